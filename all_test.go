@@ -0,0 +1,109 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cznic/ebnfutil"
+)
+
+// The test binary runs main with its arguments if $EBNF2Y_MAIN is set, see
+// ebnf2y.
+func TestMain(m *testing.M) {
+	if os.Getenv("EBNF2Y_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// ebnf2y runs the program in the demo directory and returns its output,
+// failing t if it fails.
+func ebnf2y(t *testing.T, args ...string) string {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = "demo"
+	cmd.Env = append(os.Environ(), "EBNF2Y_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("ebnf2y %v: %v\n%s", args, err, out)
+	}
+
+	return string(out)
+}
+
+// The P rules are in a reduce/reduce conflict in the state after "b" "a",
+// which yacc resolves in favor of the first one, so the rule P: 'b' 'a' is
+// never reduced unless it comes first.
+const reorderEBNF = `S = "b" P "x" | P "x" .
+P = "a" | "b" "a" .
+`
+
+// reorderYacc stands in for goyacc, reporting the outcome of the conflict of
+// reorderEBNF for the order of the P rules in the .y file.
+const reorderYacc = `#!/bin/sh
+for fn; do :; done
+echo "conflicts: 1 reduce/reduce"
+if grep -A1 '^P:' "$fn" | grep -q "^	'a'$"; then
+	echo "rule P:  'b' 'a' never reduced"
+	echo "1 rules never reduced"
+fi
+`
+
+func TestMReorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ebnf2y-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "goyacc"), []byte(reorderYacc), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "reorder.ebnf")
+	if err := ioutil.WriteFile(src, []byte(reorderEBNF), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	out := ebnf2y(t, "-start", "S", "-M", "-m-reorder", "-o", filepath.Join(dir, "reorder.y"), src)
+	if e := `[-M] Reordered "P": 'b' 'a' | 'a': rules never reduced 1 -> 0`; !strings.Contains(out, e) {
+		t.Errorf("got\n%s\nexpected %q", out, e)
+	}
+}
+
+func TestFindSuspects(t *testing.T) {
+	for _, v := range []struct {
+		src, start string
+		exp        string
+	}{
+		{`S = A "x" | B "x" . A = id . B = id . id = "i" .`, "S", "A B"},
+		// B is a left corner of A, B is reduced before A.
+		{`S = A "x" | B "y" "x" . A = B | "a" . B = id . id = "i" .`, "S", ""},
+	} {
+		grm, err := ebnfutil.Parse("test.ebnf", strings.NewReader(v.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var a []string
+		for _, s := range findSuspects(grm, newAnalysis(grm, v.start), v.start, map[string]bool{}) {
+			a = append(a, s.name)
+		}
+		if g, e := strings.Join(a, " "), v.exp; g != e {
+			t.Errorf("%s: got %q, expected %q", v.src, g, e)
+		}
+	}
+}
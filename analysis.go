@@ -0,0 +1,82 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+
+	"github.com/cznic/ebnf2y/grammar"
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// analysis adds the LL(1) and left corner checks of ebnf2y to the nullable,
+// FIRST and FOLLOW properties of a grammar.
+type analysis struct {
+	*grammar.Grammar
+	grm ebnfutil.Grammar
+}
+
+func newAnalysis(grm ebnfutil.Grammar, start string) *analysis {
+	return &analysis{grammar.New(grm, start), grm}
+}
+
+// leftCorners calls f for every non terminal which can start expr after a
+// nullable prefix and reports whether expr is nullable. The prefix passed to
+// f consists of prefix and the nullable terms of expr preceding the non
+// terminal.
+func (a *analysis) leftCorners(expr ebnf.Expression, prefix []string, f func(name string, prefix []string)) bool {
+	switch x := expr.(type) {
+	case nil:
+		return true
+	case ebnf.Alternative:
+		nullable := false
+		for _, v := range x {
+			if a.leftCorners(v, prefix, f) {
+				nullable = true
+			}
+		}
+		return nullable
+	case ebnf.Sequence:
+		for _, v := range x {
+			if !a.leftCorners(v, prefix, f) {
+				return false
+			}
+
+			prefix = append(append([]string(nil), prefix...), termString(v))
+		}
+		return true
+	case *ebnf.Group:
+		return a.leftCorners(x.Body, prefix, f)
+	case *ebnf.Option:
+		a.leftCorners(x.Body, prefix, f)
+		return true
+	case *ebnf.Repetition:
+		a.leftCorners(x.Body, prefix, f)
+		return true
+	case *ebnf.Name:
+		if ast.IsExported(x.String) {
+			f(x.String, prefix)
+			return a.Nullable(x.String)
+		}
+	}
+	return false
+}
+
+// termString returns a short representation of the nullable term expr.
+func termString(expr ebnf.Expression) string {
+	switch x := expr.(type) {
+	case *ebnf.Name:
+		return x.String
+	case *ebnf.Group:
+		return "(…)"
+	case *ebnf.Option:
+		return "[…]"
+	case *ebnf.Repetition:
+		return "{…}"
+	default:
+		return "ε"
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/exp/ebnf"
+)
+
+// fold returns the associativity of expr, a rule alternative X R where R is
+// the synthetic production of a repetition annotated by @left or @right, and
+// the name of R. It returns "", "" for the other alternatives.
+func (j *job) fold(expr ebnf.Expression) (assoc, rep string) {
+	x, ok := expr.(ebnf.Sequence)
+	if !ok || len(x) != 2 {
+		return "", ""
+	}
+
+	r, ok := x[1].(*ebnf.Name)
+	if !ok || !j.repetitions[r.String] {
+		return "", ""
+	}
+
+	o, ok := j.origins[r.String]
+	if !ok || o.kind != "repetition" {
+		return "", ""
+	}
+
+	if assoc = j.assoc[o.pos.Offset]; assoc == "" {
+		return "", ""
+	}
+
+	return assoc, r.String
+}
+
+// foldAction returns the action of expr, an alternative of the named
+// production folding the operand $1 and the operators and operands of the
+// repetition list $2 into the nested nodes of an expression tree, eg. for
+// 1 - 2 - 3 the @left [[1 - 2] - 3] or the @right [1 - [2 - 3]]. It returns
+// "" if expr is not annotated.
+func (j *job) foldAction(expr ebnf.Expression, name, start string) string {
+	assoc, rep := j.fold(expr)
+	if assoc == "" {
+		return ""
+	}
+
+	// Of the items an iteration of the repetition appends to the list.
+	k := -1
+	for _, v := range alternatives(j.grm[rep].Expr) {
+		n := len(j.fields(v)) - 1
+		switch {
+		case n < 0:
+			continue
+		case k < 0:
+			k = n
+		case n != k:
+			k = 0
+		}
+	}
+	if k < 2 {
+		log.Fatalf("%s: @%s: the iterations of the repetition must be of the same number of operators and an operand", j.origins[rep].pos, assoc)
+	}
+
+	typ, list := j.ruleName(name), j.nodeType(rep)
+	items := func(from, to string) string {
+		a := []string{"l[i]"}
+		for i := 1; i < k-1; i++ {
+			a = append(a, fmt.Sprintf("l[i+%d]", i))
+		}
+		return fmt.Sprintf("[]%s{%s, %s, %s}", typ, from, strings.Join(a, ", "), to)
+	}
+	var a []string
+	switch assoc {
+	case "left":
+		a = []string{
+			"var x interface{} = $1",
+			fmt.Sprintf("for l, i := $2.(%s), 0; i < len(l); i += %d {", list, k),
+			"\tx = " + items("x", fmt.Sprintf("l[i+%d]", k-1)),
+			"}",
+		}
+	case "right":
+		a = []string{
+			fmt.Sprintf("l := $2.(%s)", list),
+			"var x interface{} = $1",
+			"if n := len(l); n != 0 {",
+			"\tx = l[n-1]",
+			fmt.Sprintf("\tfor i := n - %d; i >= 0; i -= %d {", k, k),
+			"\t\tvar y interface{} = $1",
+			"\t\tif i != 0 {",
+			"\t\t\ty = l[i-1]",
+			"\t\t}",
+			"\t\tx = " + items("y", "x"),
+			"\t}",
+			"}",
+		}
+	}
+	a = append(a, fmt.Sprintf("%s = x", j.lhs(name, start)))
+	return strings.Join(a, "\n\t\t")
+}
@@ -0,0 +1,313 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// copyExpr returns a deep copy of expr with the offsets of its positions
+// moved by d.
+func copyExpr(expr ebnf.Expression, d int) ebnf.Expression {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		a := ebnf.Alternative{}
+		for _, v := range x {
+			a = append(a, copyExpr(v, d))
+		}
+		return a
+	case ebnf.Sequence:
+		a := ebnf.Sequence{}
+		for _, v := range x {
+			a = append(a, copyExpr(v, d))
+		}
+		return a
+	case *ebnf.Group:
+		y := *x
+		y.Lparen.Offset += d
+		y.Body = copyExpr(x.Body, d)
+		return &y
+	case *ebnf.Option:
+		y := *x
+		y.Lbrack.Offset += d
+		y.Body = copyExpr(x.Body, d)
+		return &y
+	case *ebnf.Repetition:
+		y := *x
+		y.Lbrace.Offset += d
+		y.Body = copyExpr(x.Body, d)
+		return &y
+	case *ebnf.Range:
+		return &ebnf.Range{Begin: copyExpr(x.Begin, d).(*ebnf.Token), End: copyExpr(x.End, d).(*ebnf.Token)}
+	case *ebnf.Name:
+		y := *x
+		y.StringPos.Offset += d
+		return &y
+	case *ebnf.Token:
+		y := *x
+		y.StringPos.Offset += d
+		return &y
+	case *ebnf.Bad:
+		y := *x
+		y.TokPos.Offset += d
+		return &y
+	default:
+		return expr
+	}
+}
+
+func copyProduction(prod *ebnf.Production, d int) *ebnf.Production {
+	return &ebnf.Production{Name: copyExpr(prod.Name, d).(*ebnf.Name), Expr: copyExpr(prod.Expr, d)}
+}
+
+// bisector finds a minimal set of the productions changed between two
+// versions of a grammar which gives the new version its yacc conflicts.
+type bisector struct {
+	compat      *compat
+	declareLits bool
+	errors      map[string]string // Of the new grammar.
+	levels      []*level          // Of the new grammar.
+	new         ebnfutil.Grammar
+	old         ebnfutil.Grammar // Offsets moved past the ones of new.
+	oldErrors   map[string]string
+	out         string         // The .y file yacc is run on.
+	prec        map[int]string // Of both grammars.
+	runs        int
+	skip        map[string]bool // Of the new grammar.
+	start       string
+	strict      bool
+	tPrefix     string
+	wr, ws      int
+}
+
+// loadOld reads the old grammar from file fn, like the new one of size n
+// bytes, and binds its %prec and %error directives.
+func (b *bisector) loadOld(fn, enc string, features map[string]bool, skipEllipses bool, n int) error {
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	if src, err = decode(src, enc); err != nil {
+		return err
+	}
+
+	src, ds, err := preprocess(fn, src, features)
+	if err != nil {
+		return err
+	}
+
+	if skipEllipses {
+		markEllipses(src)
+	}
+	grm, err := ebnfutil.Parse(fn, bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+
+	// The %prec bindings of both grammars share one map keyed by offset.
+	b.old = ebnfutil.Grammar{}
+	for name, prod := range grm {
+		if skipEllipses {
+			prod.Expr = removeName(prod.Expr, informal)
+		}
+		b.old[name] = copyProduction(prod, n)
+	}
+	for _, d := range ds {
+		d.pos.Offset += n
+	}
+	prec, err := bindPrec(b.old, ds)
+	if err != nil {
+		return err
+	}
+
+	for off, s := range prec {
+		b.prec[off] = s
+	}
+	b.oldErrors, err = bindErrors(b.old, ds)
+	return err
+}
+
+func prodString(prod *ebnf.Production) string {
+	return ebnfutil.Grammar{prod.Name.String: prod}.String()
+}
+
+// changes returns the sorted names of the productions added, removed or
+// modified by the new grammar.
+func (b *bisector) changes() (a []string) {
+	for name, prod := range b.new {
+		if old, ok := b.old[name]; !ok || prodString(old) != prodString(prod) {
+			a = append(a, name)
+		}
+	}
+	for name := range b.old {
+		if !has(b.new, name) {
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a)
+	return
+}
+
+// mix returns the old grammar with the named productions taken from the new
+// one, or removed if not in it, and the %error messages of its productions.
+func (b *bisector) mix(names []string) (grm ebnfutil.Grammar, msgs map[string]string) {
+	grm, msgs = ebnfutil.Grammar{}, map[string]string{}
+	for name, prod := range b.old {
+		grm[name] = copyProduction(prod, 0)
+		if s, ok := b.oldErrors[name]; ok {
+			msgs[name] = s
+		}
+	}
+	for _, name := range names {
+		delete(grm, name)
+		delete(msgs, name)
+		if prod, ok := b.new[name]; ok {
+			grm[name] = copyProduction(prod, 0)
+			if s, ok := b.errors[name]; ok {
+				msgs[name] = s
+			}
+		}
+	}
+	return
+}
+
+// conflicts returns the number of yacc conflicts of the grammar mixed from
+// the old one and the named productions of the new one, or an error if that
+// grammar is not valid.
+func (b *bisector) conflicts(names []string) (int, error) {
+	grm, msgs := b.mix(names)
+	if err := expandWildcard(grm, b.strict); err != nil {
+		return 0, err
+	}
+
+	r := reachable(grm, b.start)
+	for name := range grm {
+		if !r[name] && !ast.IsExported(name) && !b.skip[name] {
+			delete(grm, name)
+		}
+	}
+	if err := grm.Verify(b.start); err != nil {
+		return 0, err
+	}
+
+	j := &job{
+		declareLiterals: b.declareLits,
+		compat:          b.compat,
+		errors:          msgs,
+		grm:             grm,
+		names:           map[string]bool{},
+		order:           tokenOrder(grm),
+		synthetic:       map[string]string{},
+		children:        map[string][]string{},
+		skip:            b.skip,
+		sortTokens:      "source",
+		levels:          b.levels,
+		prec:            b.prec,
+		tPrefix:         b.tPrefix,
+	}
+	for _, name := range keywords {
+		j.names[name] = true
+	}
+	for name := range grm {
+		j.names[name] = true
+	}
+	start := j.inventName("Start", "")
+	j.grm[start] = &ebnf.Production{
+		Name: &ebnf.Name{String: start},
+		Expr: &ebnf.Name{String: b.start},
+	}
+	j.toBnf(b.start)
+	j.checkTerminals(start)
+
+	f, err := os.Create(b.out)
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(f)
+	err = j.render(w, start)
+	if err2 := w.Flush(); err == nil {
+		err = err2
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	b.runs++
+	return score(b.compat, "", b.out, b.wr, b.ws), nil
+}
+
+// run writes to w a minimal set of the changed productions which, taken from
+// the new grammar, give the old one at least the yacc conflicts of the new
+// one. The productions are removed one by one from the set of all changes,
+// keeping the removals which preserve the conflicts.
+func (b *bisector) run(w io.Writer) error {
+	all := b.changes()
+	if len(all) == 0 {
+		return errors.New("-bisect: the grammars do not differ")
+	}
+
+	base, err := b.conflicts(nil)
+	if err != nil {
+		return fmt.Errorf("-bisect: old grammar: %v", err)
+	}
+
+	n, err := b.conflicts(all)
+	if err != nil {
+		return fmt.Errorf("-bisect: new grammar: %v", err)
+	}
+
+	if n <= base {
+		return fmt.Errorf("-bisect: no new conflicts, old %d, new %d", base, n)
+	}
+
+	culprits := append([]string(nil), all...)
+	for _, name := range all {
+		var a []string
+		for _, v := range culprits {
+			if v != name {
+				a = append(a, v)
+			}
+		}
+		if m, err := b.conflicts(a); err == nil && m >= n {
+			culprits = a
+		}
+	}
+	// Leave the .y file of the culprits behind.
+	if _, err = b.conflicts(culprits); err != nil {
+		return err
+	}
+
+	sw := &stickyWriter{w: w}
+	fmt.Fprintf(sw, "conflicts: old %d, new %d; %d of %d changed productions, %d yacc runs\n", base, n, len(culprits), len(all), b.runs)
+	for _, name := range culprits {
+		prod, ok := b.new[name]
+		kind := "changed"
+		switch {
+		case !ok:
+			prod, kind = b.old[name], "removed"
+		case !has(b.old, name):
+			kind = "added"
+		}
+		pos := prod.Pos()
+		fmt.Fprintf(sw, "%s\t%s\t%s:%d\n", name, kind, relPath(pos.Filename), pos.Line)
+	}
+	return sw.err
+}
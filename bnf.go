@@ -0,0 +1,95 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+const epsilon = "ε"
+
+// bnfStr returns the right hand side of a BNF rule.
+func (j *job) bnfStr(expr ebnf.Expression) string {
+	switch x := expr.(type) {
+	case nil:
+		return epsilon
+	case *ebnf.Name:
+		if ast.IsExported(x.String) {
+			return j.ruleName(x.String)
+		}
+
+		return x.String
+	case ebnf.Sequence:
+		a := []string{}
+		for _, v := range x {
+			a = append(a, j.bnfStr(v))
+		}
+		return strings.Join(a, " ")
+	case *ebnf.Token:
+		return strconv.Quote(x.String)
+	default:
+		log.Fatalf("%T(%#v)", x, x)
+		panic("unreachable")
+	}
+}
+
+// bnfOrder returns the lowered non terminal productions, the production top
+// first, the others sorted. The synthetic production start is left out.
+func (j *job) bnfOrder(start, top string) []string {
+	j.checkTerminals(start)
+	a := []string{top}
+	for name := range j.rep.NonTerminals {
+		if name != start && name != top {
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a[1:])
+	return a
+}
+
+// renderBNF writes the lowered non terminal productions as plain BNF, the
+// production top first. The synthetic production start is left out.
+// Lexical tokens are written as their names, literals quoted.
+func (j *job) renderBNF(w io.Writer, start, top string) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	for _, name := range j.bnfOrder(start, top) {
+		f.Format("%s ::= ", j.ruleName(name))
+		switch x := j.grm[name].Expr.(type) {
+		case ebnf.Alternative:
+			for i, v := range x {
+				if i != 0 {
+					f.Format("\n\t| ")
+				}
+				f.Format("%s", j.bnfStr(v))
+			}
+		default:
+			f.Format("%s", j.bnfStr(x))
+		}
+		f.Format("\n\n")
+	}
+	return sw.err
+}
+
+// renderCFG writes the lowered non terminal productions as a context free
+// grammar of a "LHS -> RHS" rule per alternative, like renderBNF.
+func (j *job) renderCFG(w io.Writer, start, top string) error {
+	sw := &stickyWriter{w: w}
+	for _, name := range j.bnfOrder(start, top) {
+		for _, v := range alternatives(j.grm[name].Expr) {
+			fmt.Fprintf(sw, "%s -> %s\n", j.ruleName(name), j.bnfStr(v))
+		}
+	}
+	return sw.err
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// reTodoComment matches a trailing comment of a generated line.
+var reTodoComment = regexp.MustCompile(`[ \t]*(//|/\*)` + todo + `.*$`)
+
+// compactWriter copies its input to w, leaving out the blank lines and the
+// comments but for the //line, //go: and ebnf2y: ones.
+type compactWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (c *compactWriter) Write(b []byte) (n int, err error) {
+	c.buf = append(c.buf, b...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err = c.line(c.buf[:i]); err != nil {
+			return
+		}
+
+		c.buf = c.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (c *compactWriter) line(b []byte) (err error) {
+	s := bytes.TrimSpace(b)
+	switch {
+	case bytes.HasPrefix(s, []byte("//line ")) || bytes.HasPrefix(s, []byte("//go:")) || bytes.Contains(s, []byte("ebnf2y:")):
+		// keep
+	case len(s) == 0,
+		bytes.HasPrefix(s, []byte("//")),
+		bytes.HasPrefix(s, []byte("/*")) && bytes.HasSuffix(s, []byte("*/")):
+		return
+	default:
+		b = reTodoComment.ReplaceAll(b, nil)
+	}
+	_, err = c.w.Write(append(b, '\n'))
+	return
+}
+
+// Close writes any pending partial line.
+func (c *compactWriter) Close() (err error) {
+	if len(c.buf) != 0 {
+		err = c.line(c.buf)
+		c.buf = nil
+	}
+	return
+}
@@ -0,0 +1,231 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// configFiles are the config files looked for in the working directory if
+// -config is blank.
+var configFiles = []string{".ebnf2y.toml", ".ebnf2y.json"}
+
+// configValue is the value of a key of a config file.
+type configValue struct {
+	pos scanner.Position
+	s   string
+}
+
+// findConfig returns the config file named by fn or, if fn is blank, the
+// first of configFiles found in the working directory, "" if none.
+func findConfig(fn string) (string, error) {
+	if fn != "" {
+		return fn, nil
+	}
+
+	for _, v := range configFiles {
+		switch _, err := os.Stat(v); {
+		case err == nil:
+			return v, nil
+		case !os.IsNotExist(err):
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// parseTOML returns the key = value pairs of src, a TOML file of top level
+// keys. The values are strings, booleans, numbers and arrays of strings,
+// joined by commas.
+func parseTOML(fn string, src []byte) (m map[string]configValue, err error) {
+	m = map[string]configValue{}
+	sc := bufio.NewScanner(strings.NewReader(string(src)))
+	for line := 1; sc.Scan(); line++ {
+		pos := scanner.Position{Filename: fn, Line: line, Column: 1}
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+
+		if s[0] == '[' {
+			return nil, fmt.Errorf("%s: tables are not supported, the keys must be top level ones", pos)
+		}
+
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s: expected key = value", pos)
+		}
+
+		key := strings.TrimSpace(s[:i])
+		if k, err := strconv.Unquote(key); err == nil {
+			key = k
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("%s: duplicate key %s", pos, key)
+		}
+
+		v, err := tomlValue(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", pos, key, err)
+		}
+
+		m[key] = configValue{pos, v}
+	}
+	return m, sc.Err()
+}
+
+// tomlValue returns the value s, possibly followed by a comment, as the
+// argument of a flag.
+func tomlValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	case s[0] == '"' || s[0] == '\'':
+		q := s[0]
+		i := 1
+		for ; i < len(s) && s[i] != q; i++ {
+			if s[i] == '\\' && q == '"' {
+				i++
+			}
+		}
+		if i >= len(s) {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		if rest := strings.TrimSpace(s[i+1:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %s after the value", rest)
+		}
+
+		if q == '\'' {
+			return s[1:i], nil
+		}
+
+		return strconv.Unquote(s[:i+1])
+	case s[0] == '[':
+		i := strings.LastIndex(s, "]")
+		if i < 0 {
+			return "", fmt.Errorf("unterminated array")
+		}
+
+		var a []string
+		for _, v := range strings.Split(s[1:i], ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+
+			x, err := tomlValue(v)
+			if err != nil {
+				return "", err
+			}
+
+			a = append(a, x)
+		}
+		return strings.Join(a, ","), nil
+	}
+
+	if i := strings.Index(s, "#"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// parseJSON returns the members of src, a JSON object. The values are
+// strings, booleans, numbers and arrays of strings, joined by commas.
+func parseJSON(fn string, src []byte) (m map[string]configValue, err error) {
+	var o map[string]interface{}
+	if err = json.Unmarshal(src, &o); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+
+	m = map[string]configValue{}
+	pos := scanner.Position{Filename: fn}
+	for key, v := range o {
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = x
+		case bool:
+			s = strconv.FormatBool(x)
+		case float64:
+			s = strconv.FormatFloat(x, 'f', -1, 64)
+		case []interface{}:
+			var a []string
+			for _, e := range x {
+				t, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s: the array items must be strings", fn, key)
+				}
+
+				a = append(a, t)
+			}
+			s = strings.Join(a, ",")
+		default:
+			return nil, fmt.Errorf("%s: %s: unsupported value %v", fn, key, v)
+		}
+		m[key] = configValue{pos, s}
+	}
+	return
+}
+
+// loadConfig sets the flags not given on the command line to the values of
+// the config file fn, a .json file or else a TOML one. The keys are the flag
+// names. The unknown keys are reported as warnings.
+func loadConfig(fn string) error {
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	var m map[string]configValue
+	switch filepath.Ext(fn) {
+	case ".json":
+		m, err = parseJSON(fn, src)
+	default:
+		m, err = parseTOML(fn, src)
+	}
+	if err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := m[key]
+		if flag.Lookup(key) == nil || key == "config" {
+			switch {
+			case v.pos.IsValid():
+				warn(v.pos, "unknown config key %s", key)
+			default:
+				warn(v.pos, "%s: unknown config key %s", fn, key)
+			}
+			continue
+		}
+
+		if set[key] {
+			// The command line wins.
+			continue
+		}
+
+		if err := flag.Set(key, v.s); err != nil {
+			return fmt.Errorf("%s: %s: %v", fn, key, err)
+		}
+	}
+	return nil
+}
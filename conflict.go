@@ -0,0 +1,237 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cznic/strutil"
+)
+
+var (
+	reConflict = regexp.MustCompile(`^\s*(\d+): (shift/reduce|reduce/reduce) conflict`)
+	reItem     = regexp.MustCompile(`^\t([$A-Za-z_][$A-Za-z0-9_]*): `)
+	reState    = regexp.MustCompile(`^state (\d+)`)
+)
+
+// conflicts are the conflicts of a yacc verbose report.
+type conflicts struct {
+	edges map[[2]string][]int       // Pair of rules: states of their conflicts.
+	rules map[string]map[string]int // Rule: kind of conflict: count.
+}
+
+// parseConflicts returns the conflicts found in s, the verbose report of a
+// parser generator run. The rules of the kernel items of a state with
+// conflicts participate in all of its conflicts.
+func parseConflicts(s string) *conflicts {
+	kinds := map[int]map[string]int{}
+	items := map[int]map[string]bool{}
+	state := -1
+	for _, line := range strings.Split(s, "\n") {
+		if m := reConflict.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if kinds[n] == nil {
+				kinds[n] = map[string]int{}
+			}
+			kinds[n][m[2]]++
+			continue
+		}
+
+		if m := reState.FindStringSubmatch(line); m != nil {
+			state, _ = strconv.Atoi(m[1])
+			items[state] = map[string]bool{}
+			continue
+		}
+
+		if m := reItem.FindStringSubmatch(line); m != nil && state >= 0 && !strings.HasPrefix(m[1], "$") {
+			items[state][m[1]] = true
+		}
+	}
+
+	c := &conflicts{map[[2]string][]int{}, map[string]map[string]int{}}
+	var states []int
+	for n := range kinds {
+		states = append(states, n)
+	}
+	sort.Ints(states)
+	for _, n := range states {
+		var rules []string
+		for name := range items[n] {
+			rules = append(rules, name)
+		}
+		sort.Strings(rules)
+		for i, name := range rules {
+			if c.rules[name] == nil {
+				c.rules[name] = map[string]int{}
+			}
+			for kind, k := range kinds[n] {
+				c.rules[name][kind] += k
+			}
+			for _, other := range rules[i+1:] {
+				e := [2]string{name, other}
+				c.edges[e] = append(c.edges[e], n)
+			}
+		}
+	}
+	return c
+}
+
+// renderConflictDot writes c as an undirected DOT graph. The nodes are the
+// rules participating in conflicts, labeled by the numbers of their
+// conflicts. The edges connect the rules competing in the same states,
+// labeled by the states.
+func renderConflictDot(w io.Writer, c *conflicts) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	f.Format("graph conflicts {%i\nnode [shape=box];\n")
+	var rules []string
+	for name := range c.rules {
+		rules = append(rules, name)
+	}
+	sort.Strings(rules)
+	for _, name := range rules {
+		var a []string
+		for _, kind := range []string{"shift/reduce", "reduce/reduce"} {
+			if n := c.rules[name][kind]; n != 0 {
+				a = append(a, fmt.Sprintf("%d %s", n, kind))
+			}
+		}
+		f.Format("%q [label=%q];\n", name, name+"\n"+strings.Join(a, ", "))
+	}
+	var edges [][2]string
+	for e := range c.edges {
+		edges = append(edges, e)
+	}
+	sort.Sort(pairs(edges))
+	for _, e := range edges {
+		var a []string
+		for _, n := range c.edges[e] {
+			a = append(a, strconv.Itoa(n))
+		}
+		f.Format("%q -- %q [label=%q];\n", e[0], e[1], "state "+strings.Join(a, ", "))
+	}
+	f.Format("%u}\n")
+	return sw.err
+}
+
+type pairs [][2]string
+
+func (p pairs) Len() int { return len(p) }
+func (p pairs) Less(i, j int) bool {
+	return p[i][0] < p[j][0] || p[i][0] == p[j][0] && p[i][1] < p[j][1]
+}
+func (p pairs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// yaccConflict is a conflict listed by a yacc verbose report.
+type yaccConflict struct {
+	state int
+	text  string
+}
+
+// reportStates returns the lines of the state descriptions in s, the verbose
+// report of a parser generator run, and its conflicts in the order listed.
+func reportStates(s string) (states map[int][]string, list []yaccConflict) {
+	states = map[int][]string{}
+	state := -1
+	for _, line := range strings.Split(s, "\n") {
+		if m := reConflict.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			list = append(list, yaccConflict{n, strings.TrimSpace(line)})
+			state = -1
+			continue
+		}
+
+		if m := reState.FindStringSubmatch(line); m != nil {
+			state, _ = strconv.Atoi(m[1])
+		}
+		if state >= 0 {
+			states[state] = append(states[state], strings.TrimRight(line, " "))
+		}
+	}
+	for n, a := range states {
+		for len(a) != 0 && a[len(a)-1] == "" {
+			a = a[:len(a)-1]
+		}
+		states[n] = a
+	}
+	return
+}
+
+// explainConflict writes to w the state of the n-th conflict, counting from
+// 1, of report, the verbose report of the parser generator run on the .y
+// file last rendered. The rules and tokens of the state are mapped back to
+// the grammar.
+func (j *job) explainConflict(w io.Writer, report string, n int) error {
+	states, list := reportStates(report)
+	if n < 1 || n > len(list) {
+		return fmt.Errorf("-explain-conflict: no conflict %d, there are %d", n, len(list))
+	}
+
+	c := list[n-1]
+	sw := &stickyWriter{w: w}
+	fmt.Fprintf(sw, "conflict %d of %d: %s\n\n", n, len(list), c.text)
+	for _, line := range states[c.state] {
+		fmt.Fprintf(sw, "%s\n", line)
+	}
+
+	rules := map[string]string{}
+	for name := range j.grm {
+		if ast.IsExported(name) {
+			rules[j.ruleName(name)] = name
+		}
+	}
+	tokens := map[string]string{}
+	for term, name := range j.term2name {
+		tokens[name] = term
+	}
+	var where []string
+	seen := map[string]bool{}
+	for _, line := range states[c.state] {
+		for _, s := range strings.FieldsFunc(line, func(r rune) bool {
+			return !(r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r))
+		}) {
+			if seen[s] {
+				continue
+			}
+
+			seen[s] = true
+			name, ok := rules[s]
+			switch {
+			case ok:
+				if o, ok := j.origins[name]; ok {
+					where = append(where, fmt.Sprintf("%s: from %s in %s (%s:%d)", s, o.kind, j.ruleName(o.in), relPath(o.pos.Filename), o.pos.Line))
+					break
+				}
+
+				if pos := j.grm[name].Pos(); pos.IsValid() {
+					where = append(where, fmt.Sprintf("%s: %s (%s:%d)", s, name, relPath(pos.Filename), pos.Line))
+				}
+			default:
+				if term, ok := tokens[s]; ok {
+					if _, ok := j.rep.Tokens[term]; ok {
+						where = append(where, fmt.Sprintf("%s: token %s", s, term))
+						break
+					}
+
+					where = append(where, fmt.Sprintf("%s: %q", s, term))
+				}
+			}
+		}
+	}
+	if len(where) != 0 {
+		fmt.Fprintf(sw, "\n")
+		for _, s := range where {
+			fmt.Fprintf(sw, "%s\n", s)
+		}
+	}
+	return sw.err
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+)
+
+// coverage returns the non terminal productions of grm and those of them
+// listed in the named log, a list of production names separated by white
+// space. Names not found in grm are ignored.
+func coverage(grm ebnfutil.Grammar, fn string) (all, covered []string, err error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, name := range strings.Fields(string(b)) {
+		seen[name] = true
+	}
+	for name := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		all = append(all, name)
+		if seen[name] {
+			covered = append(covered, name)
+		}
+	}
+	sort.Strings(all)
+	sort.Strings(covered)
+	return
+}
+
+// writeCoverage writes to w the percentage of the productions in all which
+// are covered followed by the list of the uncovered ones.
+func writeCoverage(w io.Writer, all, covered []string) (err error) {
+	pct := 100.0
+	if len(all) != 0 {
+		pct = 100 * float64(len(covered)) / float64(len(all))
+	}
+	if _, err = fmt.Fprintf(w, "coverage: %.1f%% of %d productions\n", pct, len(all)); err != nil {
+		return
+	}
+
+	m := map[string]bool{}
+	for _, name := range covered {
+		m[name] = true
+	}
+	for _, name := range all {
+		if !m[name] {
+			if _, err = fmt.Fprintf(w, "\t%s\n", name); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
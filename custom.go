@@ -0,0 +1,171 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	beginCustom = "// ebnf2y:begin-custom"
+	endCustom   = "// ebnf2y:end-custom"
+)
+
+// customKey returns the region key of a begin marker line and whether line
+// is a begin marker.
+func customKey(line []byte) (string, bool) {
+	s := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(s, []byte(beginCustom)) {
+		return "", false
+	}
+
+	return string(bytes.TrimSpace(s[len(beginCustom):])), true
+}
+
+func isEndCustom(line []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(line), []byte(endCustom))
+}
+
+// loadCustom returns the contents of the custom regions found in the named
+// file, keyed by the text following the begin marker. A non existent file
+// has no custom regions, and neither has anything else than a regular file,
+// eg. a pipe.
+func loadCustom(fn string) (m map[string][]byte, err error) {
+	fi, err := os.Stat(fn)
+	if err != nil || !fi.Mode().IsRegular() {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	m = map[string][]byte{}
+	r := bufio.NewReader(f)
+	key, in, line := "", false, 0
+	var buf bytes.Buffer
+	for {
+		b, err := r.ReadBytes('\n')
+		if len(b) != 0 {
+			line++
+			switch k, ok := customKey(b); {
+			case ok && in:
+				return nil, fmt.Errorf("%s:%d: nested custom region", fn, line)
+			case ok:
+				if _, ok := m[k]; ok {
+					return nil, fmt.Errorf("%s:%d: duplicate custom region %q", fn, line, k)
+				}
+
+				key, in = k, true
+				buf.Reset()
+			case isEndCustom(b) && in:
+				m[key] = append([]byte(nil), buf.Bytes()...)
+				in = false
+			case isEndCustom(b):
+				return nil, fmt.Errorf("%s:%d: unexpected %s", fn, line, endCustom)
+			case in:
+				buf.Write(b)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	if in {
+		return nil, fmt.Errorf("%s: unterminated custom region %q", fn, key)
+	}
+
+	return m, nil
+}
+
+// customWriter copies its input to w, replacing the contents of every custom
+// region found in regions by the saved one.
+type customWriter struct {
+	w       io.Writer
+	regions map[string][]byte
+	used    map[string]bool
+	buf     []byte
+	skip    bool
+}
+
+func newCustomWriter(w io.Writer, regions map[string][]byte) *customWriter {
+	return &customWriter{w: w, regions: regions, used: map[string]bool{}}
+}
+
+func (c *customWriter) Write(b []byte) (n int, err error) {
+	c.buf = append(c.buf, b...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err = c.line(c.buf[:i+1]); err != nil {
+			return
+		}
+
+		c.buf = c.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (c *customWriter) line(b []byte) (err error) {
+	if c.skip {
+		if !isEndCustom(b) {
+			return
+		}
+
+		c.skip = false
+	}
+
+	if _, err = c.w.Write(b); err != nil {
+		return
+	}
+
+	if key, ok := customKey(b); ok {
+		if saved, ok := c.regions[key]; ok {
+			c.used[key] = true
+			c.skip = true
+			_, err = c.w.Write(saved)
+		}
+	}
+	return
+}
+
+// Close writes any pending partial line.
+func (c *customWriter) Close() (err error) {
+	if len(c.buf) != 0 {
+		err = c.line(c.buf)
+		c.buf = nil
+	}
+	return
+}
+
+// dropped returns the sorted keys of the saved regions for which there was no
+// place in the output.
+func (c *customWriter) dropped() (a []string) {
+	for key := range c.regions {
+		if !c.used[key] {
+			a = append(a, key)
+		}
+	}
+	sort.Strings(a)
+	return
+}
@@ -16,29 +16,713 @@ Usage:
 
 Options:
 
+	-accept tokens	Report whether <tokens>, literals or names of lexical
+			  productions separated by white space, are derived
+			  from the start production, and exit. A literal may
+			  be quoted, eg. "+". On success yes and the parse
+			  tree are written to stdout, a production per line
+			  indented by its nesting level, for an ambiguous
+			  input up to 10 of the parses. Otherwise no is
+			  written and the exit status is 1. The exhaustive
+			  backtracking search takes exponential time in the
+			  worst case.
+	-accept-depth number
+			Bound of the production nesting of -accept and -repl.
+			  The parses nested deeper are not found and the
+			  answer is said to be incomplete. Default 1000.
+	-alt-rules	Give every alternative of a non terminal production
+			  of the grammar a rule of its own, named like
+			  OperandAlt2 for the second one of Operand, which
+			  then chooses among them. The value of such a rule
+			  is always a node of its own type, eg.
+			  []OperandAlt2{$1, "(", $3, ")"}, also for a single
+			  item, one type per alternative for the visitors.
+			  The names are synthetic, -rename-synthetic can
+			  label the alternatives, eg. OperandAlt2=Call.
+			  The rules are used once, -iy 1 inlines them back.
+	-ast-schema name
+			Write to <name> a JSON description of the AST built by
+			  the actions of the .y file, for tools written in
+			  other languages. The object has the root node type,
+			  the value of the start production, and the nodes,
+			  one per non terminal, synthetic ones included, with
+			  the EBNF production it was derived from, the
+			  construct, if synthetic, its kind, node or list,
+			  and the value of each alternative: nil, the single
+			  field (item), the slice of the fields or, for a
+			  list, the fields appended to it. A field is a token,
+			  with its literal if any, or a node, optional (may be
+			  nil) or list of the node type.
+	-ast-stringer name
+			Write to <name> a Go file with the function
+			  astString(n interface{}) string, returning the
+			  indented tree dump of the AST node n in the format
+			  of the demo output below, nil items left out. The
+			  node types are the non terminals declared by the .y
+			  file as interface{} types, so the function uses a
+			  type switch instead of String methods.
+	-bisect name	Find which of the productions changed from the old
+			  grammar in file <name> to the input grammar give the
+			  latter its new yacc conflicts. The changed
+			  productions are taken from the input grammar one set
+			  at a time, the others from the old one, and the
+			  parser generator is run on the result written to the
+			  file named by -o. Changes keeping the number of
+			  conflicts when left out are dropped. The remaining
+			  ones are written to stdout, the -o file is left
+			  with their grammar. The precedence levels and %skip
+			  tokens are those of the input grammar. No other
+			  output is generated.
+	-check-ll1	Warn about every LL(1) conflict of the grammar: the
+			  alternatives starting with the same terminals
+			  (FIRST/FIRST), the options, repetitions and nullable
+			  alternatives starting with terminals which can also
+			  follow them (FIRST/FOLLOW) and, as by
+			  -warn-left-recursion, the left recursive
+			  productions. The warnings name the production and
+			  the terminals in conflict. Use -Werror to fail.
+	-collapse-chains
+			Replace the references to every non terminal
+			  production consisting of a single name, eg.
+			  A = B . B = C . C = "x" ., by the reference to the
+			  end of its chain, C, and remove the production. The
+			  start production and those listed by %noinline or
+			  having a %error or %prec directive are kept, their
+			  references are replaced as well. The collapsed
+			  chains are reported to stderr.
+	-comment-syntax name
+			The comments skipped by the -lexer skeleton, besides
+			  the white space:
+			  none: no comments (default)
+			  c: from /* to the next star slash, without nesting
+			  cpp: the C ones and // to the end of the line
+			  shell: # to the end of the line
+			  lua: --[[ comment ]] and -- to the end of the line
+			  re:pattern: the comments matched by the golex
+			    pattern, eg. re:";".* for Lisp.
+			Only the lexer outputs are affected.
+	-compact	Leave the comments, but for the //line, //go: and
+			  custom region ones, and the blank lines out of the
+			  .y file.
+	-compat name	Select the parser generator the output is intended for:
+			  goyacc-modern: golang.org/x/tools/cmd/goyacc (default)
+			  goyacc: the former '$ go tool yacc'
+			  bison: GNU bison, which checks only the grammar,
+			    the Go code is not compiled. It is the only one
+			    supporting %precedence.
+			The generator is also the one run by -m.
+	-config name	Read the flags not given on the command line from the
+			  config file <name>. If blank, .ebnf2y.toml or else
+			  .ebnf2y.json is read from the working directory, if
+			  any. The keys are the flag names, the command line
+			  flags override them. A .json file is an object, any
+			  other a TOML file of top level key = value lines,
+			  eg.
+
+				start = "Expression"
+				target = "bnf"
+				compact = true
+				iy = 1
+				features = ["debug", "trace"]
+
+			  The values are strings, booleans, numbers and arrays
+			  of strings, passed joined by commas. An unknown key
+			  is a warning, see -Werror.
+	-conflict-dot name
+			Run the parser generator on the final output file, which
+			  must be named by -o, and write to <name> the rules
+			  of its conflicts as an undirected DOT graph. The
+			  nodes are the rules of the kernel items of the states
+			  with conflicts, labeled by the numbers of their
+			  shift/reduce and reduce/reduce conflicts. The edges
+			  connect the rules of the same states, labeled by
+			  the state numbers. Not supported by -compat bison.
+	-coverage name	Read <name>, a log of the productions reduced by the
+			  generated parser, eg. when running tests, as names
+			  separated by white space. Then write to stdout the
+			  percentage of the non terminal productions found in
+			  the log and the list of those not found, and exit.
+			  The names are those of the grammar, other names are
+			  ignored.
+	-custom		Emit custom region markers around every action, the
+			  prologue and the epilogue, see Custom regions below.
+	-dedup-tokens	Replace every lexical production defining the same
+			  literal as one declared before it by the first one.
+			  Such productions are reported as warnings also
+			  without -dedup-tokens.
+	-ellipsis-informal policy
+			Select how a … not forming a range, ie. not between two
+			  literals, is handled:
+			  error: it is a syntax error (default).
+			  skip: it is ignored, like in the informal
+			    enumerations of the specifications. Alternatives,
+			    groups, options and repetitions left empty are
+			    removed.
+	-emit-comments-as-actions
+			Copy the doc comment of every production, the block of
+			  // comment lines immediately preceding it, to the
+			  .y file, above its yacc rules and above its node
+			  type.
+	-encoding name	Select the encoding of the input, decoded to UTF-8
+			  before anything else. The positions in messages are
+			  those of the decoded text.
+			  utf-8: (default) A leading byte order mark is
+			    removed.
+			  utf-16: big endian unless the input starts with a
+			    little endian byte order mark.
+			  utf-16be, utf-16le: big or little endian.
+			  latin-1: ISO 8859-1.
+	-error-verbose	Emit %error-verbose. Requires -compat goyacc-modern.
+	-features list	Comma separated list of the features whose %if blocks are
+			  included, eg. "generics,async". Default blank.
+	-first-comments	Precede the rules of every production of the .y file by
+			  a comment listing its FIRST set, eg. FIRST: "("
+			  IDENT "-", the tokens by their yacc names, the
+			  literals quoted, and ε if the production is
+			  nullable. An aid for resolving conflicts by hand.
+	-follow-at tokens
+			Write to stdout, as a JSON list, the terminals which can
+			  follow <tokens>, a prefix given like for -accept,
+			  and exit. Literals are listed quoted, lexical
+			  tokens by their names and $end if <tokens> is a
+			  complete input, eg. ["\")\"", "\"+\"", "$end"]. The
+			  prefix is run through the LALR(1) parser of
+			  -table, its conflicts resolved like by yacc. At the
+			  first token which cannot follow the preceding ones
+			  the list is of the terminals expected there, the
+			  token is reported to stderr and the exit status is
+			  1. An empty <tokens> lists the terminals starting
+			  the input.
+	-full-go	Emit a %union field, named and typed as the production,
+			  for every non terminal and declare the non terminals
+			  using the %type <field> form. The node types are
+			  kept when the demo stuff is removed.
+	-explain name	Write to stdout how production <name> is lowered: its
+			  EBNF, its nullable, FIRST and FOLLOW properties, the
+			  EBNF after -ie, the BNF including all the synthetic
+			  productions derived from it, the BNF after -iy and
+			  the resulting yacc rules. No output file is
+			  generated.
+	-explain-conflict n
+			Run the parser generator on the final output file, which
+			  must be named by -o, and write to stdout the state of
+			  its n-th conflict, counting from 1, as listed by its
+			  verbose report. The rules and tokens of the state
+			  are followed by the EBNF productions and terms they
+			  were generated from.
+	-explain-inline	Report to stderr the decisions of the inlining steps:
+			  -ie, the %inline directives, -inline-conflicts,
+			  -iy and -m. A line per production considered by a
+			  step gives its use count and number of terms before
+			  the step and whether it was inlined or kept, with
+			  the governing rule, eg.
+
+				-ie 1: QualifiedIdent: used 2 time(s), 3 term(s): kept (used more than once)
+				-iy 2: Term1: used 2 time(s), 3 term(s): kept (recursive)
+
+			  The rules are used-once and all of the levels 1 and
+			  2, %inline, reduce/reduce suspect and magic-chosen,
+			  with the conflicts before and after for -m. A
+			  production the rule applies to may still be left by
+			  the eligibility checks of ebnfutil, reported as eg.
+			  kept (used-once applies, not inlined by ebnfutil).
+			  The uses count the references of a production to
+			  itself.
+	-fuzz name	Write a Go fuzz test of the generated parser to <name>.
+			  The test passes arbitrary strings to yyParse through
+			  the lexer returned by the -fuzz-lexer function.
+	-fuzz-lexer name
+			Name of the func(string) yyLexer used by -fuzz.
+			  Default "newLexer", as in the demo.
+	-gogenerate name
+			Write the //go:generate directive running ebnf2y with
+			  the flags and the input file of this invocation to
+			  stderr, if <name> is "-", or into the Go file
+			  <name>. The first such directive found in the file
+			  is replaced, or a new one is inserted after the
+			  package clause. File names are kept as given.
 	-ie number	Inline eligible EBNF productions:
 			  0: none (default)
 			  1: used once
 			  2: all (cannot be used with -m)
+	-inline-candidates
+			Write to stdout the non terminal productions which can
+			  be inlined, but for the start production, the ones
+			  not used and the ones referring to themselves, and
+			  exit. Nothing is inlined. A line gives a production,
+			  the number of references to it (refs), the number
+			  of terminals and non terminals of its expression
+			  (size) and the growth of the grammar inlining it
+			  would cause, (refs-1)*size. The productions the
+			  FIRST/FOLLOW analysis of -inline-conflicts finds
+			  likely to cause reduce/reduce conflicts come first,
+			  with the competing production, then the others,
+			  the smallest growth first. Ties are broken by name.
+	-inline-conflicts
+			Inline, after -ie, the pairs of productions likely to
+			  cause reduce/reduce conflicts, as found by the
+			  FIRST/FOLLOW analysis: productions starting,
+			  directly or indirectly, different alternatives of
+			  one production, with intersecting FIRST sets and
+			  intersecting FOLLOW sets. Every inlined production
+			  is reported to stderr with the reason, eg.
+			  "inlined B because it caused a reduce/reduce between
+			  B and C in A on "x"". A heuristic, targeted and fast
+			  alternative to -m, which can still be used after it.
+	-inline-terminals
+			Replace every reference to a lexical production being a
+			  single literal, eg. lsh = "<<" ., by the literal and
+			  remove the production. The literal is declared as a
+			  token like the other ones. The %skip productions and
+			  those with a %state are kept. The replaced
+			  productions are reported to stderr.
 	-iy number	Inline eligible BNF (.y) productions:
 			  0: none (default)
 			  1: used once
 			  2: all (cannot be used with -m)
+	-keep-synthetic-comments
+			Precede the rules of every synthetic production by a
+			  comment naming the EBNF construct it was created
+			  for and its position, eg. "from repetition in Term
+			  (grammar.ebnf:42)".
+	-keep-whitespace-tokens
+			Let the lexer written by -lexer skip only the %skip
+			  tokens. Without any, it skips white space by
+			  default. See White space below.
+	-keywords	Emit after the rules a map of the keywords, the
+			  literals consisting of letters, digits and
+			  underscores, to their tokens and the function
+			  LookupKeyword(s string) (int, bool), for the lexers
+			  scanning keywords as identifiers.
+	-lexer name	Write to <name> a golex[4] skeleton of a lexer for the
+			  generated parser. Lexical productions are translated
+			  to golex definitions, an empty one is given its
+			  name as the pattern. There is a rule for every
+			  token, %skip production and declared literal. See
+			  also %states below.
+	-lexer-interface type[,lex[,error]]
+			Adapt an existing lexer of Go type <type> to the
+			  yyLexer interface of the parser. The prologue
+			  gets a lexerAdapter type whose Lex and Error
+			  methods call the <lex> and <error> methods of the
+			  lexer, by default Lex and Error. Pass
+			  lexerAdapter{l} to yyParse. The code written by
+			  -fuzz and -samples-test does so as well.
+	-literals policy
+			Select how literals are written to the yacc rules:
+			  inline: single byte literals are written as a
+			    character literal, eg. '+' (default).
+			  declared: single byte literals are declared as
+			    tokens as well.
+			Other literals are always declared. A literal having
+			  ASCII letters, digits or underscores is declared
+			  under those upper cased, eg. "func" as FUNC. Any
+			  other is declared as TOK<n>, eg. "&&" as TOK1, with
+			  a comment showing the literal.
 	-m		Magic: Attempt to to minimize yacc conflicts,
 			  by finding a minimum of wr*RR+ws*SR
 			  (wr*reducereduce+ws*shiftreduce conflicts).
 	-M		Like -m and write report to stderr.
-	-o name		Output file name. Stdout if left blank (default).
+	-m-max number	Maximum number of parser generator runs of the -m search.
+			  0: unlimited (default)
+	-m-reorder	Let -m also try moving every alternative of every
+			  production to the front. The conflicts do not depend
+			  on the order, but the rule reduced in a reduce/reduce
+			  conflict does: the reorderings reducing the number of
+			  rules never reduced are kept and reported by -M.
+	-metrics-prom name
+			Run the parser generator on the final output file, which
+			  must be named by -o, and write to <name> the gauges
+			  ebnf2y_productions, ebnf2y_recursion_depth, the
+			  longest chain of references between non terminal
+			  productions, a cycle counted once, ebnf2y_tokens and
+			  ebnf2y_conflicts, by kind, in the Prometheus text
+			  exposition format, labelled by the grammar file name.
+			  Requires -target yacc.
+	-mutants dir	Write test inputs of the parser to the subdirectories
+			  accept and reject of <dir> and exit: the -samples
+			  sentences, 10 if -samples is not set, to accept, as
+			  NNN.txt, and three minimal mutations of each,
+			  NNN-drop.txt, NNN-dup.txt and NNN-swap.txt, a token
+			  left out, repeated or swapped with the next one.
+			  A mutation is written to accept if the -accept
+			  recognizer finds it derived from the start
+			  production, else to reject. The mutations hitting
+			  the -accept-depth bound are left out and reported.
+	-no-line-info	Leave out the grammar positions, now written only by
+			  -keep-synthetic-comments, from the output. Without
+			  it, the file names of the positions are written
+			  relative to the current directory, if it contains
+			  them. The //line directives of the Go parser are
+			  written by the parser generator, use goyacc -l to
+			  leave them out.
+	-no-synthetic	Reject the grammar if lowering it to BNF would create
+			  synthetic productions, like Expression1, keeping
+			  the rules of the output in one to one
+			  correspondence with the productions. Every group,
+			  option and repetition of a non terminal production
+			  is reported with its position. Write them as
+			  productions of their own instead, eg.
+			  A = B [ C ] . as A = B | B C . The check is done
+			  after -ie and -right-factor. The start rule, Start,
+			  is created anyway.
+	-o name		Output file name. Stdout if left blank (default). The
+			  output is written as it is generated, the file may
+			  be a pipe.
 	-oe name	Output pretty printed EBNF to <name>.
+	-oe-style style	The alternatives of -oe, normalize (the default), all of
+			  them after the equal sign, or preserve, the
+			  productions written with a leading | an alternative
+			  per line, each led by |.
+	-parallel number
+			Number of goroutines evaluating the -m inlining
+			  candidates, each running the parser generator in a
+			  temporary directory of its own. Default 1. The
+			  result does not depend on it, the candidates scoring
+			  the same are tried in the order of their names.
 	-p string	Prefix for token names, eg. "_". Default blank.
 	-pkg name	Package name. Default "main".
+	-playground name
+			Write to <name> a self-contained Go program for a
+			  quick test of the grammar: a lexer, the tables of
+			  -table, a driver and a main printing the parse tree
+			  of stdin. See Parse tables below.
+	-recursion kind	The rules a repetition { X } is lowered to: left, the
+			  left recursive L: | L X, or right, the right
+			  recursive L: | X L. The list values are the same,
+			  in source order, the right recursive rules prepend
+			  the items. Left recursion keeps the yacc stack
+			  shallow and is the default, right recursion is the
+			  default of -target recursive-descent.
+	-refcounts format
+			Write to stdout every production with the number of
+			  references to it in the grammar, most referred to
+			  first, and exit. The format is text, a count and a
+			  name per line, or json, an array of {"name", "refs"}
+			  objects.
+	-rename-rules mode
+			Output names of the non terminals, including the
+			  synthetic ones:
+			  keep: unchanged (default)
+			  snake: snake_case, eg. expression_list
+			  camel: lowerCamelCase, eg. expressionList
+			Renaming two productions to the same name or to a Go
+			  keyword is an error.
+	-rename-synthetic name
+			Rename the synthetic productions by the name=newname
+			  lines of the file <name>, eg. Term1=MulChain, the
+			  names being the ones of the numbered helpers derived
+			  from the grammar, as written by -target bnf.
+			  Unlisted synthetics keep the derived names, which do
+			  not depend on the renamed ones. A new name already
+			  used or not starting with an upper case letter, and
+			  a name not given to a synthetic production, are
+			  errors.
+	-rename-table name
+			Write the -rename-rules mapping as name=newname lines
+			  to <name>.
+	-repl		Read commands from stdin, one per line, and write the
+			  answers to stdout until quit or the end of the input,
+			  then exit. The commands are
+			  first Name, follow Name, nullable Name: the
+			  FIRST and FOLLOW sets of the non terminal Name and
+			  whether it derives the empty string.
+			  accept token...: like -accept "token...".
+			  lower name: the BNF productions the production
+			  name is lowered to.
+			  help: the list of the commands.
+	-report-file name
+			Like -M but write the report to <name>. The file is
+			written also when there are no conflicts to report.
+	-result name	Emit the synthetic start rule and the -start
+			  production first, for the yaccs without %start, the
+			  start rule setting the variable <name>, declared in
+			  the prologue, to the value of the -start production.
+			  Retrieve it after a successful yyParse.
+	-right-factor	Factor out the common suffixes of the alternatives of
+			  the non terminal productions, eg. A = B "x" | C "x" .
+			  becomes A = ( B | C ) "x" . and A = B "x" | "x" .
+			  becomes A = [ B ] "x" . The alternatives with a %prec
+			  directive are left alone. The rewritten productions
+			  are reported to stderr.
+	-samples number	Write <number> random sentences derived from the start
+			  production to stdout, one per line, and exit. Tokens
+			  are separated by a space. A lexical token is written
+			  as a string matching its production or, if the
+			  production is empty, as its name.
+	-samples-depth number
+			Productions nested at least <number> levels deep are
+			  expanded by -samples in the shortest possible way,
+			  options and repetitions are left out. Default 8.
+	-samples-test name
+			Write to <name> a Go test of package -pkg checking the
+			  generated parser accepts the -samples sentences,
+			  10 if -samples is not set. The sentences are read
+			  by the lexer constructor named by -fuzz-lexer. The
+			  conversion then continues as without -samples.
+	-scaffold dir	Bootstrap a new project in the directory <dir>, created
+			  if necessary: the .y file parser.y, the golex
+			  skeleton lexer.l, a copy of the grammar, a Makefile
+			  and main.go, reading stdin and dumping the parse
+			  tree, or, if -pkg is not main, parse.go with a Parse
+			  function. The go:generate directives and the
+			  Makefile regenerate parser.y with the other flags
+			  given, lexer.l is left for editing. Cannot be used
+			  with -o, -lexer or -update.
+	-sort-tokens order
+			Select the order of the %token declarations:
+			  source: the order of the lexical productions and of
+			    the first uses of the literals in the grammar
+			    (default)
+			  name: sorted by name
+			  category: lexical tokens, literals named TOKn and
+			    literals named after their letters, each sorted
+			    by name. The output of former versions.
+			The literals named TOKn are numbered in the same order.
+	-source-refs	Follow every rule alternative of the .y file by a
+			  comment giving the grammar position it comes from,
+			  eg. grammar.ebnf:12, of its first term, else
+			  of its production, or of the construct a synthetic
+			  production was created for. Cannot be used with
+			  -no-line-info.
 	-start name	Select start production name. Default is "SourceFile".
+			  An undefined start production is an error listing
+			  the non terminal productions of the grammar.
+	-strict-camelcase
+			Warn about every production name breaking the naming
+			  convention the tool classifies the productions by:
+			  a non terminal name with underscores or consecutive
+			  upper case letters, eg. XMLParser or My_Rule, and a
+			  lower case name with underscores, eg. my_rule, which
+			  may have been meant as a non terminal. The warning
+			  suggests the canonical name, eg. XmlParser, or
+			  MyRule and myrule. Use -Werror to fail on them.
+	-strict-notation
+			Reject the extensions of the notation described below:
+			  directives and the wildcard _any are errors. Cannot
+			  be used with -ellipsis-informal skip.
+	-symtype name	The semantic value type of the parser used by the
+			  -lexer skeleton and the -lexer-interface adapter,
+			  yySymType by default. Set it to match the -p prefix
+			  goyacc is run with, eg. fooSymType for -p foo.
+	-table file	Write the LALR(1) ACTION and GOTO tables of the lowered
+			  grammar to file as Go data for a table driven
+			  parser, see Parse tables below. The conflicts are
+			  resolved like by yacc and reported to stderr.
+			  Requires -target yacc.
+	-target name	Select the output format:
+			  yacc: the .y file (default).
+			  goyacc-generics: experimental, the .y file for Go
+			    1.18 or later, declaring the generic type
+			    List[T any] []T. A repetition every iteration of
+			    which appends one item, of the same non terminal
+			    or a literal, has the type List[Item], or
+			    List[string], instead of a slice of interface{}
+			    values, eg. Args List[Expression]. The type is
+			    named List1, ..., if List is taken. Cannot be
+			    used with -compat bison or -full-go.
+			  bnf: the lowered grammar as plain BNF, one
+			    "Name ::= rhs" production per non terminal, the
+			    alternatives separated by "|" and the empty one
+			    written as ε. Lexical tokens are written as
+			    their names, literals as quoted strings. There are
+			    no actions or yacc declarations. Cannot be used
+			    with -m or -fuzz.
+			  cfg: the same lowered grammar as a context free
+			    grammar for parsing tools, one "Name -> rhs" rule
+			    per alternative, the empty one written as ε.
+			    Non terminals start with an upper case letter,
+			    lexical tokens with a lower case one, literals are
+			    quoted. As for bnf, and cannot be used with
+			    -rename-rules.
+			  lalrpop: a grammar for the Rust parser generator
+			    lalrpop[5], written from the EBNF after -ie. Every
+			    non terminal becomes a rule of type () with stub
+			    => () actions, the start production is pub.
+			    Repetitions and options become the *, + and ?
+			    operators, nested alternatives rules of their
+			    own. Lexical productions used by the rules become
+			    rules of a regular expression, literals take
+			    precedence over them in the match block, where
+			    the %skip productions are skipped. The same
+			    restrictions as for bnf apply.
+			  recursive-descent: a Go parser, without goyacc, of
+			    a LL(1) grammar: a parse function per production
+			    of the lowered grammar, choosing the alternatives
+			    by the lookahead token and its FIRST sets,
+			    repetitions parsed by loops. yyParse reads the
+			    tokens of a yyLexer, like the one of goyacc, and
+			    builds the same values as the actions of the yacc
+			    target, @left and @right folds included. Fails
+			    listing the conflicts if the grammar is not LL(1)
+			    or left recursive. The same restrictions as for
+			    bnf apply.
+	-timings	Write to stderr a table of the wall clock time spent in
+			  the phases of the conversion: parsing, including the
+			  checks of the grammar, the nullable, FIRST and FOLLOW
+			  analysis, inlining, lowering to BNF, emitting the
+			  output and the -m search. Phases not run are not
+			  listed.
+	-token-map name	Rename the tokens by the name=newname lines of the file
+			  <name>, eg. IDENTIFIER=NAME, the names being the
+			  ones derived from the grammar, -p prefix included.
+			  Unlisted tokens keep the derived names. A new name
+			  already used by a token or a rule is an error.
+	-token-order-from name
+			Declare first the tokens listed by the file <name>, in
+			  its order, so that goyacc, numbering the tokens by
+			  their declaration order, gives them the numbers of
+			  the previous run. The file is a .y file, its
+			  %token declarations, a .go file generated by
+			  goyacc, its token constants, or else a token name
+			  per line, # starting a comment. The names are the
+			  ones of the output, after -p and -token-map. The
+			  new tokens follow in the -sort-tokens order. A
+			  listed token no longer declared before other listed
+			  ones, changing their numbers, is a warning.
+	-token-type name
+			Declare <name> as the type of the semantic values of
+			  the tokens, the same struct as yySymType. The
+			  <lex> method of -lexer-interface is passed a *<name>.
+	-toposort format
+			Write to stdout the productions in reverse dependency
+			  order, every production after the ones it refers
+			  to, and exit. Productions referring to each other
+			  have no such order, they are written sorted by name
+			  after a "# cycle: names" line. The format is text,
+			  a name per line, or json, an array of
+			  {"productions", "cycle"} objects.
+	-unparse name	Write to <name> a Go file with the function
+			  unparse(w io.Writer, n interface{}) error, writing
+			  the terminals of the AST node n back to w, separated
+			  by spaces. There is an unparser method per non
+			  terminal type, writing its items in order. The
+			  literals are among the items, as the .y file actions
+			  keep them. Other token values are written by
+			  fmt.Sprint.
+	-update name	Write the output to the existing .y file <name>, also
+			  named by -o if at all, rewriting only its changed
+			  rules. A rule is changed if its alternatives
+			  differ, the actions are not compared. The unchanged
+			  rules keep their text, actions and rule numbers
+			  included, as does the prologue if only its time
+			  stamp and command line differ. The changed rules
+			  are listed on stderr.
+	-verify name	Regenerate the .y file in memory and compare it to the
+			  file <name>, eg. a committed one, instead of
+			  writing it. The differences are written to stdout
+			  as a unified diff and the exit status is 1 if there
+			  are any. The -custom regions are taken from <name>.
+			  Cannot be used with -o, -update, -scaffold or -m.
+	-verify-normalize list
+			Parts of both files -verify leaves out of the
+			  comparison, a comma separated list of
+			  stamp: the time stamp and command line lines of
+			  the prologue (default)
+			  lines: the //line directives and the grammar
+			  positions, eg. x.ebnf:42, as written by
+			  -source-refs and -keep-synthetic-comments
+			  or none, comparing byte for byte.
+	-version	Print the ebnf2y version and exit.
+	-warn-ambiguous-dangling
+			Warn about every option ending a non terminal
+			  production, eg. [ "else" Statement ], which starts
+			  with terminals that can also follow the production,
+			  the dangling else. Yacc reports a shift/reduce
+			  conflict and shifts, binding the option to the
+			  innermost production. Declaring the intent by %prec,
+			  see below, silences the conflict.
+	-warn-epsilon-in-repetition
+			Warn about every repetition of a nullable expression,
+			  eg. { A } if A can derive the empty string. Such a
+			  repetition is ambiguous and almost always a bug of
+			  the grammar. Use -Werror to fail.
+	-warn-left-recursion
+			Warn about every left recursive production, the path
+			  of the recursion included. Also the recursion
+			  hidden by nullable productions, options or
+			  repetitions before it is reported, together with
+			  the nullable prefix, eg. A in A = B A . if B can
+			  derive the empty string. Left recursion is fine
+			  for yacc, but not for LL or PEG parsers.
+	-warn-mixed-assoc
+			Warn about every operator used by the operator chains
+			  of more than one production. An operator chain is an
+			  alternative X { op X }, eg. Term { ( "+" | "-" )
+			  Term }, the operators being the literals and lexical
+			  tokens before the operand. Each chain implies a
+			  precedence level, but a %left or %right declaration
+			  can assign an operator only one. The operators of
+			  a prefix, eg. the unary "-" of [ "-" ] Operand, are
+			  not in a chain. Use -Werror to fail on them.
+	-warn-nesting number
+			Warn about every non terminal production nesting
+			  groups, options and repetitions more than <number>
+			  levels deep, each level giving a synthetic
+			  production. Factor such constructs out to
+			  productions of their own. 0: never (default). Use
+			  -Werror to fail.
+	-warn-nullable-start
+			Warn if the -start production can derive the empty
+			  string, ie. the parser accepts an empty input, which
+			  is often a bug. Use -Werror to fail.
+	-warn-rhs number
+			Warn about every alternative of the lowered rules, ie.
+			  after -ie, -iy and the conversion to BNF, of more
+			  than <number> terms. 0: never (default)
+	-warn-right-recursion
+			Warn about every list written right recursive, ie. a
+			  production P = x P | y . where x y equals y z, eg.
+			  List = Item List | Item . or List = Item "," List |
+			  Item . The yacc stack grows with the length of such
+			  a list, the left recursive P = P z | y . or, unless
+			  -recursion right, the repetition y { z } keep it
+			  constant. Other right recursion, eg. of a prefix
+			  operator, is not reported. Only the yacc and lalrpop
+			  targets are checked, the recursive descent parsers
+			  need right recursion. Use -Werror to fail.
+	-warn-single-use-nonterminal
+			Warn about every non terminal production, other than
+			  the start one, referred to exactly once, at the
+			  reference. These are the productions -ie 1 would
+			  inline.
+	-warn-token-prefixes
+			Warn about every literal used by the non terminal
+			  productions which is a proper prefix of other ones,
+			  eg. "<" of "<<", at its first use. The lexical
+			  productions of a single literal, eg. lsh = "<<" .,
+			  count as their literal. The lexer must prefer the
+			  longest match. The rules of such terminals in the
+			  -lexer skeleton are annotated as well.
+	-Werror		Treat warnings as errors.
 	-wr		Weight of reduce/reduce conflicts for -m.
+	-wrap number	Break the right hand sides of the rules of the .y file
+			  into lines at most <number> columns wide, tabs
+			  counted as 8 columns, the continuation lines
+			  indented by two tabs. A term is never broken and a
+			  term longer than the width gets a line of its own.
+			  0: never (default).
 	-ws		Weight of shift/reduce conflicts for -m.
+	-xref format	Write to stdout the cross reference of the grammar and
+			  exit: every production, sorted by name, with the
+			  position defining it, then every literal, quoted,
+			  each followed by the positions referring to it in
+			  source order. The unused lexical productions are
+			  left out, like by -refcounts. The format is text, a
+			  name and its definition per line followed by the
+			  references indented by a tab, or json, an array of
+			  {"name", "defined", "refs"} objects.
 
 File:
 	A named EBNF file. If no non-opt args are given, ebnf2y reads stdin.
 
+Every production of the file must be reachable from the start production.
+The unreachable ones are reported before the conversion fails, the cycles of
+productions referring to each other but referred to by no other production,
+typically left behind by an edit, as dead cycle groups, eg.
+
+	grammar.ebnf:12:1: dead cycle group Block, Stmt: referring only to each other, unreachable from SourceFile
+
 Notation
 
 The EBNF flavor is the one used by the Go language specification[1]:
@@ -74,6 +758,167 @@ token of the Go language.
 
 _______________________________________________________________________________
 
+Extensions
+
+The following additions to the above notation are recognized by ebnf2y.
+
+The name _any, used as a term of a non terminal production, stands for any
+single terminal. It is replaced by a reference to a synthetic production, named
+Any unless that name is already taken, having one alternative for every lexical
+token and literal used by the non terminal productions of the grammar. If the
+grammar defines a production named _any, the name has no special meaning. A
+lone "." cannot be used for this purpose as it terminates a production.
+
+The keyword fragment, starting a line, marks the lexical production following
+it as a fragment, a helper used only to define other lexical productions, for
+example
+
+	fragment digit = "0" … "9" .
+	number = digit { digit } .
+
+A fragment is never a token. Its use by a non terminal production is an error,
+as is a fragment referring to itself or listed by %skip. The lexer written by
+-lexer has no definition for a fragment, its pattern is inlined into the
+patterns using it.
+
+The first alternative of a production may be preceded by a |, which is
+ignored, so the alternatives can be aligned, for example
+
+	Statement =
+		| IfStmt
+		| ForStmt .
+
+A lexical production may declare the Go type of the values of its token
+between its name and the equal sign, for example
+
+	integer : int = decimal_digit { decimal_digit } .
+
+The .y file then declares the token as %token <intval> INTEGER, with the
+%union field intval int, the field named after the type. The lexer written by
+-lexer leaves setting the field to the user.
+
+A directive, starting with a percent sign, may appear anywhere outside of
+literals and comments. Directives are removed from the grammar before it is
+parsed. Unknown directives are an error.
+
+	%ebnf2y-version version
+
+The %ebnf2y-version directive, best placed at the top of the grammar, states the
+minimum version of ebnf2y, for example 1.2, the grammar requires. An older
+ebnf2y fails on it before looking at the rest of the grammar.
+
+	%if feature
+	%endif
+
+The text between %if and the matching %endif is removed from the grammar unless
+the feature is listed by -features. The blocks may nest. Directives other than
+%if and %endif are ignored in the removed text.
+
+	%prec symbol
+
+The %prec directive must follow a top level alternative of a non terminal
+production. The corresponding yacc rule gets the same %prec clause, for
+example
+
+	Expression = Expression "-" Expression
+		| "-" Expression %prec UMINUS
+		| Operand .
+
+The symbol is a literal, a lexical production name or any other name. An other
+name is declared as a %token to be given its precedence by the user, unless
+it is listed by one of the following directives.
+
+	%left symbol...
+	%right symbol...
+	%nonassoc symbol...
+	%precedence symbol...
+
+These directives declare the precedence levels of the listed symbols, written
+to the .y file as the same yacc declarations in the same order, ie. in
+increasing precedence. The symbols are those of %prec. For example
+
+	%left "+" "-"
+	%left "*" "/"
+	%precedence UMINUS
+
+A %precedence level has no associativity, conflicts between its symbols are
+reported instead of being resolved. As only bison supports it, for the other
+-compat targets it is written as %nonassoc, which makes such conflicts
+syntax errors of the generated parser, with a note.
+
+	Term { operator... Operand }@left
+	Term { operator... Operand }@right
+
+A repetition of operators followed by an operand, the only other term of its
+alternative, may be annotated by its associativity. The terminals of the
+operators are declared as a %left or %right level, following the directives
+above in the order of the annotations, and the value of the alternative is
+the expression tree folded from the operands and operators, eg. for 1 - 2 - 3
+and @left [[1 "-" 2] "-" 3] instead of [1 ["-" 2 "-" 3]]. For example
+
+	Expression = Term { ( "+" | "-" ) Term }@left .
+	Term = Factor { "^" Factor }@right .
+
+	%error "message"
+
+The %error directive must follow a non terminal production. Its yacc rules get
+an additional error alternative, which passes the message to the Error method
+of the lexer when the parser recovers from a syntax error in the production,
+for example
+
+	Expression = Expression "+" Term | Term . %error "expected an expression"
+
+The error alternative may introduce conflicts.
+
+	%inline target[,target...] name...
+
+The %inline directive lists non terminal productions inlined at their call
+sites, like by -ie, but only for the listed -target values, yacc, bnf, cfg,
+lalrpop or recursive-descent. One grammar can so give fewer conflicts to yacc
+and readable rules to the other targets, for example
+
+	%inline yacc Operand PrimaryExpr
+
+	%noinline name...
+
+The %noinline directive lists non terminal productions -collapse-chains keeps.
+
+	%skip name...
+
+The %skip directive lists lexical productions the parser never sees, like
+white space or comments. Lexical productions not reachable from the start
+production are removed from the grammar. Those not listed by %skip are
+reported as declared but never used.
+
+	%states name...
+	%state name...
+
+The %states directive declares exclusive start conditions of the lexer
+written by -lexer. The %state directive must follow a lexical production. The
+rule of the token is then active only in the listed start conditions, which
+must be declared or INITIAL. For example
+
+	%states STR
+	strchars = { "a" … "z" } . %state STR
+
+The parser is not affected.
+
+White space
+
+There is no implicit white space. Every lexical production reachable from the
+start production, including those matching white space, like the NEWLINE,
+INDENT and DEDENT tokens of an indentation sensitive language, is a terminal
+like any other. It is declared as a %token and it is a member of the FIRST and
+FOLLOW sets computed for -explain. Only the tokens listed by %skip are left out
+of the grammar.
+
+The lexer written by -lexer skips the %skip tokens. If there are none, it skips
+blanks, tabs and new lines unless -keep-whitespace-tokens is set. The sentences
+written by -samples separate the tokens by a space.
+
+Package github.com/cznic/ebnf2y/grammar exposes the nullable, FIRST and FOLLOW
+sets of -explain to other programs, eg. custom LL(1) table generators.
+
 Generated code
 
 Many non trivial EBNF grammars will produce shift/reduce conflicts. These must
@@ -180,6 +1025,58 @@ program can be compiled. Running it without arguments prints:
 Note: In the above output, nil items have been removed from the AST dump before
 printing.
 
+Parse tables
+
+The file written by -table declares, besides the token constants numbered like
+by goyacc,
+
+	yyNTerminals	the number of terminals, $end being terminal 0
+	yySymbols	the names of the terminals followed by the non terminals
+	yyTerminals	the terminal of each token returned by the lexer
+	yyRules		the left hand side symbol and length of each rule
+	yyAction	the terminal, action pairs of each state
+	yyGoto		the non terminal, state pairs of each state
+
+An action a > 0 shifts the token and enters state a-1, a < 0 reduces by the
+rule -a-1 and 0 accepts the input. Rule 0 is the synthetic start rule. A
+driver keeps a stack of states, initially 0, and repeats
+
+	a := action of the top state on the terminal of the lookahead token
+	a > 0: push a-1, read the next token
+	a < 0: pop yyRules[-a-1][1] states, push the goto of the top
+	       state on yyRules[-a-1][0]
+	a == 0: accept
+
+A token without a terminal or a missing action is a syntax error. The %error
+rules of the .y file are left out, error recovery is up to the driver.
+
+The program written by -playground is such a driver. Its lexer skips white
+space, or the %skip productions, and returns the longest match of the literals
+and of the lexical tokens, the literals winning over the tokens of the same
+length. A lexical token gets a Go regular expression if it has one, else a
+stub, marked by a TODO comment and reported to stderr, to be completed before
+the token can be read. For example
+
+	$ ebnf2y -start Expression -o demo.y -playground pg.go demo.ebnf
+	$ echo 'true &^ false' | go run pg.go
+
+prints the parse tree of the lowered grammar, a node per line, the terminals
+with their text.
+
+Custom regions
+
+When the output file named by -o exists, the regions enclosed by the lines
+
+	// ebnf2y:begin-custom key
+	// ebnf2y:end-custom
+
+are read from it before it is regenerated. Any region with the same key in
+the new output gets the saved content instead of the generated one. The key of
+an action region emitted by -custom is the rule, for example "Expression: Term
+Expression1". The prologue and epilogue regions are keyed "prologue" and
+"epilogue". Saved regions having no place in the new output are reported as
+warnings.
+
 Demo
 
 Prerequisites: ebnf2y and golex[4] must be installed.
@@ -200,6 +1097,7 @@ Links fom the above godocs:
   [3]: http://github.com/cznic/ebnf2y/blob/master/demo/demo.y
   [3]: http://github.com/cznic/ebnf2y/blob/master/demo/demo.l
   [4]: http://github.com/cznic/golex
+  [5]: https://github.com/lalrpop/lalrpop
 
 */
 package main
@@ -11,27 +11,48 @@ import (
 	"fmt"
 	"go/ast"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"text/scanner"
 	"time"
+	"unicode/utf8"
 
-	"golang.org/x/exp/ebnf"
 	"github.com/cznic/ebnfutil"
 	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
 )
 
 const (
-	sep = ""
+	sep     = ""
+	version = "1.1"
 )
 
 var todo = strings.ToUpper("todo")
 
+// compat describes a yacc flavor the output is generated for.
+type compat struct {
+	yacc         []string // Command running the parser generator.
+	errorVerbose bool     // Supports %error-verbose.
+	precedence   bool     // Supports %precedence.
+	report       string   // Verbose report written by the parser generator.
+}
+
+func (c *compat) String() string { return strings.Join(c.yacc, " ") }
+
+var compats = map[string]*compat{
+	"bison":         {[]string{"bison", "-o", os.DevNull}, true, true, ""},
+	"goyacc":        {[]string{"go", "tool", "yacc"}, false, false, "y.output"},
+	"goyacc-modern": {[]string{"goyacc"}, true, false, "y.output"},
+}
+
 func dbg(s string, va ...interface{}) {
 	_, fn, fl, _ := runtime.Caller(1)
 	fmt.Printf("%s:%d: ", path.Base(fn), fl)
@@ -40,13 +61,54 @@ func dbg(s string, va ...interface{}) {
 }
 
 type job struct {
-	pkg         string
-	grm         ebnfutil.Grammar
-	rep         *ebnfutil.Report
-	names       map[string]bool
-	repetitions map[string]bool
-	tPrefix     string
-	term2name   map[string]string
+	altRules        bool           // A production per alternative.
+	assoc           map[int]string // Repetition offset: @left or @right.
+	comments        []string       // golex patterns of the comments skipped by -lexer.
+	compat          *compat
+	custom          bool
+	declareLiterals bool
+	docs            map[string][]string // Production: doc comment lines.
+	errors          map[string]string   // Production: %error message.
+	errorVerbose    bool
+	first           *analysis // Of the emitted rules, for -first-comments.
+	firstComments   bool
+	fragments       map[string]bool // Lexical productions inlined by -lexer.
+	fullGo          bool
+	keepWhitespace  bool             // No implicit white space rule in the lexer.
+	keywords        bool             // Emit the keyword map and LookupKeyword.
+	lexIface        *lexerInterface  // Existing lexer adapted to yyLexer, if any.
+	lexStates       []string         // Declared by %states.
+	lexical         ebnfutil.Grammar // Lexical productions, including the unused ones.
+	listType        string           // Generic type of the -target goyacc-generics lists, if any.
+	pkg             string
+	grm             ebnfutil.Grammar
+	rep             *ebnfutil.Report
+	names           map[string]bool
+	noLineInfo      bool
+	sourceRefs      bool
+	order           map[string]int // Terminal: source order.
+	levels          []*level       // Precedence levels, lowest first.
+	prec            map[int]string // Alternative offset: %prec symbol.
+	repetitions     map[string]bool
+	rightRecursion  bool                // Repetitions lowered to R: | X R.
+	result          string              // Variable set by the start rule, declared in the prologue.
+	children        map[string][]string // Production: synthetic productions derived from it, in order.
+	origins         map[string]origin   // Synthetic production: construct it was created for.
+	ruleNames       map[string]string   // Non terminal: output name.
+	rules           map[string]int      // Non terminal: number of the rules rendered before it.
+	symType         string              // Semantic value type of the parser, eg. yySymType.
+	synthComments   bool
+	skip            map[string]bool // Declared by %skip.
+	sortTokens      string
+	synthetic       map[string]string // Synthetic production: derived from.
+	tPrefix         string
+	term2name       map[string]string
+	synthMap        map[string]string   // Synthetic name: -rename-synthetic name.
+	tokenMap        map[string]string   // Derived token name: -token-map name.
+	tokenOrder      *tokenSeed          // Of -token-order-from, if any.
+	tokenTypes      map[string]string   // Lexical production: Go type of its values.
+	tokenStates     map[string][]string // Lexical production: start conditions.
+	wrap            int                 // Column bound of the rules, 0: none.
 }
 
 func (j *job) inventName(prefix, sep string) (s string) {
@@ -67,13 +129,59 @@ func (j *job) inventName(prefix, sep string) (s string) {
 }
 
 func (j *job) toBnf(start string) {
+	g := j.grm
 	var err error
 	j.grm, j.repetitions, err = j.grm.BNF(start, func(name string) string {
-		return j.inventName(name, sep)
+		s := j.synthName(j.inventName(j.synthKey(name), sep))
+		j.synthetic[s] = name
+		j.children[name] = append(j.children[name], s)
+		return s
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if j.rightRecursion {
+		for name := range j.repetitions {
+			prod := j.grm[name]
+			a := alternatives(prod.Expr)
+			for i, v := range a[1:] {
+				x := v.(ebnf.Sequence)
+				a[i+1] = append(append(ebnf.Sequence{}, x[1:]...), x[0])
+			}
+			prod.Expr = ebnf.Alternative(a)
+		}
+	}
+
+	j.origins = map[string]origin{}
+	for name, prod := range g {
+		if ast.IsExported(name) {
+			j.bindOrigins(name, prod.Expr, name)
+		}
+	}
+	if j.altRules {
+		j.splitAlternatives()
+	}
+}
+
+// iteration returns the items appended to its list by x, a non empty
+// alternative of a lowered repetition, R items... or, with -recursion right,
+// items... R.
+func (j *job) iteration(x ebnf.Sequence) ebnf.Sequence {
+	if j.rightRecursion {
+		return x[:len(x)-1]
+	}
+
+	return x[1:]
+}
+
+// keywords are the Go keywords, not usable as production names.
+var keywords = []string{
+	"break", "default", "func", "interface", "select",
+	"case", "defer", "go", "map", "struct",
+	"chan", "else", "goto", "package", "switch",
+	"const", "fallthrough", "if", "range", "type",
+	"continue", "for", "import", "return", "var",
 }
 
 func (j *job) checkTerminals(start string) {
@@ -95,6 +203,12 @@ func toAscii(s string) string {
 	return string(r)
 }
 
+// inlineLiteral reports whether lit is written as a character literal
+// instead of being declared as a token.
+func (j *job) inlineLiteral(lit string) bool {
+	return len(lit) == 1 && !j.declareLiterals
+}
+
 func (j *job) str(expr ebnf.Expression) (s string) {
 	switch x := expr.(type) {
 	case nil:
@@ -102,7 +216,7 @@ func (j *job) str(expr ebnf.Expression) (s string) {
 	case *ebnf.Name:
 		switch name := x.String; ast.IsExported(name) {
 		case true:
-			return name
+			return j.ruleName(name)
 		default:
 			return j.term2name[name]
 		}
@@ -113,8 +227,8 @@ func (j *job) str(expr ebnf.Expression) (s string) {
 		}
 		return strings.Join(a, " ")
 	case *ebnf.Token:
-		switch s := x.String; len(s) {
-		case 1:
+		switch s := x.String; {
+		case j.inlineLiteral(s):
 			return strconv.QuoteRune(rune(s[0]))
 		default:
 			hint := ""
@@ -129,9 +243,22 @@ func (j *job) str(expr ebnf.Expression) (s string) {
 	}
 }
 
-var sIsStart = map[bool]string{
-	false: "$$",
-	true:  "_parserResult",
+// lhs returns the target of the value of a rule of the named production.
+func (j *job) lhs(name, start string) string {
+	if name == start {
+		return j.resultVar()
+	}
+
+	return "$$"
+}
+
+// resultVar returns the name of the variable set by the start rule.
+func (j *job) resultVar() string {
+	if j.result != "" {
+		return j.result
+	}
+
+	return "_parserResult"
 }
 
 const (
@@ -166,28 +293,275 @@ func (j *job) ystr(expr ebnf.Expression, name, start string, rep int) (s string)
 	case true:
 		switch rep {
 		case 0:
-			return fmt.Sprintf("$$ = []%s(nil)", name)
+			return fmt.Sprintf("$$ = %s(nil)", j.nodeType(name))
 		default:
-			return fmt.Sprintf("$$ = append($1.([]%s), %s)", name, strings.Join(a[1:], ", "))
+			typ := j.nodeType(name)
+			if j.rightRecursion {
+				list := a[len(a)-1]
+				if a = a[:len(a)-1]; len(a) == 1 {
+					a[0] = j.listItem(name, a[0])
+				}
+				return fmt.Sprintf("$$ = append(%s{%s}, %s.(%s)...)", typ, strings.Join(a, ", "), list, typ)
+			}
+
+			if len(a) == 2 {
+				a[1] = j.listItem(name, a[1])
+			}
+			return fmt.Sprintf("$$ = append($1.(%s), %s)", typ, strings.Join(a[1:], ", "))
 			//default:
 			//	log.Fatal("internal error")
 			//	panic("unreachable")
 		}
 	case false:
-		switch len(a) {
-		case 0:
-			return fmt.Sprintf("%s = nil", sIsStart[name == start])
-		case 1:
-			return fmt.Sprintf("%s = %s", sIsStart[name == start], a[0])
+		switch {
+		case j.altRule(name):
+			return fmt.Sprintf("%s = []%s{%s}", j.lhs(name, start), j.ruleName(name), strings.Join(a, ", "))
+		case len(a) == 0:
+			return fmt.Sprintf("%s = nil", j.lhs(name, start))
+		case len(a) == 1:
+			return fmt.Sprintf("%s = %s", j.lhs(name, start), a[0])
 		default:
-			return fmt.Sprintf("%s = []%s{%s}", sIsStart[name == start], name, strings.Join(a, ", "))
+			return fmt.Sprintf("%s = []%s{%s}", j.lhs(name, start), j.ruleName(name), strings.Join(a, ", "))
 		}
 	}
 	panic("unreachable")
 }
 
+// precSym returns the yacc name of the %prec symbol s.
+func (j *job) precSym(s string) string {
+	if lit, err := strconv.Unquote(s); err == nil {
+		if j.inlineLiteral(lit) {
+			return strconv.QuoteRune(rune(lit[0]))
+		}
+
+		if name, ok := j.term2name[lit]; ok {
+			return name
+		}
+
+		log.Fatalf("%%prec %s: literal not used in the grammar", s)
+	}
+
+	if name, ok := j.term2name[s]; ok {
+		return name
+	}
+
+	return s
+}
+
+func (j *job) precStr(expr ebnf.Expression) string {
+	if s, ok := j.prec[exprOffset(expr)]; ok {
+		return " %prec " + j.precSym(s)
+	}
+
+	return ""
+}
+
+func (j *job) rule(f strutil.Formatter, expr ebnf.Expression, name, start string, i, rule int) {
+	rhs := j.str(expr)
+	switch {
+	case j.wrap != 0:
+		f.Format("%s\n\t{\n", j.wrapRule(expr, name))
+	default:
+		f.Format("%s%s%s\n\t{\n", rhs, j.precStr(expr), j.sourceRef(expr, name))
+	}
+	if j.custom {
+		f.Format("\t\t%s %s: %s\n", beginCustom, j.ruleName(name), rhs)
+	}
+	action := j.foldAction(expr, name, start)
+	if action == "" {
+		action = j.ystr(expr, name, start, i)
+	}
+	f.Format("\t\t%s //%s %d\n", action, todo, rule)
+	if j.custom {
+		f.Format("\t\t%s\n", endCustom)
+	}
+	f.Format("\t}\n")
+}
+
+// wrapRule returns the right hand side of the rule of expr, an alternative of
+// the named production, broken into lines of at most j.wrap columns. The
+// continuation lines are indented by two tabs.
+func (j *job) wrapRule(expr ebnf.Expression, name string) string {
+	var words []string
+	switch x := expr.(type) {
+	case ebnf.Sequence:
+		for _, v := range x {
+			words = append(words, j.str(v))
+		}
+	default:
+		words = append(words, j.str(x))
+	}
+	for _, s := range []string{j.precStr(expr), j.sourceRef(expr, name)} {
+		if s != "" {
+			words = append(words, strings.TrimSpace(s))
+		}
+	}
+	return wrap(words, 8, j.wrap, "\t\t", 16)
+}
+
+// wrap joins words by spaces, the first one starting at column col, into
+// lines at most max columns wide unless a single word is wider. A line break
+// is followed by indent, which ends at column indentCol.
+func wrap(words []string, col, max int, indent string, indentCol int) string {
+	var a []string
+	n := 0
+	for _, v := range words {
+		w := utf8.RuneCountInString(v)
+		switch {
+		case n == 0:
+			// nop
+		case col+1+w > max:
+			a = append(a, "\n"+indent)
+			col = indentCol
+			n = 0
+		default:
+			a = append(a, " ")
+			col++
+		}
+		a = append(a, v)
+		col += w
+		n++
+	}
+	return strings.Join(a, "")
+}
+
+// sourceRef returns the -source-refs comment of the rule of expr, an
+// alternative of the named production: the position of its first term, else
+// of the production or of the construct a synthetic production was created
+// for.
+func (j *job) sourceRef(expr ebnf.Expression, name string) string {
+	if !j.sourceRefs {
+		return ""
+	}
+
+	pos := exprPos(expr)
+	if !pos.IsValid() {
+		pos = j.grm[name].Pos()
+	}
+	if o, ok := j.origins[name]; ok && !pos.IsValid() {
+		pos = o.pos
+	}
+	if !pos.IsValid() {
+		return ""
+	}
+
+	return fmt.Sprintf(" /* %s:%d */", relPath(pos.Filename), pos.Line)
+}
+
+// firstComment returns the -first-comments comment of the named production:
+// the terminals of its FIRST set, the lexical tokens by their yacc names and
+// the literals quoted, and ε if it is nullable.
+func (j *job) firstComment(name string) string {
+	var a []string
+	for _, t := range j.first.First(name).Sorted() {
+		if _, err := strconv.Unquote(t); err != nil {
+			t = j.term2name[t]
+		}
+		a = append(a, t)
+	}
+	if j.first.Nullable(name) {
+		a = append(a, "ε")
+	}
+	return fmt.Sprintf("/* FIRST: %s */\n", strings.Join(a, " "))
+}
+
+// doc writes the doc comment of the named production, if any.
+func (j *job) doc(f strutil.Formatter, name string) {
+	for _, s := range j.docs[name] {
+		switch s {
+		case "":
+			f.Format("//\n")
+		default:
+			f.Format("// %s\n", s)
+		}
+	}
+}
+
+// production writes the yacc rules of the named production.
+func (j *job) production(f strutil.Formatter, name, start string, rule *int) {
+	j.doc(f, name)
+	if o, ok := j.origins[name]; ok && j.synthComments {
+		switch {
+		case j.noLineInfo:
+			f.Format("/* from %s in %s */\n", o.kind, j.ruleName(o.in))
+		default:
+			f.Format("/* from %s in %s (%s:%d) */\n", o.kind, j.ruleName(o.in), relPath(o.pos.Filename), o.pos.Line)
+		}
+	}
+	if j.firstComments {
+		f.Format("%s", j.firstComment(name))
+	}
+	f.Format("%s:\n\t", j.ruleName(name))
+	expr := j.grm[name].Expr
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for i, v := range x {
+			if i != 0 {
+				f.Format("|\t")
+			}
+			*rule++
+			j.rule(f, v, name, start, i, *rule)
+		}
+	default:
+		*rule++
+		j.rule(f, x, name, start, -1, *rule)
+	}
+	if s, ok := j.errors[name]; ok {
+		f.Format("|\terror\n\t{\n\t\tyylex.Error(%q)\n\t}\n", s)
+	}
+	f.Format("\n")
+}
+
+// stickyWriter writes to w until the first error, which it keeps.
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(b []byte) (n int, err error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n, s.err = s.w.Write(b)
+	return n, s.err
+}
+
+// generated writes the header of a generated Go file to f, what it is, when
+// and by which command it was generated, followed by the note lines, if any.
+func generated(f strutil.Formatter, what string, note ...string) {
+	f.Format(`//%s Put your favorite license here
+
+// %s generated by ebnf2y[1]
+// at %s
+//
+//  $ %s
+//
+`, todo, what, time.Now(), strings.Join(os.Args, " "))
+	for _, v := range note {
+		switch v {
+		case "":
+			f.Format("//\n")
+		default:
+			f.Format("// %s\n", v)
+		}
+	}
+	if len(note) != 0 {
+		f.Format("//\n")
+	}
+	f.Format(`// CAUTION: Generated file - DO NOT EDIT.
+//
+//   [1]: http://github.com/cznic/ebnf2y
+
+`)
+}
+
+// render writes the .y file to w as the productions are walked, nothing is
+// buffered except by w. The first write error, if any, ends the output and is
+// returned.
 func (j *job) render(w io.Writer, start string) (err error) {
-	f := strutil.IndentFormatter(w, "\t")
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
 	f.Format(`%%{
 
 //%s Put your favorite license here
@@ -198,7 +572,7 @@ func (j *job) render(w io.Writer, start string) (err error) {
 //  $ %s
 //
 // CAUTION: If this file is a Go source file (*.go), it was generated
-// automatically by '$ go tool yacc' from a *.y file - DO NOT EDIT in that case!
+// automatically by '$ %s' from a *.y file - DO NOT EDIT in that case!
 // 
 //   [1]: http://github.com/cznic/ebnf2y
 
@@ -213,123 +587,150 @@ import (
 	"github.com/cznic/strutil"
 )
 
-%%}
-
-%%union {
-	item interface{} //%s insert real field(s)
-}
-
-`, todo, time.Now(), strings.Join(os.Args, " "), j.pkg, todo, todo, todo)
-	j.term2name = map[string]string{}
-	a := []string{}
-	for name := range j.rep.Tokens {
-		token := j.inventName(j.tPrefix+strings.ToUpper(name), "")
-		j.term2name[name] = token
-		a = append(a, token)
+`, todo, time.Now(), strings.Join(os.Args, " "), j.compat, j.pkg, todo, todo)
+	if j.result != "" {
+		f.Format("// %s is the value of the start production set by yyParse.\nvar %s interface{}\n\n", j.result, j.result)
+	}
+	if j.lexIface != nil {
+		j.lexIface.render(f, j.symType)
+	}
+	if j.custom {
+		f.Format("%s prologue\n%s\n\n", beginCustom, endCustom)
+	}
+	f.Format("%%}\n\n")
+	nts := []string{}
+	for name := range j.rep.NonTerminals {
+		nts = append(nts, name)
 	}
-	if len(a) != 0 {
-		sort.Strings(a)
+	sort.Strings(nts)
+	switch {
+	case j.fullGo:
+		f.Format("%%union {\n\titem interface{}\n")
+		j.unionFields(f)
+		for _, name := range nts {
+			f.Format("\t%s %s\n", j.ruleName(name), j.ruleName(name))
+		}
+		f.Format("}\n\n")
+	default:
+		f.Format("%%union {\n\titem interface{} //%s insert real field(s)\n", todo)
+		j.unionFields(f)
+		f.Format("}\n\n")
+	}
+
+	j.tokens(f)
+	a := nts
+	switch {
+	case j.fullGo:
 		for _, name := range a {
-			f.Format("%%token\t%s\n", name)
+			f.Format("%%type\t<%s>\t%s\n", j.ruleName(name), j.ruleName(name))
 		}
-		f.Format("\n%%type\t<item> \t/*%s real type(s), if/where applicable */\n", todo)
+	default:
+		f.Format("%%type\t<item> \t/*%s real type(s), if/where applicable */\n", todo)
 		for _, name := range a {
-			f.Format("\t%s\n", name)
+			f.Format("\t%s\n", j.ruleName(name))
 		}
-		f.Format("\n")
 	}
+	f.Format("\n")
 
-	j.inventName(j.tPrefix+"TOK", "")
-	a = a[:0]
-	for lit := range j.rep.Literals {
-		if len(lit) == 1 || toAscii(lit) != "" {
-			continue
+	declared := map[string]bool{}
+	for _, name := range j.term2name {
+		declared[name] = true
+	}
+	for _, l := range j.levels {
+		for _, s := range l.syms {
+			declared[j.precSym(s)] = true
 		}
-
-		j.term2name[lit] = j.inventName(j.tPrefix+"TOK", "")
-		a = append(a, lit)
 	}
-	if len(a) != 0 {
-		for _, lit := range a {
-			f.Format("%%token\t%s\t/*%s Name for %q */\n", j.term2name[lit], todo, lit)
+	var precs []string
+	for _, s := range j.prec {
+		if name := j.precSym(s); name[0] != '\'' && !declared[name] {
+			declared[name] = true
+			precs = append(precs, name)
 		}
-		f.Format("\n")
-		f.Format("%%type\t<item> \t/*%s real type(s), if/where applicable */\n", todo)
-		for _, lit := range a {
-			f.Format("\t%s\n", j.term2name[lit])
+	}
+	if len(precs) != 0 {
+		sort.Strings(precs)
+		for _, name := range precs {
+			f.Format("%%token\t%s\t/*%s Used by %%prec, declare its precedence */\n", name, todo)
 		}
 		f.Format("\n")
 	}
 
-	a = a[:0]
-	for lit := range j.rep.Literals {
-		nm := toAscii(lit)
-		if len(lit) == 1 || nm == "" {
-			continue
+	if j.errorVerbose {
+		f.Format("%%error-verbose\n\n")
+	}
+	switch {
+	case len(j.levels) == 0:
+		f.Format("/*%s %%left, %%right, ... declarations */\n\n", todo)
+	default:
+		for _, l := range j.levels {
+			kind, note := l.kind, ""
+			if kind == "precedence" && !j.compat.precedence {
+				kind, note = "nonassoc", fmt.Sprintf("\t/*%s %%precedence, not supported by %s */", todo, j.compat)
+			}
+			f.Format("%%%s", kind)
+			for _, s := range l.syms {
+				f.Format(" %s", j.precSym(s))
+			}
+			f.Format("%s\n", note)
 		}
-
-		name := j.inventName(j.tPrefix+strings.ToUpper(nm), "")
-		j.term2name[lit] = name
-		a = append(a, name)
+		f.Format("\n")
 	}
-	if len(a) != 0 {
-		sort.Strings(a)
+	f.Format("%%start %s\n\n%%%%\n\n", j.ruleName(start))
+
+	rules := a
+	if j.result != "" {
+		// The start rules first, for the yaccs without %start.
+		top := j.grm[start].Expr.(*ebnf.Name).String
+		f.Format("// %s, the first rule, is the start rule.\n\n", j.ruleName(start))
+		rules = []string{start, top}
 		for _, name := range a {
-			f.Format("%%token %s\n", name)
+			if name != start && name != top {
+				rules = append(rules, name)
+			}
 		}
-		f.Format("\n")
 	}
-
-	a = a[:0]
-	for name := range j.rep.NonTerminals {
-		a = append(a, name)
+	if j.firstComments {
+		j.first = newAnalysis(j.grm, start)
 	}
-	sort.Strings(a)
-	f.Format("%%type\t<item> \t/*%s real type(s), if/where applicable */\n", todo)
-	for _, name := range a {
-		f.Format("\t%s\n", name)
+	rule := 0
+	j.rules = map[string]int{}
+	for _, name := range rules {
+		j.rules[name] = rule
+		j.production(f, name, start, &rule)
 	}
-	f.Format("\n")
 
-	f.Format("/*%s %%left, %%right, ... declarations */\n\n%%start %s\n\n%%%%\n\n", todo, start)
-
-	rule := 0
+	f.Format("%%%%\n\n")
+	if j.keywords {
+		j.renderKeywords(f)
+	}
+	if !j.fullGo {
+		f.Format("//%s remove demo stuff below\n\n", todo)
+	}
+	if j.result == "" {
+		f.Format("var _parserResult interface{}\n\n")
+	}
+	f.Format("type (%i\n")
+	if j.listType != "" {
+		f.Format("// %s is the value of a repetition of items of the same type.\n%[1]s[T any] []T\n\n", j.listType)
+	}
 	for _, name := range a {
-		f.Format("%s:\n\t", name)
-		expr := j.grm[name].Expr
-		switch x := expr.(type) {
-		case ebnf.Alternative:
-			for i, v := range x {
-				if i != 0 {
-					f.Format("|\t")
-				}
-				rule++
-				f.Format("%s\n\t{\n\t\t%s //%s %d\n\t}\n", j.str(v), j.ystr(v, name, start, i), todo, rule)
-			}
+		j.doc(f, name)
+		switch typ, _ := j.listElem(name); {
+		case typ != "":
+			f.Format("%s %s[%s]\n", j.ruleName(name), j.listType, typ)
 		default:
-			rule++
-			f.Format("%s\n\t{\n\t\t%s //%s %d\n\t}\n", j.str(x), j.ystr(x, name, start, -1), todo, rule)
+			f.Format("%s interface{}\n", j.ruleName(name))
 		}
-		f.Format("\n")
 	}
-
-	f.Format(`%%%%
-
-//%s remove demo stuff below
-
-var _parserResult interface{}
-
-type (%i
-`, todo)
-
-	for _, name := range a {
-		f.Format("%s interface{}\n", name)
+	f.Format("%u)\n")
+	if j.fullGo {
+		f.Format("\n//%s remove demo stuff below\n", todo)
 	}
 
-	f.Format(`%u)
-	
+	f.Format(`	
 func _dump() {
-	s := fmt.Sprintf("%%#v", _parserResult)
+	s := fmt.Sprintf("%%#v", %s)
 	s = strings.Replace(s, "%%", "%%%%", -1)
 	s = strings.Replace(s, "{", "{%%i\n", -1)
 	s = strings.Replace(s, "}", "%%u\n}", -1)
@@ -348,8 +749,54 @@ func _dump() {
 }
 
 // End of demo stuff
-`)
-	return
+`, j.resultVar())
+	if j.custom {
+		f.Format("\n%s epilogue\n%s\n", beginCustom, endCustom)
+	}
+	return sw.err
+}
+
+// relPath returns fn relative to the current directory if it is an absolute
+// path below it.
+func relPath(fn string) string {
+	if !filepath.IsAbs(fn) {
+		return fn
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return fn
+	}
+
+	if rel, err := filepath.Rel(wd, fn); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return fn
+}
+
+// create writes the named file, or stdout if fn is blank, using f.
+func create(fn string, f func(io.Writer) error) {
+	out := os.Stdout
+	if fn != "" {
+		var err error
+		if out, err = os.Create(fn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	if err := f(w); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func scoreN(s string, a []string) (y int) {
@@ -381,118 +828,852 @@ func scoreN(s string, a []string) (y int) {
 	return
 }
 
-func score(fn string, wr, ws int) (y int) {
-	cmd := exec.Command("go", "tool", "yacc", fn)
+func (c *compat) run(fn string) string { return c.runIn("", fn) }
+
+// runIn runs the parser generator on file fn in directory dir, the current
+// one if blank, and returns its output.
+func (c *compat) runIn(dir, fn string) string {
+	cmd := exec.Command(c.yacc[0], append(c.yacc[1:], fn)...)
+	cmd.Dir = dir
 	var yout bytes.Buffer
 	cmd.Stdout = &yout
+	cmd.Stderr = &yout
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("execuing 'go tool yacc': %v", err)
+		log.Fatalf("executing '%s': %v", c, err)
 	}
 
-	s := yout.String()
+	return yout.String()
+}
+
+// yaccRun is a run of the parser generator on the file named by fn, made on
+// first use and shared by its users.
+type yaccRun struct {
+	c      *compat
+	fn     func() string
+	out    string
+	ran    bool
+	rep    string
+	hasRep bool
+}
+
+// output returns the output of the parser generator.
+func (r *yaccRun) output() string {
+	if !r.ran {
+		r.out, r.ran = r.c.run(r.fn()), true
+	}
+	return r.out
+}
+
+// report returns the report file written by the parser generator.
+func (r *yaccRun) report() string {
+	if !r.hasRep {
+		r.output()
+		b, err := ioutil.ReadFile(r.c.report)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		r.rep, r.hasRep = string(b), true
+	}
+	return r.rep
+}
+
+func score(c *compat, dir, fn string, wr, ws int) (y int) {
+	s := c.runIn(dir, fn)
 	a := strings.Split(s, " shift/reduce")
 	y = ws * scoreN(s, a)
 	a = strings.Split(s, " reduce/reduce")
 	return y + wr*scoreN(s, a)
 }
 
+// neverReduced returns the number of the rules never reduced reported in the
+// parser generator output s.
+func neverReduced(s string) (n int) {
+	for _, v := range strings.Split(s, "\n") {
+		if v = strings.TrimSpace(v); strings.HasPrefix(v, "rule ") && strings.HasSuffix(v, " never reduced") {
+			n++
+		}
+	}
+	return
+}
+
 func main() {
+	oAccept := flag.String("accept", "", "Report whether the space separated tokens <arg> are derived from the start production, write its parse trees to stdout and exit.")
+	oAcceptDepth := flag.Uint("accept-depth", 1000, "Bound of the production nesting of -accept and -repl.")
+	oAltRules := flag.Bool("alt-rules", false, "Give every alternative of a non terminal production a rule, and a node type, of its own, named like NameAlt1.")
+	oASTSchema := flag.String("ast-schema", "", "Write a JSON description of the AST node types to <arg> if non blank.")
+	oASTStringer := flag.String("ast-stringer", "", "Write a Go AST tree dump function to <arg> if non blank.")
+	oBisect := flag.String("bisect", "", "Find the productions changed from the old grammar <arg> causing the new yacc conflicts, report to stdout and exit.")
+	oCheckLL1 := flag.Bool("check-ll1", false, "Warn about the LL(1) conflicts of the grammar, left recursion included.")
+	oCollapseChains := flag.Bool("collapse-chains", false, "Replace the references to productions consisting of a single name by the end of their chain, report to stderr.")
+	oCommentSyntax := flag.String("comment-syntax", "none", "Comments skipped by the -lexer skeleton: none, c, cpp, shell, lua or re:pattern (a golex pattern).")
+	oCompact := flag.Bool("compact", false, "Leave the comments and blank lines out of the .y file.")
+	oCompat := flag.String("compat", "goyacc-modern", "Target yacc: bison, goyacc ('go tool yacc') or goyacc-modern (golang.org/x/tools/cmd/goyacc).")
+	oConfig := flag.String("config", "", "Read the flags not given on the command line from the config file <arg>, .ebnf2y.toml or .ebnf2y.json of the working directory if blank.")
+	oConflictDot := flag.String("conflict-dot", "", "Write the graph of the rules in yacc conflicts as DOT to <arg> if non blank.")
+	oCoverage := flag.String("coverage", "", "Write the productions not listed in the log file <arg> to stdout and exit.")
+	oCustom := flag.Bool("custom", false, "Emit custom region markers around actions, prologue and epilogue.")
+	oDedupTokens := flag.Bool("dedup-tokens", false, "Replace lexical productions defining the same literal by the first one.")
+	oEllipsis := flag.String("ellipsis-informal", "error", "A … not forming a range: error or skip (ignore it).")
+	oComments := flag.Bool("emit-comments-as-actions", false, "Copy the // doc comments of productions to their yacc rules and node types.")
+	oEncoding := flag.String("encoding", "utf-8", "Input encoding: utf-8, utf-16, utf-16be, utf-16le or latin-1.")
+	oErrorVerbose := flag.Bool("error-verbose", false, "Emit %error-verbose. Requires -compat goyacc-modern.")
+	oExplain := flag.String("explain", "", "Explain the lowering of production <arg> to yacc rules and exit.")
+	oExplainConflict := flag.Uint("explain-conflict", 0, "Write the parser state of the yacc conflict number <arg> to stdout, if non zero.")
+	oExplainInline := flag.Bool("explain-inline", false, "Report to stderr the productions considered by the inlining steps, their use counts and sizes, and whether they were inlined and why.")
+	oFeatures := flag.String("features", "", "Comma separated list of the features whose %if blocks are included.")
+	oFirstComments := flag.Bool("first-comments", false, "Precede the yacc rules of every production by a comment listing its FIRST set.")
+	oFollowAt := flag.String("follow-at", "", "Write the tokens which can follow the space separated tokens <arg> as a JSON list to stdout and exit.")
+	oFuzz := flag.String("fuzz", "", "Write a Go fuzz test of the parser to <arg> if non blank.")
+	oFuzzLexer := flag.String("fuzz-lexer", "newLexer", "Name of the func(string) lexer constructor used by -fuzz.")
+	oFullGo := flag.Bool("full-go", false, "Emit a %union field and a typed %type for every non terminal.")
+	oGoGenerate := flag.String("gogenerate", "", "Write the go:generate directive of this invocation to stderr (-) or into the Go file <arg>.")
 	oIE := flag.Uint("ie", 0, "Inline EBNF. 0: none, 1: used once, 2: all (illegal with -m).")
+	oInlineCandidates := flag.Bool("inline-candidates", false, "Write the productions ranked by the benefit of inlining them to stdout and exit.")
+	oInlineConflicts := flag.Bool("inline-conflicts", false, "Inline the productions the FIRST/FOLLOW analysis finds causing reduce/reduce conflicts, report to stderr.")
+	oInlineTerminals := flag.Bool("inline-terminals", false, "Replace the references to lexical productions of a single literal by the literal.")
 	oIY := flag.Uint("iy", 0, "Inline BNF (.y). 0: none, 1: used once, 2: all (illegal with -m).")
 	oM := flag.Bool("m", false, "Magic: reduce yacc conflicts, maybe (slow).")
+	oMMax := flag.Uint("m-max", 0, "Maximum number of yacc runs of the -m search, 0: unlimited.")
+	oMReorder := flag.Bool("m-reorder", false, "Let -m also try reordering alternatives of productions, reducing the rules never reduced.")
+	oMetricsProm := flag.String("metrics-prom", "", "Write the grammar metrics in the Prometheus text format to <arg> if non blank.")
+	oMutants := flag.String("mutants", "", "Write -samples sentences and their mutations, classified by the grammar, to the directory <arg> and exit.")
 	oMBig := flag.Bool("M", false, "Like -m and report to stderr.")
+	oKeepWhitespace := flag.Bool("keep-whitespace-tokens", false, "Do not let -lexer skip white space without a %skip directive.")
+	oSynthComments := flag.Bool("keep-synthetic-comments", false, "Annotate synthetic rules with the EBNF construct they were created for.")
+	oKeywords := flag.Bool("keywords", false, "Emit a map of the keywords to their tokens and the LookupKeyword function.")
+	oLexer := flag.String("lexer", "", "Write a golex skeleton of the lexer to <arg> if non blank.")
+	oLexerIface := flag.String("lexer-interface", "", "Adapt the existing lexer type[,lex[,error]] to the parser if non blank.")
+	oLiterals := flag.String("literals", "inline", "Single character literals: inline ('x') or declared (as tokens).")
+	oNoLineInfo := flag.Bool("no-line-info", false, "Leave out the grammar positions from the output.")
+	oNoSynthetic := flag.Bool("no-synthetic", false, "Reject the groups, options and repetitions lowered to synthetic productions.")
 	oOE := flag.String("oe", "", "Pretty print EBNF to <arg> if non blank.")
+	oOEStyle := flag.String("oe-style", "normalize", "Alternatives of -oe: normalize or preserve (the productions written with a leading | an alternative per line).")
 	oOut := flag.String("o", "", "Output file. Stdout if left blank.")
+	oParallel := flag.Uint("parallel", 1, "Number of goroutines evaluating the -m inlining candidates.")
 	oPkg := flag.String("pkg", "main", "Package name. Default \"main\".")
+	oPlayground := flag.String("playground", "", "Write a self-contained Go program printing the parse tree of stdin, a lexer, the parse tables and a driver, to <arg> if non blank.")
 	oPrefix := flag.String("p", "", "Prefix for token names, eg. \"_\". Default blank.")
+	oRecursion := flag.String("recursion", "", "Lowering of the repetitions: left (L: | L X) or right (L: | X L) recursive rules. Default left, right for -target recursive-descent.")
+	oRefcounts := flag.String("refcounts", "", "Write the reference counts of the productions to stdout, as text or json, and exit.")
+	oRenameRules := flag.String("rename-rules", "keep", "Output names of non terminals: keep, snake (snake_case) or camel (lowerCamelCase).")
+	oRenameSynthetic := flag.String("rename-synthetic", "", "Rename the synthetic productions by the name=newname lines of the file <arg> if non blank.")
+	oRenameTable := flag.String("rename-table", "", "Write the -rename-rules mapping to <arg> if non blank.")
+	oRepl := flag.Bool("repl", false, "Read commands querying the grammar from stdin, write the answers to stdout and exit.")
+	oReport := flag.String("report-file", "", "Like -M but report to <arg> instead of stderr.")
+	oResult := flag.String("result", "", "Emit the start rules first, setting the variable <arg>, declared in the prologue, if non blank.")
+	oRightFactor := flag.Bool("right-factor", false, "Factor out the common suffixes of alternatives, report to stderr.")
+	oSamples := flag.Uint("samples", 0, "Write <arg> random sentences of the grammar to stdout and exit.")
+	oSamplesDepth := flag.Uint("samples-depth", 8, "Nesting level of productions from which -samples takes the shortest expansion.")
+	oSamplesTest := flag.String("samples-test", "", "Write a Go test checking the parser accepts -samples sentences to <arg> if non blank.")
+	oScaffold := flag.String("scaffold", "", "Write the .y file, a lexer skeleton, the grammar, a Go stub and a Makefile to the directory <arg> if non blank.")
+	oSortTokens := flag.String("sort-tokens", "source", "Order of the %token declarations: source, name or category.")
+	oSourceRefs := flag.Bool("source-refs", false, "Follow every rule alternative by a comment giving its position in the grammar.")
 	oStart := flag.String("start", "SourceFile", "Start production name.")
+	oStrictCamelCase := flag.Bool("strict-camelcase", false, "Warn about the production names neither CamelCase, for non terminals, nor lower case, for lexical productions.")
+	oStrict := flag.Bool("strict-notation", false, "Reject the ebnf2y extensions of the EBNF notation.")
+	oSymType := flag.String("symtype", "yySymType", "Semantic value type of the parser, as named by the goyacc -p prefix, used by the generated lexer code.")
+	oTable := flag.String("table", "", "Write the LALR(1) ACTION and GOTO tables of the grammar as Go data for a table driven parser to <arg> if non blank.")
+	oTarget := flag.String("target", "yacc", "Output format: yacc (.y file), goyacc-generics (.y file with generic List types), bnf (plain BNF), cfg (a LHS -> RHS rule per alternative), lalrpop (.lalrpop file) or recursive-descent (Go parser of a LL(1) grammar).")
+	oTimings := flag.Bool("timings", false, "Write the time spent in the conversion phases to stderr.")
+	oTokenMap := flag.String("token-map", "", "Rename the tokens by the name=newname lines of the file <arg> if non blank.")
+	oTokenOrderFrom := flag.String("token-order-from", "", "Declare first the tokens of the .y file, goyacc generated .go file or token list <arg>, in its order, keeping their numbers, if non blank.")
+	oTokenType := flag.String("token-type", "", "Name of the semantic value type passed to the -lexer-interface lex method if non blank.")
+	oToposort := flag.String("toposort", "", "Write the productions in reverse dependency order to stdout, as text or json, and exit.")
+	oUnparse := flag.String("unparse", "", "Write a Go function writing an AST back as source to <arg> if non blank.")
+	oUpdate := flag.String("update", "", "Rewrite only the changed rules of the existing output file <arg> if non blank.")
+	oVerify := flag.String("verify", "", "Compare the regenerated .y file to the file <arg>, write the differences to stdout and exit with status 1 if any.")
+	oVerifyNormalize := flag.String("verify-normalize", "stamp", "Parts of the .y files left out by -verify: none, or a comma separated list of stamp and lines.")
+	oVersion := flag.Bool("version", false, "Print the ebnf2y version and exit.")
+	oWarnDangling := flag.Bool("warn-ambiguous-dangling", false, "Warn about the options ending productions which start with terminals following them, like the dangling else.")
+	oWarnEpsRep := flag.Bool("warn-epsilon-in-repetition", false, "Warn about repetitions of nullable expressions.")
+	oWarnLeftRec := flag.Bool("warn-left-recursion", false, "Warn about left recursive productions, also through nullable prefixes.")
+	oWarnMixedAssoc := flag.Bool("warn-mixed-assoc", false, "Warn about the operators used by the operator chains, X { op X }, of more than one production.")
+	oWarnNesting := flag.Uint("warn-nesting", 0, "Warn about productions nesting groups, options and repetitions more than <arg> levels deep, 0: never.")
+	oWarnNullStart := flag.Bool("warn-nullable-start", false, "Warn if the start production can derive the empty string.")
+	oWarnRHS := flag.Uint("warn-rhs", 0, "Warn about lowered rule alternatives of more than <arg> terms, 0: never.")
+	oWarnRightRec := flag.Bool("warn-right-recursion", false, "Warn about the lists written right recursive, eg. List = Item List | Item ., suggesting the left recursive rule. yacc and lalrpop targets only.")
+	oWarnSingleUse := flag.Bool("warn-single-use-nonterminal", false, "Warn about the non terminal productions referred to only once, at the reference.")
+	oWarnTokenPrefixes := flag.Bool("warn-token-prefixes", false, "Warn about the literals which are prefixes of other ones, eg. \"<\" of \"<<\".")
+	oWError := flag.Bool("Werror", false, "Treat warnings as errors.")
 	oWR := flag.Uint("wr", 1, "Weight of reduce/reduce conflicts for -m")
+	oWrap := flag.Uint("wrap", 0, "Break the rules of the .y file into lines at most <arg> columns wide, 0: never.")
 	oWS := flag.Uint("ws", 1, "Weight of shift/reduce conflicts for -m")
+	oXref := flag.String("xref", "", "Write the definitions of and references to the productions and literals to stdout, as text or json, and exit.")
 	flag.Parse()
 
-	if *oMBig {
-		*oM = true
+	if *oVersion {
+		fmt.Println(version)
+		return
 	}
-	if *oM {
-		switch {
-		case *oOut == "":
-			log.Fatal("'-m' requires using a named output file ('-o name').")
-		case *oIE > 1 || *oIY > 1:
-			log.Fatal("'-m' cannot be used with '-ie' > 1 or '-iy' > 1.")
+
+	switch fn, err := findConfig(*oConfig); {
+	case err != nil:
+		log.Fatal(err)
+	case fn != "":
+		if err = loadConfig(fn); err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	if flag.NArg() > 1 {
-		log.Fatal("Atmost one input file may be specified.")
+	var tm *timings
+	if *oTimings {
+		tm = newTimings()
+		defer tm.write(os.Stderr)
 	}
 
-	var err error
-	var in *os.File
+	tm.enter("parse")
 
-	switch name := flag.Arg(0); {
-	case name == "":
-		in = os.Stdin
-	default:
-		if in, err = os.Open(name); err != nil {
-			log.Fatal(err)
+	if fn := *oUpdate; fn != "" {
+		switch {
+		case *oOut == "":
+			*oOut = fn
+		case *oOut != fn:
+			log.Fatal("'-update' and '-o' must name the same file.")
 		}
 	}
 
-	grm, err := ebnfutil.Parse(in.Name(), in)
-	if err != nil {
-		log.Fatal(err)
+	if *oVerify != "" {
+		switch {
+		case *oTarget != "yacc":
+			log.Fatal("'-verify' requires '-target yacc'.")
+		case *oOut != "" || *oUpdate != "" || *oScaffold != "" || *oM:
+			log.Fatal("'-verify' cannot be used with '-o', '-update', '-scaffold' or '-m'.")
+		}
+	}
+
+	if dir := *oScaffold; dir != "" {
+		switch {
+		case *oTarget != "yacc":
+			log.Fatal("'-scaffold' requires '-target yacc'.")
+		case *oOut != "" || *oLexer != "" || *oUpdate != "":
+			log.Fatal("'-scaffold' cannot be used with '-o', '-lexer' or '-update'.")
+		}
+
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			log.Fatal(err)
+		}
+
+		*oOut = filepath.Join(dir, scaffoldParser)
+		*oLexer = filepath.Join(dir, scaffoldLexer)
+	}
+
+	if *oReport != "" {
+		*oMBig = true
+	}
+	if *oMBig {
+		*oM = true
+	}
+	if *oParallel == 0 {
+		log.Fatal("'-parallel' must be at least 1.")
+	}
+
+	if *oM {
+		switch {
+		case *oOut == "":
+			log.Fatal("'-m' requires using a named output file ('-o name').")
+		case *oIE > 1 || *oIY > 1:
+			log.Fatal("'-m' cannot be used with '-ie' > 1 or '-iy' > 1.")
+		}
+	}
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	generics := *oTarget == "goyacc-generics"
+	if generics {
+		switch {
+		case *oCompat == "bison":
+			log.Fatal("'-target goyacc-generics' requires '-compat goyacc' or '-compat goyacc-modern'.")
+		case *oFullGo:
+			log.Fatal("'-target goyacc-generics' cannot be used with '-full-go'.")
+		}
+
+		// The yacc target with the generic List types.
+		*oTarget = "yacc"
+	}
+	switch *oTarget {
+	case "yacc":
+		// ok
+	case "bnf", "cfg", "lalrpop", "recursive-descent":
+		if *oM || *oFuzz != "" || *oConflictDot != "" || *oExplainConflict != 0 || *oBisect != "" || *oUpdate != "" || *oMetricsProm != "" || *oTable != "" || *oPlayground != "" {
+			log.Fatal("'-m', '-fuzz', '-conflict-dot', '-explain-conflict', '-bisect', '-update', '-metrics-prom', '-table' and '-playground' require '-target yacc'.")
+		}
+
+		if *oTarget == "cfg" && *oRenameRules != "keep" {
+			log.Fatal("'-target cfg' tells the non terminals by their upper case initial, it cannot be used with '-rename-rules'.")
+		}
+	default:
+		log.Fatalf("-target: unknown %q, must be yacc, goyacc-generics, bnf, cfg, lalrpop or recursive-descent", *oTarget)
+	}
+
+	recursion := *oRecursion
+	switch recursion {
+	case "":
+		recursion = "left"
+		if *oTarget == "recursive-descent" {
+			recursion = "right"
+		}
+	case "left", "right":
+		// ok
+	default:
+		log.Fatalf("-recursion: unknown %q, must be left or right", recursion)
+	}
+
+	c, ok := compats[*oCompat]
+	switch {
+	case !ok:
+		log.Fatalf("-compat: unknown %q, must be bison, goyacc or goyacc-modern", *oCompat)
+	case *oErrorVerbose && !c.errorVerbose:
+		log.Fatalf("-error-verbose: not supported by -compat %s", *oCompat)
+	case *oSortTokens != "source" && *oSortTokens != "name" && *oSortTokens != "category":
+		log.Fatalf("-sort-tokens: unknown %q, must be source, name or category", *oSortTokens)
+	case *oConflictDot != "" && c.report == "":
+		log.Fatalf("-conflict-dot: not supported by -compat %s", *oCompat)
+	case *oConflictDot != "" && *oOut == "":
+		log.Fatal("'-conflict-dot' requires using a named output file ('-o name').")
+	case *oMetricsProm != "" && *oOut == "":
+		log.Fatal("'-metrics-prom' requires using a named output file ('-o name').")
+	case *oExplainConflict != 0 && c.report == "":
+		log.Fatalf("-explain-conflict: not supported by -compat %s", *oCompat)
+	case *oExplainConflict != 0 && *oOut == "":
+		log.Fatal("'-explain-conflict' requires using a named output file ('-o name').")
+	}
+
+	switch {
+	case *oBisect != "" && *oOut == "":
+		log.Fatal("'-bisect' requires using a named output file ('-o name').")
+	case *oBisect != "" && (*oDedupTokens || *oInlineTerminals):
+		log.Fatal("'-bisect' cannot be used with '-dedup-tokens' or '-inline-terminals'.")
+	}
+
+	if *oSourceRefs && *oNoLineInfo {
+		log.Fatal("'-source-refs' cannot be used with '-no-line-info'.")
+	}
+
+	if flag.NArg() > 1 {
+		log.Fatal("Atmost one input file may be specified.")
+	}
+
+	var tokenMap map[string]string
+	if fn := *oTokenMap; fn != "" {
+		var err error
+		if tokenMap, err = loadNameMap(fn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var seed *tokenSeed
+	if fn := *oTokenOrderFrom; fn != "" {
+		var err error
+		if seed, err = loadTokenOrder(fn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var synthMap map[string]string
+	if fn := *oRenameSynthetic; fn != "" {
+		var err error
+		if synthMap, err = loadNameMap(fn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !reIdent.MatchString(*oSymType) {
+		log.Fatalf("-symtype: invalid type name %q", *oSymType)
+	}
+
+	var lexIface *lexerInterface
+	switch {
+	case *oLexerIface != "":
+		var err error
+		if lexIface, err = parseLexerInterface(*oLexerIface, *oTokenType); err != nil {
+			log.Fatal(err)
+		}
+	case *oTokenType != "":
+		log.Fatal("'-token-type' requires '-lexer-interface'.")
+	}
+
+	switch fn := *oGoGenerate; fn {
+	case "":
+		// nop
+	case "-":
+		fmt.Fprintln(os.Stderr, goGenerate())
+	default:
+		if err := injectGoGenerate(fn, goGenerate()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var err error
+	comments, err := commentRules(*oCommentSyntax)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var in *os.File
+
+	switch name := flag.Arg(0); {
+	case name == "":
+		in = os.Stdin
+	default:
+		if in, err = os.Open(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	raw := src
+
+	if src, err = decode(src, *oEncoding); err != nil {
+		log.Fatal(err)
+	}
+
+	features := map[string]bool{}
+	for _, v := range strings.Split(*oFeatures, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			features[v] = true
+		}
+	}
+	src, ds, err := preprocess(in.Name(), src, features)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *oStrict && len(ds) != 0 {
+		switch d := ds[0]; d.name {
+		case assoc:
+			log.Fatalf("%s: @%s: annotations are not allowed by -strict-notation", d.pos, d.args[0])
+		case leadingBar:
+			log.Fatalf("%s: a | before the first alternative is not allowed by -strict-notation", d.pos)
+		case fragment:
+			log.Fatalf("%s: fragment %s: the fragment keyword is not allowed by -strict-notation", d.pos, d.args[0])
+		case typeDecl:
+			log.Fatalf("%s: %s : %s: token type declarations are not allowed by -strict-notation", d.pos, d.args[0], d.args[1])
+		}
+
+		log.Fatalf("%s: %%%s: directives are not allowed by -strict-notation", ds[0].pos, ds[0].name)
+	}
+
+	switch *oEllipsis {
+	case "error":
+		// nop
+	case "skip":
+		if *oStrict {
+			log.Fatal("'-ellipsis-informal skip' cannot be used with '-strict-notation'.")
+		}
+
+		markEllipses(src)
+	default:
+		log.Fatalf("-ellipsis-informal: unknown %q, must be error or skip", *oEllipsis)
+	}
+
+	grm, err := ebnfutil.Parse(in.Name(), bytes.NewReader(src))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = checkStart(grm, *oStart); err != nil {
+		log.Fatal(err)
+	}
+
+	if *oStrictCamelCase {
+		// Of the names as written, before any production is inlined.
+		checkCamelCase(grm)
+		checkWarnings(*oWError)
+	}
+
+	bars := leadingBars(grm, ds)
+
+	var docs map[string][]string
+	if *oComments {
+		docs = docComments(src, grm)
+	}
+
+	if *oEllipsis == "skip" {
+		for _, prod := range grm {
+			prod.Expr = removeName(prod.Expr, informal)
+		}
+	}
+
+	assoc, err := bindAssoc(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	levels, err := precLevels(ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prec, err := bindPrec(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lexStates, tokenStates, err := bindStates(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errors, err := bindErrors(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dedupTokens(grm, prec, levels, *oDedupTokens)
+
+	skip, err := skipTokens(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fragments, err := fragmentTokens(grm, ds, skip)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	types, err := tokenTypes(grm, ds, fragments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inlines, err := targetInlines(grm, ds, *oTarget, *oStart)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *oInlineTerminals {
+		inlineTerminals(grm, prec, levels, skip, tokenStates)
+	}
+
+	noinline, err := noinlineProductions(grm, ds)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *oCollapseChains {
+		pinned := map[string]bool{*oStart: true}
+		for name, prod := range grm {
+			if _, ok := prec[exprOffset(prod.Expr)]; ok || noinline[name] || errors[name] != "" {
+				pinned[name] = true
+			}
+		}
+		collapseChains(grm, pinned)
+	}
+
+	if fn := *oBisect; fn != "" {
+		b := &bisector{
+			compat:      c,
+			declareLits: *oLiterals == "declared",
+			errors:      errors,
+			levels:      levels,
+			new:         grm,
+			out:         *oOut,
+			prec:        prec,
+			skip:        skip,
+			start:       *oStart,
+			strict:      *oStrict,
+			tPrefix:     *oPrefix,
+			wr:          int(*oWR),
+			ws:          int(*oWS),
+		}
+		if err = b.loadOld(fn, *oEncoding, features, *oEllipsis == "skip", len(src)); err != nil {
+			log.Fatal(err)
+		}
+
+		tm.enter("bisect")
+		if err = b.run(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if err = expandWildcard(grm, *oStrict); err != nil {
+		log.Fatal(err)
+	}
+
+	lexical := ebnfutil.Grammar{}
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			lexical[name] = prod
+		}
+	}
+
+	dropUnusedTokens(grm, *oStart, skip)
+	checkWarnings(*oWError)
+	if err := checkReachable(grm, *oStart); err != nil {
+		log.Fatal(err)
 	}
 
 	if err := grm.Verify(*oStart); err != nil {
 		log.Fatal(err)
 	}
 
+	if n := *oWarnNesting; n != 0 {
+		checkNesting(grm, int(n))
+		checkWarnings(*oWError)
+	}
+
+	if *oWarnSingleUse {
+		checkSingleUse(grm, *oStart)
+		checkWarnings(*oWError)
+	}
+
+	if *oWarnTokenPrefixes {
+		checkTokenPrefixes(grm, singleLiterals(grm, skip, tokenStates))
+		checkWarnings(*oWError)
+	}
+
+	if *oWarnMixedAssoc {
+		checkMixedAssoc(grm)
+		checkWarnings(*oWError)
+	}
+
+	if *oWarnRightRec && (*oTarget == "yacc" || *oTarget == "lalrpop") {
+		// LL parsers need the right recursion.
+		checkRightRecursion(grm, recursion == "right")
+		checkWarnings(*oWError)
+	}
+
+	if *oWarnLeftRec || *oCheckLL1 || *oWarnEpsRep || *oWarnNullStart || *oWarnDangling {
+		a := newAnalysis(grm, *oStart)
+		if *oCheckLL1 {
+			a.checkLL1()
+		}
+		if *oWarnLeftRec || *oCheckLL1 {
+			checkLeftRecursion(grm, a)
+		}
+		if *oWarnEpsRep {
+			checkEpsilonRepetition(grm, a)
+		}
+		if *oWarnDangling {
+			checkDangling(grm, a)
+		}
+		if *oWarnNullStart && a.Nullable(*oStart) {
+			warn(grm[*oStart].Pos(), "start production %s accepts the empty input", *oStart)
+		}
+		checkWarnings(*oWError)
+	}
+
+	if format := *oRefcounts; format != "" {
+		if err = writeRefcounts(os.Stdout, countRefs(grm), format); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if format := *oXref; format != "" {
+		if err = writeXref(os.Stdout, xref(grm), format); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *oInlineCandidates {
+		if err = writeInlineCandidates(os.Stdout, inlineCandidates(grm, *oStart)); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if fn := *oCoverage; fn != "" {
+		all, covered, err := coverage(grm, fn)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err = writeCoverage(os.Stdout, all, covered); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if format := *oToposort; format != "" {
+		if err = writeToposort(os.Stdout, toposort(grm), format); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if dir := *oMutants; dir != "" {
+		n := *oSamples
+		if n == 0 {
+			n = 10
+		}
+		s := newSampler(grm, int(*oSamplesDepth))
+		tm.enter("mutants")
+		if err = writeMutants(dir, s, newRecognizer(grm, int(*oAcceptDepth)), *oStart, int(n)); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	switch n, fn := *oSamples, *oSamplesTest; {
+	case fn != "":
+		if n == 0 {
+			n = 10
+		}
+		s := newSampler(grm, int(*oSamplesDepth))
+		tm.enter("samples")
+		var a []string
+		for i := uint(0); i < n; i++ {
+			a = append(a, s.sample(*oStart))
+		}
+		create(fn, func(w io.Writer) error { return renderSamplesTest(w, *oPkg, lexIface.adapt(*oFuzzLexer+"(src)"), a) })
+	case n != 0:
+		s := newSampler(grm, int(*oSamplesDepth))
+		tm.enter("samples")
+		for i := uint(0); i < n; i++ {
+			fmt.Println(s.sample(*oStart))
+		}
+		return
+	}
+
+	if src := *oAccept; src != "" {
+		if !newRecognizer(grm, int(*oAcceptDepth)).accept(os.Stdout, *oStart, tokens(strings.Fields(src))) {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if *oRepl {
+		if err = repl(os.Stdin, os.Stdout, grm, *oStart, int(*oAcceptDepth)); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	var ex *explainer
+	if name := *oExplain; name != "" {
+		if _, ok := grm[name]; !ok {
+			log.Fatalf("-explain: undefined production %q", name)
+		}
+
+		ex = &explainer{name, os.Stdout}
+		ex.ebnf("EBNF", grm)
+		tm.enter("analysis")
+		ex.sets(newAnalysis(grm, *oStart))
+	}
+
+	var tr *inlineTrace
+	if *oExplainInline {
+		tr = newInlineTrace(*oStart)
+	}
+	tm.enter("inline")
 	switch *oIE {
 	case 0:
 		// nop
 	case 1:
-		if err = grm.Inline(*oStart, false); err != nil {
+		if err = tr.run(grm, "-ie 1", "used-once", nil, func() error { return grm.Inline(*oStart, false) }); err != nil {
 			log.Fatal(err)
 		}
 	case 2:
-		if err = grm.Inline(*oStart, true); err != nil {
+		if err = tr.run(grm, "-ie 2", "all", nil, func() error { return grm.Inline(*oStart, true) }); err != nil {
 			log.Fatal(err)
 		}
 	default:
 		log.Fatal("-ie: <arg> must be 0, 1 or 2")
 	}
-
-	if fn := *oOE; fn != "" {
-		f, err := os.Create(fn)
-		if err != nil {
+	for _, name := range inlines {
+		if err = tr.run(grm, "%inline", "%inline", []string{name}, func() error { return grm.InlineOne(name, true) }); err != nil {
 			log.Fatal(err)
 		}
+	}
+	if *oInlineConflicts {
+		tried := map[string]bool{}
+		an := newAnalysis(grm, *oStart)
+		for {
+			a := findSuspects(grm, an, *oStart, tried)
+			if len(a) == 0 {
+				break
+			}
 
-		b := []byte(grm.String())
-		n, err := f.Write(b)
-		if n != len(b) || err != nil {
-			log.Fatal(err)
-		}
+			for _, s := range a {
+				tried[s.name] = true
+				m := snapshot(grm)
+				if err = tr.run(grm, "-inline-conflicts", "reduce/reduce suspect", []string{s.name}, func() error { return grm.InlineOne(s.name, true) }); err != nil {
+					log.Fatal(err)
+				}
 
-		if err = f.Close(); err != nil {
-			log.Fatal(err)
+				an.Invalidate(modified(grm, m)...)
+
+				wlog.Printf("inlined %s because it caused a reduce/reduce between %s and %s in %s on %s", s.name, s.name, s.other, s.in, strings.Join(s.on, " "))
+			}
 		}
 	}
+	if *oRightFactor {
+		rightFactor(grm, prec)
+	}
+	if ex != nil && *oIE != 0 {
+		ex.ebnf(fmt.Sprintf("EBNF after -ie %d", *oIE), grm)
+	}
+
+	if fn := *oOE; fn != "" {
+		create(fn, func(w io.Writer) error { return writeEBNF(w, grm, *oOEStyle, bars) })
+	}
+
+	switch *oLiterals {
+	case "inline", "declared":
+		// ok
+	default:
+		log.Fatalf("-literals: unknown %q, must be inline or declared", *oLiterals)
+	}
 
 	j := &job{
-		pkg:     *oPkg,
-		grm:     grm,
-		names:   map[string]bool{},
-		tPrefix: *oPrefix,
-	}
-	for _, name := range []string{
-		"break", "default", "func", "interface", "select",
-		"case", "defer", "go", "map", "struct",
-		"chan", "else", "goto", "package", "switch",
-		"const", "fallthrough", "if", "range", "type",
-		"continue", "for", "import", "return", "var",
-	} {
+		altRules:        *oAltRules,
+		assoc:           assoc,
+		declareLiterals: *oLiterals == "declared",
+		compat:          c,
+		errors:          errors,
+		errorVerbose:    *oErrorVerbose,
+		custom:          *oCustom,
+		docs:            docs,
+		fragments:       fragments,
+		tokenTypes:      types,
+		fullGo:          *oFullGo,
+		keepWhitespace:  *oKeepWhitespace,
+		comments:        comments,
+		keywords:        *oKeywords,
+		tokenMap:        tokenMap,
+		tokenOrder:      seed,
+		synthMap:        synthMap,
+		lexIface:        lexIface,
+		pkg:             *oPkg,
+		result:          *oResult,
+		rightRecursion:  recursion == "right",
+		grm:             grm,
+		names:           map[string]bool{},
+		noLineInfo:      *oNoLineInfo,
+		sourceRefs:      *oSourceRefs,
+		firstComments:   *oFirstComments,
+		order:           tokenOrder(grm),
+		synthetic:       map[string]string{},
+		children:        map[string][]string{},
+		lexical:         lexical,
+		lexStates:       lexStates,
+		skip:            skip,
+		sortTokens:      *oSortTokens,
+		tokenStates:     tokenStates,
+		symType:         *oSymType,
+		synthComments:   *oSynthComments,
+		levels:          levels,
+		prec:            prec,
+		tPrefix:         *oPrefix,
+		wrap:            int(*oWrap),
+	}
+	for _, name := range keywords {
 		j.names[name] = true
 	}
 	for name := range grm {
@@ -502,30 +1683,198 @@ func main() {
 
 		j.names[name] = true
 	}
+	if generics {
+		j.listType = j.inventName("List", "")
+	}
+	if *oTarget == "lalrpop" {
+		// Of the EBNF, lalrpop supports repetitions and options.
+		if err = j.renameRules(*oRenameRules); err != nil {
+			log.Fatal(err)
+		}
+
+		tm.enter("emit")
+		create(*oOut, func(w io.Writer) error { return j.renderLalrpop(w, *oStart) })
+		return
+	}
+
+	if *oNoSynthetic {
+		if err = checkNoSynthetic(j.grm); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var metrics []metric
+	if *oMetricsProm != "" {
+		// Of the EBNF grammar, before the lowering to BNF.
+		metrics = grammarMetrics(grm)
+	}
+
 	start := j.inventName("Start", "")
+	if tr != nil {
+		tr.skip[start] = true
+	}
 	j.grm[start] = &ebnf.Production{
 		Name: &ebnf.Name{String: start},
 		Expr: &ebnf.Name{String: *oStart},
 	}
 
+	tm.enter("bnf")
 	j.toBnf(*oStart)
+	if err = j.checkSynthMap(); err != nil {
+		log.Fatal(err)
+	}
+	followAt := false
+	flag.Visit(func(f *flag.Flag) { followAt = followAt || f.Name == "follow-at" })
+	if followAt {
+		// The empty prefix asks for the tokens starting the input.
+		ok, err := j.followAt(os.Stdout, start, strings.Fields(*oFollowAt))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+
+		return
+	}
+	if ex != nil {
+		ex.bnf("BNF", j)
+	}
+	tm.enter("inline")
 	switch *oIY {
 	case 0:
 		// nop
 	case 1:
-		if err = j.grm.Inline(*oStart, false); err != nil {
+		if err = tr.run(j.grm, "-iy 1", "used-once", nil, func() error { return j.grm.Inline(*oStart, false) }); err != nil {
 			log.Fatal(err)
 		}
 	case 2:
-		if err = j.grm.Inline(*oStart, true); err != nil {
+		if err = tr.run(j.grm, "-iy 2", "all", nil, func() error { return j.grm.Inline(*oStart, true) }); err != nil {
 			log.Fatal(err)
 		}
 	default:
 		log.Fatal("-ie: <arg> must be 0, 1 or 2")
 	}
+	if n := *oWarnRHS; n != 0 {
+		j.checkRHS(int(n))
+		checkWarnings(*oWError)
+	}
+	if ex != nil {
+		if *oIY != 0 {
+			ex.bnf(fmt.Sprintf("BNF after -iy %d", *oIY), j)
+		}
+		tm.enter("emit")
+		if err = ex.yacc(j, start); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if err = j.renameRules(*oRenameRules); err != nil {
+		log.Fatal(err)
+	}
+
+	if fn := *oRenameTable; fn != "" {
+		create(fn, j.renameTable)
+	}
+
+	switch *oTarget {
+	case "bnf":
+		tm.enter("emit")
+		create(*oOut, func(w io.Writer) error { return j.renderBNF(w, start, *oStart) })
+		return
+	case "cfg":
+		tm.enter("emit")
+		create(*oOut, func(w io.Writer) error { return j.renderCFG(w, start, *oStart) })
+		return
+	case "recursive-descent":
+		tm.enter("emit")
+		create(*oOut, func(w io.Writer) error { return j.renderRD(w, start, *oStart) })
+		return
+	}
+
+	if fn := *oASTSchema; fn != "" {
+		// After the -m search, like -ast-stringer.
+		defer create(fn, func(w io.Writer) error { return j.renderSchema(w, start, *oStart) })
+	}
+
+	if fn := *oASTStringer; fn != "" {
+		// After the -m search, which may remove node types.
+		defer create(fn, func(w io.Writer) error { return j.renderStringer(w, start) })
+	}
+
+	if fn := *oUnparse; fn != "" {
+		// After the -m search, like -ast-stringer.
+		defer create(fn, func(w io.Writer) error { return j.renderUnparser(w, start) })
+	}
+
+	if fn := *oLexer; fn != "" {
+		// Uses the token names of the last emitted .y file.
+		defer create(fn, j.renderLexer)
+	}
+
+	if fn := *oTable; fn != "" {
+		// Uses the token names of the last emitted .y file, like -lexer.
+		defer create(fn, func(w io.Writer) error { return j.renderTable(w, fn, start) })
+	}
+
+	if fn := *oPlayground; fn != "" {
+		// Uses the token names of the last emitted .y file, like -lexer.
+		defer create(fn, func(w io.Writer) error { return j.renderPlayground(w, fn, start) })
+	}
+
+	if dir := *oScaffold; dir != "" {
+		base := "grammar.ebnf"
+		if fn := flag.Arg(0); fn != "" {
+			base = filepath.Base(fn)
+		}
+		j.scaffold(dir, base, raw)
+	}
+
+	if fn := *oFuzz; fn != "" {
+		create(fn, func(w io.Writer) error { return j.renderFuzz(w, j.lexIface.adapt(*oFuzzLexer+"(src)")) })
+	}
+
+	var regions map[string][]byte
+	s := *oOut
+	if *oVerify != "" {
+		s = *oVerify
+	}
+	if s != "" {
+		if regions, err = loadCustom(s); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Of the final output, after -m.
+	var dropped []string
+	defer func() {
+		for _, key := range dropped {
+			warn(scanner.Position{}, "custom region %q dropped, it has no place in the output", key)
+		}
+		checkWarnings(*oWError)
+	}()
+
+	var prev []byte
+	var updated []string
+	if fn := *oUpdate; fn != "" {
+		if prev, err = ioutil.ReadFile(fn); err != nil {
+			log.Fatal(err)
+		}
+
+		defer func() {
+			wlog.Printf("-update: %d of %d rules changed", len(updated), len(j.rep.NonTerminals))
+			for _, name := range updated {
+				wlog.Printf("\t%s", name)
+			}
+		}()
+	}
 
 	var out *os.File
 	emit := func() {
+		defer tm.enter(tm.enter("emit"))
 		n0 := map[string]bool{}
 		for name := range j.names {
 			n0[name] = true
@@ -538,11 +1887,51 @@ func main() {
 		}
 
 		w := bufio.NewWriter(out)
+		var buf bytes.Buffer
+		cw := newCustomWriter(w, regions)
+		if prev != nil || *oVerify != "" {
+			cw = newCustomWriter(&buf, regions)
+		}
 		j.checkTerminals(start)
-		if err = j.render(w, start); err != nil {
+		var rw io.Writer = cw
+		if *oCompact {
+			rw = &compactWriter{w: cw}
+		}
+		if err = j.render(rw, start); err != nil {
 			log.Fatal(err)
 		}
 
+		if c, ok := rw.(*compactWriter); ok {
+			if err = c.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err = cw.Close(); err != nil {
+			log.Fatal(err)
+		}
+
+		dropped = cw.dropped()
+
+		if fn := *oVerify; fn != "" {
+			ok, err := verify(os.Stdout, fn, buf.Bytes(), *oVerifyNormalize)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if !ok {
+				os.Exit(1)
+			}
+		}
+
+		if prev != nil {
+			var b []byte
+			b, updated = update(prev, buf.Bytes())
+			if _, err = w.Write(b); err != nil {
+				log.Fatal(err)
+			}
+		}
+
 		if err = w.Flush(); err != nil {
 			log.Fatal(err)
 		}
@@ -553,8 +1942,50 @@ func main() {
 		j.names = n0
 	}
 
+	// Of the final .y file, after -m.
+	final := &yaccRun{c: j.compat, fn: func() string { return out.Name() }}
+	if n := *oExplainConflict; n != 0 {
+		defer func() {
+			if err := j.explainConflict(os.Stdout, final.report(), int(n)); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if fn := *oConflictDot; fn != "" {
+		defer func() {
+			create(fn, func(w io.Writer) error { return renderConflictDot(w, parseConflicts(final.report())) })
+		}()
+	}
+
+	if fn := *oMetricsProm; fn != "" {
+		defer func() {
+			create(fn, func(w io.Writer) error {
+				return writeMetrics(w, in.Name(), append(metrics, j.yaccMetrics(final.output())...))
+			})
+		}()
+	}
+
 	log2 := log.New(os.Stderr, "[-M] ", 0)
+	var report bytes.Buffer
+	if *oReport != "" {
+		log2 = log.New(&report, "[-M] ", 0)
+	}
 	tried := map[string]bool{}
+	runs := 0
+	eval := func() int {
+		defer tm.enter(tm.enter("magic"))
+		runs++
+		return score(j.compat, "", out.Name(), int(*oWR), int(*oWS))
+	}
+	// The conflicts depend only on the set of rules, the order of the rules
+	// decides which one is reduced in a reduce/reduce conflict.
+	reduced := func() int {
+		defer tm.enter(tm.enter("magic"))
+		runs++
+		return neverReduced(j.compat.run(out.Name()))
+	}
+	exhausted := func() bool { return *oMMax != 0 && runs >= int(*oMMax) }
 magic:
 	emit()
 	if !*oM {
@@ -563,33 +1994,45 @@ magic:
 
 	g0 := j.grm.Normalize()
 	bestName := ""
-	best0 := score(out.Name(), int(*oWR), int(*oWS))
+	best0 := eval()
 	var best int
 	if best0 <= 0 {
 		goto magic2
 	}
 
 	best = best0
-	for name := range j.grm {
-		g1 := g0.Normalize()
-		if err = g1.InlineOne(name, true); err != nil {
-			log.Fatal(err)
+	{
+		// Ties broken by name, whatever -parallel is.
+		var names []string
+		for name := range j.grm {
+			names = append(names, name)
 		}
-
-		j.grm = g1
-		emit()
-		if n := score(out.Name(), int(*oWR), int(*oWS)); n < best {
-			best = n
-			bestName = name
-			if *oMBig {
-				log2.Printf("%q: %d", bestName, best)
+		sort.Strings(names)
+		if m := int(*oMMax) - runs; *oMMax != 0 && m < len(names) {
+			if m < 0 {
+				m = 0
+			}
+			names = names[:m]
+		}
+		prev := tm.enter("magic")
+		scores := j.inlineScores(g0, names, start, int(*oParallel), int(*oWR), int(*oWS))
+		tm.enter(prev)
+		runs += len(names)
+		for i, n := range scores {
+			if n < best {
+				best = n
+				bestName = names[i]
+				if *oMBig {
+					log2.Printf("%q: %d", bestName, best)
+				}
 			}
 		}
 	}
 
 	j.grm = g0
 	if best < best0 {
-		if g0.InlineOne(bestName, true); err != nil {
+		why := fmt.Sprintf("magic-chosen, conflicts %d -> %d", best0, best)
+		if err = tr.run(g0, "-m", why, []string{bestName}, func() error { return g0.InlineOne(bestName, true) }); err != nil {
 			log.Fatal(err)
 		}
 
@@ -598,6 +2041,47 @@ magic:
 		}
 		goto magic
 	}
+
+	if nr := 0; *oMReorder {
+		nr = reduced()
+		var names []string
+		for name := range j.grm {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := j.synthetic[name]; ok {
+				// The actions of the repetitions depend on the order.
+				continue
+			}
+
+			prod := j.grm[name]
+			alts, ok := prod.Expr.(ebnf.Alternative)
+			if !ok {
+				continue
+			}
+
+			for i := 1; i < len(alts) && nr != 0 && !exhausted(); i++ {
+				a := append(ebnf.Alternative{alts[i]}, alts[:i]...)
+				a = append(a, alts[i+1:]...)
+				j.grm[name] = &ebnf.Production{Name: prod.Name, Expr: a}
+				emit()
+				if n := reduced(); n < nr {
+					if *oMBig {
+						var rules []string
+						for _, v := range a {
+							rules = append(rules, j.str(v))
+						}
+						log2.Printf("Reordered %q: %s: rules never reduced %d -> %d", name, strings.Join(rules, " | "), nr, n)
+					}
+					nr, alts, prod = n, a, j.grm[name]
+					continue
+				}
+
+				j.grm[name] = prod
+			}
+		}
+	}
 	emit()
 
 magic2:
@@ -606,21 +2090,15 @@ magic2:
 		return
 	}
 
-	cmd := exec.Command("go", "tool", "yacc", out.Name())
-	var yout bytes.Buffer
-	cmd.Stdout = &yout
-	if err = cmd.Run(); err != nil {
-		log.Fatalf("executing 'go tool yacc': %v", err)
-	}
-
+	yout := j.compat.run(out.Name())
 	if *oMBig {
 		log2.Println("----")
-		a := strings.Split(strings.TrimSpace(yout.String()), "\n")
+		a := strings.Split(strings.TrimSpace(yout), "\n")
 		for _, v := range a {
 			log2.Println(v)
 		}
 	}
-	a := strings.Split(yout.String(), "\n")
+	a := strings.Split(yout, "\n")
 next:
 	for _, v := range a {
 		s := strings.TrimSpace(v)
@@ -639,7 +2117,7 @@ next:
 					}
 
 					tried[name] = true
-					if err = j.grm.InlineOne(name, true); err != nil {
+					if err = tr.run(j.grm, "-m", "magic-chosen, rule never reduced", []string{name}, func() error { return j.grm.InlineOne(name, true) }); err != nil {
 						log.Fatal(err)
 					}
 
@@ -648,4 +2126,10 @@ next:
 			}
 		}
 	}
+
+	if fn := *oReport; fn != "" {
+		if err = ioutil.WriteFile(fn, report.Bytes(), 0666); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
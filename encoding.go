@@ -0,0 +1,66 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+)
+
+// decode returns src, encoded by enc, as UTF-8 without a leading byte order
+// mark. A UTF-16 byte order mark is decoded to U+FEFF and removed as well.
+func decode(src []byte, enc string) ([]byte, error) {
+	switch enc {
+	case "utf-8":
+		if bytes.HasPrefix(src, bomUTF16BE) || bytes.HasPrefix(src, bomUTF16LE) {
+			return nil, errors.New("the input starts with a UTF-16 byte order mark, use -encoding utf-16")
+		}
+
+		return bytes.TrimPrefix(src, bomUTF8), nil
+	case "utf-16":
+		// Big endian unless the byte order mark says otherwise.
+		return decodeUTF16(src, !bytes.HasPrefix(src, bomUTF16LE))
+	case "utf-16be":
+		return decodeUTF16(src, true)
+	case "utf-16le":
+		return decodeUTF16(src, false)
+	case "latin-1":
+		var buf bytes.Buffer
+		for _, b := range src {
+			buf.WriteRune(rune(b))
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("-encoding: unknown %q, must be utf-8, utf-16, utf-16be, utf-16le or latin-1", enc)
+	}
+}
+
+func decodeUTF16(src []byte, bigEndian bool) ([]byte, error) {
+	if len(src)%2 != 0 {
+		return nil, errors.New("UTF-16 input of odd length")
+	}
+
+	a := make([]uint16, len(src)/2)
+	for i := range a {
+		hi, lo := src[2*i], src[2*i+1]
+		if !bigEndian {
+			hi, lo = lo, hi
+		}
+		a[i] = uint16(hi)<<8 | uint16(lo)
+	}
+	var buf bytes.Buffer
+	for _, r := range utf16.Decode(a) {
+		buf.WriteRune(r)
+	}
+	return bytes.TrimPrefix(buf.Bytes(), bomUTF8), nil
+}
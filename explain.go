@@ -0,0 +1,115 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+	"github.com/cznic/strutil"
+)
+
+// explainer writes the stages of lowering of a single production for
+// -explain.
+type explainer struct {
+	name string
+	w    io.Writer
+}
+
+func (e *explainer) title(s string) {
+	fmt.Fprintf(e.w, "# %s\n\n", s)
+}
+
+// ebnf writes the named production as found in grm.
+func (e *explainer) ebnf(title string, grm ebnfutil.Grammar) {
+	e.title(title)
+	prod, ok := grm[e.name]
+	if !ok {
+		fmt.Fprintf(e.w, "%s was inlined.\n\n", e.name)
+		return
+	}
+
+	fmt.Fprintf(e.w, "%s\n", ebnfutil.Grammar{e.name: prod})
+}
+
+// sets writes the nullable, FIRST and FOLLOW properties of the named non
+// terminal production.
+func (e *explainer) sets(a *analysis) {
+	if !ast.IsExported(e.name) {
+		return
+	}
+
+	e.title("Sets")
+	fmt.Fprintf(e.w, "nullable: %t\n", a.Nullable(e.name))
+	fmt.Fprintf(e.w, "FIRST:    %s\n", strings.Join(a.First(e.name).Sorted(), " "))
+	fmt.Fprintf(e.w, "FOLLOW:   %s\n\n", strings.Join(a.Follow(e.name).Sorted(), " "))
+}
+
+// bnf writes the named production and the synthetic productions derived from
+// it as found in j.grm.
+func (e *explainer) bnf(title string, j *job) {
+	e.title(title)
+	g := ebnfutil.Grammar{}
+	for _, name := range j.derived(e.name) {
+		g[name] = j.grm[name]
+	}
+	if len(g) == 0 {
+		fmt.Fprintf(e.w, "%s was inlined.\n\n", e.name)
+		return
+	}
+
+	fmt.Fprintf(e.w, "%s\n", g)
+}
+
+// yacc writes the yacc rules of the named production and the synthetic
+// productions derived from it.
+func (e *explainer) yacc(j *job, start string) (err error) {
+	e.title("yacc")
+	j.checkTerminals(start)
+	if err = j.render(ioutil.Discard, start); err != nil {
+		return
+	}
+
+	if !ast.IsExported(e.name) {
+		if token, ok := j.term2name[e.name]; ok {
+			fmt.Fprintf(e.w, "%%token\t%s\n", token)
+		}
+		return
+	}
+
+	f := strutil.IndentFormatter(e.w, "\t")
+	for _, name := range j.derived(e.name) {
+		// Numbered like in the .y file.
+		rule := j.rules[name]
+		j.production(f, name, start, &rule)
+	}
+	return
+}
+
+// derived returns the sorted names of the named production and of all the
+// synthetic productions derived from it, directly or indirectly, which are
+// present in j.grm.
+func (j *job) derived(name string) (a []string) {
+	for n := range j.grm {
+		for s := n; ; {
+			if s == name {
+				a = append(a, n)
+				break
+			}
+
+			var ok bool
+			if s, ok = j.synthetic[s]; !ok {
+				break
+			}
+		}
+	}
+	sort.Strings(a)
+	return
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+
+	"github.com/cznic/strutil"
+)
+
+// renderFuzz writes a Go fuzz test feeding arbitrary input to the parser
+// through the lexer value of the Go expression lexer, of variable src.
+func (j *job) renderFuzz(w io.Writer, lexer string) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "Fuzz test", "The seed corpus, if any, goes to testdata/fuzz/FuzzParse.")
+	f.Format(`package %s
+
+import (
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {%i
+//%s f.Add(...) seed inputs
+f.Fuzz(func(t *testing.T, src string) {%i
+yyParse(%s) // Must not panic.
+%u})
+%u}
+`, j.pkg, todo, lexer)
+	return sw.err
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+
+	"golang.org/x/exp/ebnf"
+)
+
+// listElem returns, for -target goyacc-generics, the Go type of the items of
+// the named repetition if every iteration appends one item of the same type,
+// a non terminal, also returned as prod, or a literal, a string. It returns
+// "", "" otherwise.
+func (j *job) listElem(name string) (typ, prod string) {
+	if j.listType == "" || !j.repetitions[name] {
+		return "", ""
+	}
+
+	a := alternatives(j.grm[name].Expr)
+	if len(a) < 2 {
+		return "", ""
+	}
+
+	for i, v := range a[1:] {
+		x, ok := v.(ebnf.Sequence)
+		if !ok || len(x) != 2 {
+			return "", ""
+		}
+
+		var t, p string
+		switch y := j.iteration(x)[0].(type) {
+		case *ebnf.Name:
+			if !ast.IsExported(y.String) {
+				return "", ""
+			}
+
+			t, p = j.ruleName(y.String), y.String
+		case *ebnf.Token:
+			t = "string"
+		default:
+			return "", ""
+		}
+		if i != 0 && (t != typ || p != prod) {
+			return "", ""
+		}
+
+		typ, prod = t, p
+	}
+	return
+}
+
+// nodeType returns the Go type of the values of the named repetition, a
+// List of its items if they are of the same type, else a slice of them.
+func (j *job) nodeType(name string) string {
+	if typ, _ := j.listElem(name); typ != "" {
+		return j.ruleName(name)
+	}
+
+	return "[]" + j.ruleName(name)
+}
+
+// listItem returns the item s, $2, appended to the named repetition, asserted
+// to the type of the list items if it is a List itself.
+func (j *job) listItem(name, s string) string {
+	if typ, prod := j.listElem(name); prod != "" {
+		if t, _ := j.listElem(prod); t != "" {
+			return s + ".(" + typ + ")"
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const goGeneratePrefix = "//go:generate ebnf2y"
+
+// goGenerateArg returns s quoted if go generate would not see it as a single
+// argument otherwise.
+func goGenerateArg(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// goGenerate returns the go:generate directive running ebnf2y with the flags
+// set on the command line, except -gogenerate, and the same input file.
+func goGenerate() string {
+	a := append([]string{goGeneratePrefix}, setFlags(map[string]bool{"gogenerate": true})...)
+	for _, v := range flag.Args() {
+		a = append(a, goGenerateArg(v))
+	}
+	return strings.Join(a, " ")
+}
+
+// setFlags returns the arguments of the flags set on the command line, except
+// the ones in skip.
+func setFlags(skip map[string]bool) (a []string) {
+	flag.Visit(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+
+		if b, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		}); ok && b.IsBoolFlag() {
+			switch v := f.Value.String(); v {
+			case "true":
+				a = append(a, "-"+f.Name)
+			default:
+				a = append(a, "-"+f.Name+"="+v)
+			}
+			return
+		}
+
+		a = append(a, "-"+f.Name, goGenerateArg(f.Value.String()))
+	})
+	return
+}
+
+// injectGoGenerate replaces the first go:generate directive running ebnf2y
+// in the named Go file by line. If there is none, line is inserted after the
+// package clause.
+func injectGoGenerate(fn, line string) error {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	pkg := -1
+	for i, v := range lines {
+		s := bytes.TrimSpace(v)
+		switch {
+		case bytes.HasPrefix(s, []byte(goGeneratePrefix+" ")):
+			lines[i] = []byte(line + "\n")
+			return writeLines(fn, lines)
+		case pkg < 0 && bytes.HasPrefix(s, []byte("package ")):
+			pkg = i
+		}
+	}
+	if pkg < 0 {
+		return fmt.Errorf("%s: no package clause", fn)
+	}
+
+	if !bytes.HasSuffix(lines[pkg], []byte("\n")) {
+		lines[pkg] = append(lines[pkg], '\n')
+	}
+	lines = append(lines[:pkg+1], append([][]byte{[]byte("\n" + line + "\n")}, lines[pkg+1:]...)...)
+	return writeLines(fn, lines)
+}
+
+func writeLines(fn string, lines [][]byte) error {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fn, bytes.Join(lines, nil), fi.Mode())
+}
@@ -0,0 +1,674 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// wildcard is the name of the "any single terminal" term.
+const wildcard = "_any"
+
+// walk calls f for expr and all of its subexpressions, depth first.
+func walk(expr ebnf.Expression, f func(ebnf.Expression)) {
+	f(expr)
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			walk(v, f)
+		}
+	case ebnf.Sequence:
+		for _, v := range x {
+			walk(v, f)
+		}
+	case *ebnf.Group:
+		walk(x.Body, f)
+	case *ebnf.Option:
+		walk(x.Body, f)
+	case *ebnf.Repetition:
+		walk(x.Body, f)
+	}
+}
+
+func has(grm ebnfutil.Grammar, name string) bool {
+	_, ok := grm[name]
+	return ok
+}
+
+// uniqueName returns prefix, or prefix followed by the smallest positive
+// integer, which is not a production name in grm.
+func uniqueName(grm ebnfutil.Grammar, prefix string) string {
+	if !has(grm, prefix) {
+		return prefix
+	}
+
+	for i := 1; ; i++ {
+		if s := fmt.Sprintf("%s%d", prefix, i); !has(grm, s) {
+			return s
+		}
+	}
+}
+
+// expandWildcard replaces all references to the wildcard, if any, by a
+// reference to a new non terminal production having an alternative for every
+// terminal used by the non terminal productions of grm. If strict is set, a
+// reference to the wildcard is an error instead.
+func expandWildcard(grm ebnfutil.Grammar, strict bool) error {
+	if has(grm, wildcard) {
+		return nil
+	}
+
+	var refs []*ebnf.Name
+	names, lits := map[string]bool{}, map[string]bool{}
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			switch x := expr.(type) {
+			case *ebnf.Name:
+				switch {
+				case x.String == wildcard:
+					refs = append(refs, x)
+				case !ast.IsExported(x.String):
+					names[x.String] = true
+				}
+			case *ebnf.Token:
+				lits[x.String] = true
+			}
+		})
+	}
+	switch {
+	case len(refs) == 0:
+		return nil
+	case strict:
+		return fmt.Errorf("%s: %s is not allowed by -strict-notation", refs[0].StringPos, wildcard)
+	}
+
+	a := []string{}
+	for name := range names {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	alt := ebnf.Alternative{}
+	for _, name := range a {
+		alt = append(alt, &ebnf.Name{StringPos: refs[0].StringPos, String: name})
+	}
+	a = a[:0]
+	for lit := range lits {
+		a = append(a, lit)
+	}
+	sort.Strings(a)
+	for _, lit := range a {
+		alt = append(alt, &ebnf.Token{StringPos: refs[0].StringPos, String: lit})
+	}
+
+	var expr ebnf.Expression
+	switch len(alt) {
+	case 0:
+		log.Fatalf("%s: %s used in a grammar without terminals", refs[0].StringPos, wildcard)
+	case 1:
+		expr = alt[0]
+	default:
+		expr = alt
+	}
+
+	name := uniqueName(grm, "Any")
+	grm[name] = &ebnf.Production{
+		Name: &ebnf.Name{StringPos: refs[0].StringPos, String: name},
+		Expr: expr,
+	}
+	for _, ref := range refs {
+		ref.String = name
+	}
+	return nil
+}
+
+// reachable returns the set of productions of grm reachable from start.
+func reachable(grm ebnfutil.Grammar, start string) map[string]bool {
+	m := map[string]bool{}
+	var f func(string)
+	f = func(name string) {
+		if m[name] {
+			return
+		}
+
+		prod, ok := grm[name]
+		if !ok {
+			return
+		}
+
+		m[name] = true
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok {
+				f(x.String)
+			}
+		})
+	}
+	f(start)
+	return m
+}
+
+// origin is the EBNF construct a synthetic production was created for.
+type origin struct {
+	kind string // "group", "option", "repetition" or "alternative".
+	pos  scanner.Position
+	in   string // The production containing the construct.
+}
+
+// constructs appends to a the groups, options and repetitions of expr which
+// are not nested in other ones.
+func constructs(expr ebnf.Expression, a []ebnf.Expression) []ebnf.Expression {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			a = constructs(v, a)
+		}
+	case ebnf.Sequence:
+		for _, v := range x {
+			a = constructs(v, a)
+		}
+	case *ebnf.Group, *ebnf.Option, *ebnf.Repetition:
+		a = append(a, x)
+	}
+	return a
+}
+
+// bindOrigins records the origins of the synthetic productions derived from
+// the named one, which was lowered from expr, a part of production in. The
+// synthetic productions are matched to the constructs of expr in the order
+// they were created. If the numbers do not match, nothing is recorded.
+func (j *job) bindOrigins(name string, expr ebnf.Expression, in string) {
+	kids, cs := j.children[name], constructs(expr, nil)
+	if len(kids) != len(cs) {
+		return
+	}
+
+	for i, c := range cs {
+		kid := kids[i]
+		var body ebnf.Expression
+		o := origin{pos: c.Pos(), in: in}
+		switch x := c.(type) {
+		case *ebnf.Group:
+			o.kind, body = "group", x.Body
+		case *ebnf.Option:
+			o.kind, body = "option", x.Body
+		case *ebnf.Repetition:
+			o.kind, body = "repetition", x.Body
+		}
+		j.origins[kid] = o
+		if _, ok := body.(ebnf.Alternative); ok && o.kind != "group" {
+			// The alternatives of an option or repetition get a
+			// production of their own.
+			a := j.children[kid]
+			if len(a) != 1 {
+				continue
+			}
+
+			kid = a[0]
+			j.origins[kid] = o
+		}
+		j.bindOrigins(kid, body, in)
+	}
+}
+
+// splitAlternatives gives every alternative of the lowered non terminal
+// productions of j, other than the synthetic ones, a production of its own,
+// named like NameAlt1 for the first one. The production becomes a choice of
+// them.
+func (j *job) splitAlternatives() {
+	var names []string
+	for name := range j.grm {
+		if _, ok := j.synthetic[name]; !ok && ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod := j.grm[name]
+		a := alternatives(prod.Expr)
+		if len(a) < 2 {
+			continue
+		}
+
+		var b ebnf.Alternative
+		for i, v := range a {
+			s := j.synthName(j.inventName(fmt.Sprintf("%sAlt%d", j.synthKey(name), i+1), ""))
+			j.synthetic[s] = name
+			pos := prod.Pos()
+			if v != nil {
+				if p := v.Pos(); p.IsValid() {
+					pos = p
+				}
+			}
+			j.origins[s] = origin{kind: "alternative", pos: pos, in: name}
+			j.grm[s] = &ebnf.Production{Name: &ebnf.Name{StringPos: pos, String: s}, Expr: v}
+			b = append(b, &ebnf.Name{StringPos: pos, String: s})
+		}
+		prod.Expr = b
+	}
+}
+
+// altRule reports whether the named production is an alternative given a
+// production of its own by -alt-rules. Its value is a node of its type,
+// whatever the number of items.
+func (j *job) altRule(name string) bool {
+	return j.origins[name].kind == "alternative"
+}
+
+// removeName returns expr without the references to name, or nil if nothing
+// is left. Alternatives, groups, options and repetitions left empty are
+// removed as well.
+func removeName(expr ebnf.Expression, name string) ebnf.Expression {
+	switch x := expr.(type) {
+	case *ebnf.Name:
+		if x.String == name {
+			return nil
+		}
+	case ebnf.Alternative:
+		var a ebnf.Alternative
+		for _, v := range x {
+			if v = removeName(v, name); v != nil {
+				a = append(a, v)
+			}
+		}
+		switch len(a) {
+		case 0:
+			return nil
+		case 1:
+			return a[0]
+		}
+		return a
+	case ebnf.Sequence:
+		var a ebnf.Sequence
+		for _, v := range x {
+			if v = removeName(v, name); v != nil {
+				a = append(a, v)
+			}
+		}
+		switch len(a) {
+		case 0:
+			return nil
+		case 1:
+			return a[0]
+		}
+		return a
+	case *ebnf.Group:
+		if b := removeName(x.Body, name); b != nil {
+			return &ebnf.Group{Lparen: x.Lparen, Body: b}
+		}
+
+		return nil
+	case *ebnf.Option:
+		if b := removeName(x.Body, name); b != nil {
+			return &ebnf.Option{Lbrack: x.Lbrack, Body: b}
+		}
+
+		return nil
+	case *ebnf.Repetition:
+		if b := removeName(x.Body, name); b != nil {
+			return &ebnf.Repetition{Lbrace: x.Lbrace, Body: b}
+		}
+
+		return nil
+	}
+	return expr
+}
+
+// docComments returns the comments documenting the productions of grm, parsed
+// from src. Like in Go, a doc comment is the block of // comment lines
+// immediately preceding the line where the production starts.
+func docComments(src []byte, grm ebnfutil.Grammar) map[string][]string {
+	nl := []byte("\n")
+	m := map[string][]string{}
+	for name, prod := range grm {
+		off := prod.Pos().Offset
+		bol := bytes.LastIndex(src[:off], nl) + 1
+		if len(bytes.TrimSpace(src[bol:off])) != 0 {
+			continue // Not the first production of the line.
+		}
+
+		var a []string
+		for bol > 0 {
+			prev := bytes.LastIndex(src[:bol-1], nl) + 1
+			s := strings.TrimSpace(string(src[prev : bol-1]))
+			if !strings.HasPrefix(s, "//") {
+				break
+			}
+
+			a = append([]string{strings.TrimPrefix(s[2:], " ")}, a...)
+			bol = prev
+		}
+		if len(a) != 0 {
+			m[name] = a
+		}
+	}
+	return m
+}
+
+// singleLiterals returns the lexical productions of grm consisting of a single
+// literal, other than the %skip ones and those of start conditions, mapped to
+// the literal.
+func singleLiterals(grm ebnfutil.Grammar, skip map[string]bool, states map[string][]string) map[string]string {
+	m := map[string]string{}
+	for name, prod := range grm {
+		if x, ok := prod.Expr.(*ebnf.Token); ok && !ast.IsExported(name) && !skip[name] && states[name] == nil {
+			m[name] = x.String
+		}
+	}
+	return m
+}
+
+// inlineTerminals replaces the references to the lexical productions of grm
+// consisting of a single literal by the literal and removes the productions.
+// The %prec symbols in prec and the precedence levels are updated as well.
+// The %skip tokens and those having a %state are kept. The inlined
+// productions are reported to stderr.
+func inlineTerminals(grm ebnfutil.Grammar, prec map[int]string, levels []*level, skip map[string]bool, states map[string][]string) {
+	lits := singleLiterals(grm, skip, states)
+	if len(lits) == 0 {
+		return
+	}
+
+	var a byPos
+	for name := range lits {
+		a = append(a, grm[name])
+	}
+	sort.Sort(a)
+	for _, prod := range a {
+		name := prod.Name.String
+		wlog.Printf("%s: inlined terminal %s as %q", prod.Pos(), name, lits[name])
+		delete(grm, name)
+	}
+	for _, prod := range grm {
+		prod.Expr = replaceNames(prod.Expr, lits)
+	}
+	for off, s := range prec {
+		if lit, ok := lits[s]; ok {
+			prec[off] = strconv.Quote(lit)
+		}
+	}
+	for _, l := range levels {
+		for i, s := range l.syms {
+			if lit, ok := lits[s]; ok {
+				l.syms[i] = strconv.Quote(lit)
+			}
+		}
+	}
+}
+
+// collapseChains replaces the references to the non terminal productions of
+// grm consisting of a single name, eg. A = B . B = C . C = "x" ., by the
+// reference to the end of their chain, C for A and B, and removes them. The
+// pinned productions are kept, but their references are replaced as well.
+// Cycles of such productions are left alone. The collapsed chains are
+// reported.
+func collapseChains(grm ebnfutil.Grammar, pinned map[string]bool) {
+	next := func(name string) string {
+		if !ast.IsExported(name) {
+			return ""
+		}
+
+		if x, ok := grm[name].Expr.(*ebnf.Name); ok && has(grm, x.String) {
+			return x.String
+		}
+
+		return ""
+	}
+
+	// The chains of the productions to remove.
+	chains := map[string][]string{}
+	for name := range grm {
+		if pinned[name] || next(name) == "" {
+			continue
+		}
+
+		a := []string{name}
+		seen := map[string]bool{name: true}
+		for n := next(name); ; n = next(n) {
+			if seen[n] {
+				a = nil
+				break
+			}
+
+			seen[n] = true
+			a = append(a, n)
+			if pinned[n] || next(n) == "" {
+				break
+			}
+		}
+		if a != nil {
+			chains[name] = a
+		}
+	}
+	if len(chains) == 0 {
+		return
+	}
+
+	// The heads of the chains are not part of longer ones.
+	var heads byPos
+	inner := map[string]bool{}
+	for name, prod := range grm {
+		x, ok := prod.Expr.(*ebnf.Name)
+		if !ok || chains[name] == nil && !(pinned[name] && chains[x.String] != nil) {
+			continue
+		}
+
+		heads = append(heads, prod)
+		inner[x.String] = true
+	}
+	for i := 0; i < len(heads); i++ {
+		if inner[heads[i].Name.String] {
+			heads = append(heads[:i], heads[i+1:]...)
+			i--
+		}
+	}
+	sort.Sort(heads)
+	for _, prod := range heads {
+		name := prod.Name.String
+		a := chains[name]
+		note := ""
+		if a == nil {
+			a = append([]string{name}, chains[prod.Expr.(*ebnf.Name).String]...)
+			note = ", " + name + " kept"
+		}
+		wlog.Printf("%s: collapsed chain %s%s", prod.Pos(), strings.Join(a, " -> "), note)
+	}
+
+	for name := range chains {
+		delete(grm, name)
+	}
+	for _, prod := range grm {
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok {
+				if a := chains[x.String]; a != nil {
+					x.String = a[len(a)-1]
+				}
+			}
+		})
+	}
+}
+
+// replaceNames returns expr with the references to the names in lits
+// replaced by the literals.
+func replaceNames(expr ebnf.Expression, lits map[string]string) ebnf.Expression {
+	switch x := expr.(type) {
+	case *ebnf.Name:
+		if lit, ok := lits[x.String]; ok {
+			return &ebnf.Token{StringPos: x.StringPos, String: lit}
+		}
+	case ebnf.Alternative:
+		for i, v := range x {
+			x[i] = replaceNames(v, lits)
+		}
+	case ebnf.Sequence:
+		for i, v := range x {
+			x[i] = replaceNames(v, lits)
+		}
+	case *ebnf.Group:
+		x.Body = replaceNames(x.Body, lits)
+	case *ebnf.Option:
+		x.Body = replaceNames(x.Body, lits)
+	case *ebnf.Repetition:
+		x.Body = replaceNames(x.Body, lits)
+	}
+	return expr
+}
+
+// rightFactor rewrites the alternatives of the non terminal productions of
+// grm sharing a common suffix, eg. A = B "x" | C "x" . becomes
+// A = ( B | C ) "x" . and A = B "x" | "x" . becomes A = [ B ] "x" . The
+// alternatives with a %prec clause are left alone. The rewritten productions
+// are reported.
+func rightFactor(grm ebnfutil.Grammar, prec map[int]string) {
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod := grm[name]
+		var changed bool
+		if prod.Expr, changed = factorSuffixes(prod.Expr, prec); changed {
+			wlog.Printf("%s: right factored production %s", prod.Pos(), name)
+		}
+	}
+}
+
+// terms returns the terms of the sequence expr.
+func terms(expr ebnf.Expression) []ebnf.Expression {
+	switch x := expr.(type) {
+	case nil:
+		return nil
+	case ebnf.Sequence:
+		return x
+	default:
+		return []ebnf.Expression{x}
+	}
+}
+
+// sequence returns the expression of the sequence of terms a.
+func sequence(a []ebnf.Expression) ebnf.Expression {
+	switch len(a) {
+	case 0:
+		return nil
+	case 1:
+		return a[0]
+	default:
+		return ebnf.Sequence(append([]ebnf.Expression(nil), a...))
+	}
+}
+
+// factorSuffixes returns expr with the common suffixes of its alternatives,
+// also the nested ones, factored out and whether anything changed.
+func factorSuffixes(expr ebnf.Expression, prec map[int]string) (_ ebnf.Expression, changed bool) {
+	var c bool
+	switch x := expr.(type) {
+	case ebnf.Sequence:
+		for i, v := range x {
+			x[i], c = factorSuffixes(v, prec)
+			changed = changed || c
+		}
+		return x, changed
+	case *ebnf.Group:
+		x.Body, changed = factorSuffixes(x.Body, prec)
+		return x, changed
+	case *ebnf.Option:
+		x.Body, changed = factorSuffixes(x.Body, prec)
+		return x, changed
+	case *ebnf.Repetition:
+		x.Body, changed = factorSuffixes(x.Body, prec)
+		return x, changed
+	case ebnf.Alternative:
+		// handled below
+	default:
+		return expr, false
+	}
+
+	alts := expr.(ebnf.Alternative)
+	for i, v := range alts {
+		alts[i], c = factorSuffixes(v, prec)
+		changed = changed || c
+	}
+	bound := func(v ebnf.Expression) bool {
+		_, ok := prec[exprOffset(v)]
+		return ok
+	}
+	used := make([]bool, len(alts))
+	var a ebnf.Alternative
+	for i, v := range alts {
+		if used[i] {
+			continue
+		}
+
+		t := terms(v)
+		group := []int{i}
+		n := len(t) // Length of the common suffix.
+		if n != 0 && !bound(v) {
+			for k := i + 1; k < len(alts); k++ {
+				u := terms(alts[k])
+				if used[k] || len(u) == 0 || bound(alts[k]) || !sameExpr(t[len(t)-1], u[len(u)-1]) {
+					continue
+				}
+
+				m := 1
+				for m < len(t) && m < len(u) && sameExpr(t[len(t)-1-m], u[len(u)-1-m]) {
+					m++
+				}
+				if m < n {
+					n = m
+				}
+				group = append(group, k)
+			}
+		}
+		var prefixes ebnf.Alternative
+		empty := false
+		for _, k := range group {
+			u := terms(alts[k])
+			switch p := sequence(u[:len(u)-n]); {
+			case p == nil:
+				empty = true
+			default:
+				prefixes = append(prefixes, p)
+			}
+		}
+		if len(group) < 2 || len(prefixes) == 0 {
+			a = append(a, v)
+			continue
+		}
+
+		for _, k := range group {
+			used[k] = true
+		}
+		var body ebnf.Expression = prefixes
+		if len(prefixes) == 1 {
+			body = prefixes[0]
+		}
+		var factor ebnf.Expression = &ebnf.Group{Lparen: exprPos(v), Body: body}
+		if empty {
+			factor = &ebnf.Option{Lbrack: exprPos(v), Body: body}
+		}
+		a = append(a, sequence(append([]ebnf.Expression{factor}, t[len(t)-n:]...)))
+		changed = true
+	}
+	if len(a) == 1 {
+		return a[0], changed
+	}
+
+	return a, changed
+}
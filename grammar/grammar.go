@@ -0,0 +1,634 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package grammar computes the nullable, FIRST and FOLLOW properties of the
+// non terminal productions of an EBNF grammar, the front end analysis of
+// ebnf2y.
+//
+// Non terminals are the productions with an exported name, the lexical
+// productions are terminals. A terminal is represented by its name, a
+// literal by its quoted string.
+//
+//	g, err := grammar.Parse(fn, src, "SourceFile")
+//	...
+//	for _, t := range g.First("Expression").Sorted() {
+//		...
+//	}
+package grammar
+
+import (
+	"go/ast"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// EndOfInput is the member of the FOLLOW set of the start production
+// representing the end of input.
+const EndOfInput = "$end"
+
+// TokenSet is a set of terminals.
+type TokenSet map[string]bool
+
+// Sorted returns the members of s in sorted order.
+func (s TokenSet) Sorted() (a []string) {
+	for k := range s {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return
+}
+
+// Bits is a set of terminals represented by their indices, see Grammar.Set.
+type Bits []uint64
+
+// Or adds the members of c to b and reports whether b changed.
+func (b Bits) Or(c Bits) (changed bool) {
+	for i, v := range c {
+		if w := b[i] | v; w != b[i] {
+			b[i] = w
+			changed = true
+		}
+	}
+	return
+}
+
+// And returns the intersection of b and c.
+func (b Bits) And(c Bits) Bits {
+	d := make(Bits, len(b))
+	for i, v := range b {
+		d[i] = v & c[i]
+	}
+	return d
+}
+
+// Empty reports whether b has no members.
+func (b Bits) Empty() bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Grammar is an EBNF grammar and the nullable, FIRST and FOLLOW properties of
+// its non terminal productions. The properties are computed on first use and
+// cached. The grammar must not be modified while its properties are in use.
+//
+// The fixpoints are computed using a work list. After the production of a
+// non terminal is evaluated, only the productions affected by a change of
+// its properties are evaluated again. Invalidate updates the properties after
+// a modification of some productions.
+type Grammar struct {
+	ebnfutil.Grammar
+	first    map[string]Bits
+	follow   map[string]Bits
+	names    []string // Terminal index: terminal.
+	nullable map[string]bool
+	refs     map[string][]string // Non terminal: names it refers to.
+	start    string
+	terms    map[string]Bits     // Terminal: set of the terminal.
+	users    map[string][]string // Non terminal: non terminals referring to it.
+}
+
+// New returns the Grammar of grm, start being the name of its start
+// production.
+func New(grm ebnfutil.Grammar, start string) *Grammar {
+	return &Grammar{Grammar: grm, start: start}
+}
+
+// Parse returns the verified Grammar of the EBNF source src, fname naming it
+// in the errors.
+func Parse(fname string, src io.Reader, start string) (*Grammar, error) {
+	grm, err := ebnfutil.Parse(fname, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := grm.Verify(start); err != nil {
+		return nil, err
+	}
+
+	return New(grm, start), nil
+}
+
+// Invalidate updates the cached properties of g after the named productions
+// were modified, added or removed. Only the properties which can depend on
+// them are computed again, unless that is most of them or a production
+// introduces a new terminal, then the cache is discarded.
+func (g *Grammar) Invalidate(names ...string) {
+	if g.nullable == nil {
+		return
+	}
+
+	for _, name := range names {
+		if prod, ok := g.Grammar[name]; ok && ast.IsExported(name) {
+			found := true
+			walk(prod.Expr, func(expr ebnf.Expression) {
+				if t, ok := Terminal(expr); ok && g.terms[t] == nil {
+					found = false
+				}
+			})
+			if !found {
+				g.discard()
+				return
+			}
+		}
+	}
+
+	// The references of the productions, before and after.
+	touched := map[string]bool{}
+	for _, name := range names {
+		for _, v := range g.refs[name] {
+			a := g.users[v][:0]
+			for _, u := range g.users[v] {
+				if u != name {
+					a = append(a, u)
+				}
+			}
+			g.users[v] = a
+			touched[v] = true
+		}
+		delete(g.refs, name)
+		if prod, ok := g.Grammar[name]; ok && ast.IsExported(name) {
+			g.refs[name] = refs(prod.Expr)
+			for _, v := range g.refs[name] {
+				g.users[v] = append(g.users[v], name)
+				touched[v] = true
+			}
+		}
+	}
+
+	// The nullable and FIRST properties depend on the productions referred
+	// to only, affected are the changed productions and their users.
+	var affected []string
+	seen := map[string]bool{}
+	var visit func(string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+
+		seen[name] = true
+		affected = append(affected, name)
+		for _, v := range g.users[name] {
+			visit(v)
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	if 2*len(affected) > len(g.refs) {
+		g.discard()
+		return
+	}
+
+	var live []string
+	for _, name := range affected {
+		delete(g.nullable, name)
+		if g.first != nil {
+			delete(g.first, name)
+		}
+		switch _, ok := g.Grammar[name]; {
+		case ok && ast.IsExported(name):
+			live = append(live, name)
+		case g.follow != nil:
+			delete(g.follow, name)
+		}
+	}
+	g.fixpoint(live, g.evalNullable)
+	if g.first == nil {
+		return
+	}
+
+	for _, name := range live {
+		g.first[name] = g.NewBits()
+	}
+	g.fixpoint(live, g.evalFirst)
+	if g.follow == nil {
+		return
+	}
+
+	// The FOLLOW set of a production depends on the productions referring
+	// to it, the FIRST sets of the terms following it there and the FOLLOW
+	// sets of their left hand sides.
+	reset := map[string]bool{}
+	var q []string
+	var add func(string)
+	add = func(name string) {
+		if reset[name] || !ast.IsExported(name) {
+			return
+		}
+
+		reset[name] = true
+		q = append(q, name)
+		for _, v := range g.refs[name] {
+			add(v)
+		}
+	}
+	for v := range touched {
+		add(v)
+	}
+	for _, name := range affected {
+		for _, u := range g.users[name] {
+			for _, v := range g.refs[u] {
+				add(v)
+			}
+		}
+	}
+	if 2*len(q) > len(g.refs) {
+		g.follow = nil
+		return
+	}
+
+	for _, name := range q {
+		if _, ok := g.Grammar[name]; !ok {
+			delete(g.follow, name)
+			continue
+		}
+
+		g.follow[name] = g.NewBits()
+		if name == g.start {
+			g.follow[name].Or(g.terms[EndOfInput])
+		}
+	}
+	var eval []string
+	queued := map[string]bool{}
+	for _, name := range q {
+		for _, v := range append([]string{name}, g.users[name]...) {
+			if _, ok := g.Grammar[v]; ok && !queued[v] {
+				queued[v] = true
+				eval = append(eval, v)
+			}
+		}
+	}
+	g.fixpoint(eval, g.evalFollow)
+}
+
+// discard discards the cached properties.
+func (g *Grammar) discard() {
+	g.first, g.follow, g.nullable = nil, nil, nil
+}
+
+// Nullable reports whether the named production derives the empty string.
+func (g *Grammar) Nullable(name string) bool {
+	g.nullables()
+	return g.nullable[name]
+}
+
+// First returns the set of terminals starting the strings derived from the
+// named production.
+func (g *Grammar) First(name string) TokenSet {
+	g.firsts()
+	return g.Set(g.first[name])
+}
+
+// Follow returns the set of terminals which can follow the named production
+// in the strings derived from the start production.
+func (g *Grammar) Follow(name string) TokenSet {
+	return g.Set(g.FollowBits(name))
+}
+
+// FollowBits returns the FOLLOW set of the named production. It must not be
+// modified.
+func (g *Grammar) FollowBits(name string) Bits {
+	g.follows()
+	return g.follow[name]
+}
+
+// Set returns the terminals of b.
+func (g *Grammar) Set(b Bits) TokenSet {
+	g.nullables()
+	s := TokenSet{}
+	for i, v := range b {
+		for j := 0; v != 0; j, v = j+1, v>>1 {
+			if v&1 != 0 {
+				s[g.names[64*i+j]] = true
+			}
+		}
+	}
+	return s
+}
+
+// NewBits returns an empty set of the terminals of g.
+func (g *Grammar) NewBits() Bits {
+	g.nullables()
+	return make(Bits, (len(g.names)+63)/64)
+}
+
+// Union returns the union of sets.
+func (g *Grammar) Union(sets []Bits) Bits {
+	b := g.NewBits()
+	for _, s := range sets {
+		b.Or(s)
+	}
+	return b
+}
+
+// Terminal returns the terminal expr, a lexical production name, a literal or
+// a range, and whether it is one.
+func Terminal(expr ebnf.Expression) (string, bool) {
+	switch x := expr.(type) {
+	case *ebnf.Name:
+		return x.String, !ast.IsExported(x.String)
+	case *ebnf.Token:
+		return strconv.Quote(x.String), true
+	case *ebnf.Range:
+		return strconv.Quote(x.Begin.String) + "…" + strconv.Quote(x.End.String), true
+	}
+	return "", false
+}
+
+func walk(expr ebnf.Expression, f func(ebnf.Expression)) {
+	f(expr)
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			walk(v, f)
+		}
+	case ebnf.Sequence:
+		for _, v := range x {
+			walk(v, f)
+		}
+	case *ebnf.Group:
+		walk(x.Body, f)
+	case *ebnf.Option:
+		walk(x.Body, f)
+	case *ebnf.Repetition:
+		walk(x.Body, f)
+	}
+}
+
+// refs returns the names referred to by expr, without duplicates.
+func refs(expr ebnf.Expression) (a []string) {
+	seen := map[string]bool{}
+	walk(expr, func(expr ebnf.Expression) {
+		if x, ok := expr.(*ebnf.Name); ok && !seen[x.String] {
+			seen[x.String] = true
+			a = append(a, x.String)
+		}
+	})
+	return
+}
+
+// term returns the set of the terminal expr.
+func (g *Grammar) term(expr ebnf.Expression) Bits {
+	t, _ := Terminal(expr)
+	return g.terms[t]
+}
+
+// nonTerminals returns the names of the non terminal productions.
+func (g *Grammar) nonTerminals() (a []string) {
+	for name := range g.Grammar {
+		if ast.IsExported(name) {
+			a = append(a, name)
+		}
+	}
+	return
+}
+
+// fixpoint evaluates f for the names of q and then again for the names
+// returned by f until it returns none.
+func (g *Grammar) fixpoint(q []string, f func(name string) []string) {
+	queued := map[string]bool{}
+	for _, name := range q {
+		queued[name] = true
+	}
+	for len(q) != 0 {
+		name := q[0]
+		q = q[1:]
+		queued[name] = false
+		for _, v := range f(name) {
+			if !queued[v] {
+				q = append(q, v)
+				queued[v] = true
+			}
+		}
+	}
+}
+
+func (g *Grammar) nullables() {
+	if g.nullable != nil {
+		return
+	}
+
+	g.names = []string{EndOfInput}
+	index := map[string]int{EndOfInput: 0}
+	g.refs = map[string][]string{}
+	g.users = map[string][]string{}
+	for name, prod := range g.Grammar {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if t, ok := Terminal(expr); ok {
+				if _, ok := index[t]; !ok {
+					index[t] = len(g.names)
+					g.names = append(g.names, t)
+				}
+			}
+		})
+		g.refs[name] = refs(prod.Expr)
+		for _, v := range g.refs[name] {
+			g.users[v] = append(g.users[v], name)
+		}
+	}
+	g.terms = map[string]Bits{}
+	for t, i := range index {
+		b := make(Bits, (len(g.names)+63)/64)
+		b[i/64] |= 1 << uint(i%64)
+		g.terms[t] = b
+	}
+
+	g.nullable = map[string]bool{}
+	g.fixpoint(g.nonTerminals(), g.evalNullable)
+}
+
+func (g *Grammar) evalNullable(name string) []string {
+	if g.nullable[name] || !g.exprNullable(g.Grammar[name].Expr) {
+		return nil
+	}
+
+	g.nullable[name] = true
+	return g.users[name]
+}
+
+// NullableExpr reports whether expr, an expression of a non terminal
+// production, derives the empty string.
+func (g *Grammar) NullableExpr(expr ebnf.Expression) bool {
+	g.nullables()
+	return g.exprNullable(expr)
+}
+
+func (g *Grammar) exprNullable(expr ebnf.Expression) bool {
+	switch x := expr.(type) {
+	case nil, *ebnf.Option, *ebnf.Repetition:
+		return true
+	case ebnf.Alternative:
+		for _, v := range x {
+			if g.exprNullable(v) {
+				return true
+			}
+		}
+		return false
+	case ebnf.Sequence:
+		for _, v := range x {
+			if !g.exprNullable(v) {
+				return false
+			}
+		}
+		return true
+	case *ebnf.Group:
+		return g.exprNullable(x.Body)
+	case *ebnf.Name:
+		return g.nullable[x.String]
+	default:
+		return false
+	}
+}
+
+func (g *Grammar) firsts() {
+	if g.first != nil {
+		return
+	}
+
+	g.nullables()
+	g.first = map[string]Bits{}
+	for name := range g.Grammar {
+		if ast.IsExported(name) {
+			g.first[name] = g.NewBits()
+		}
+	}
+	g.fixpoint(g.nonTerminals(), g.evalFirst)
+}
+
+func (g *Grammar) evalFirst(name string) []string {
+	sets, _ := g.firstSets(g.Grammar[name].Expr, nil)
+	changed := false
+	for _, s := range sets {
+		if g.first[name].Or(s) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return g.users[name]
+}
+
+// FirstSets appends to sets the sets whose union is the FIRST set of expr, an
+// expression of a non terminal production, and reports whether expr is
+// nullable. The sets appended must not be modified.
+func (g *Grammar) FirstSets(expr ebnf.Expression, sets []Bits) (_ []Bits, nullable bool) {
+	g.firsts()
+	return g.firstSets(expr, sets)
+}
+
+func (g *Grammar) firstSets(expr ebnf.Expression, sets []Bits) (_ []Bits, nullable bool) {
+	switch x := expr.(type) {
+	case nil:
+		return sets, true
+	case ebnf.Alternative:
+		for _, v := range x {
+			var ok bool
+			if sets, ok = g.firstSets(v, sets); ok {
+				nullable = true
+			}
+		}
+		return sets, nullable
+	case ebnf.Sequence:
+		for _, v := range x {
+			var ok bool
+			if sets, ok = g.firstSets(v, sets); !ok {
+				return sets, false
+			}
+		}
+		return sets, true
+	case *ebnf.Group:
+		return g.firstSets(x.Body, sets)
+	case *ebnf.Option:
+		sets, _ = g.firstSets(x.Body, sets)
+		return sets, true
+	case *ebnf.Repetition:
+		sets, _ = g.firstSets(x.Body, sets)
+		return sets, true
+	case *ebnf.Name:
+		if ast.IsExported(x.String) {
+			return append(sets, g.first[x.String]), g.nullable[x.String]
+		}
+	}
+	return append(sets, g.term(expr)), false
+}
+
+func (g *Grammar) follows() {
+	if g.follow != nil {
+		return
+	}
+
+	g.firsts()
+	g.follow = map[string]Bits{}
+	for name := range g.Grammar {
+		if ast.IsExported(name) {
+			g.follow[name] = g.NewBits()
+		}
+	}
+	if b, ok := g.follow[g.start]; ok {
+		b.Or(g.terms[EndOfInput])
+	}
+	g.fixpoint(g.nonTerminals(), g.evalFollow)
+}
+
+func (g *Grammar) evalFollow(name string) (changed []string) {
+	g.exprFollow(g.Grammar[name].Expr, []Bits{g.follow[name]}, &changed)
+	return
+}
+
+// exprFollow adds the union of sets, the terminals following expr, to the
+// FOLLOW sets of the non terminals referred to by expr and appends the names
+// of those which changed to changed.
+func (g *Grammar) exprFollow(expr ebnf.Expression, sets []Bits, changed *[]string) {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			g.exprFollow(v, sets, changed)
+		}
+	case ebnf.Sequence:
+		for i := len(x) - 1; i >= 0; i-- {
+			g.exprFollow(x[i], sets, changed)
+			t, nullable := g.firstSets(x[i], nil)
+			if nullable {
+				t = append(t, sets...)
+			}
+			sets = t
+		}
+	case *ebnf.Group:
+		g.exprFollow(x.Body, sets, changed)
+	case *ebnf.Option:
+		g.exprFollow(x.Body, sets, changed)
+	case *ebnf.Repetition:
+		t, _ := g.firstSets(x.Body, nil)
+		g.exprFollow(x.Body, append(t, sets...), changed)
+	case *ebnf.Name:
+		f, ok := g.follow[x.String]
+		if !ok {
+			break
+		}
+
+		c := false
+		for _, s := range sets {
+			if f.Or(s) {
+				c = true
+			}
+		}
+		if c {
+			*changed = append(*changed, x.String)
+		}
+	}
+}
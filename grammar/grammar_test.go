@@ -0,0 +1,399 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grammar
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// naive computes the properties of grm by the textbook fixpoints, every
+// production evaluated again until nothing changes.
+type naive struct {
+	grm      ebnfutil.Grammar
+	nullable map[string]bool
+	first    map[string]TokenSet
+	follow   map[string]TokenSet
+}
+
+func newNaive(grm ebnfutil.Grammar, start string) *naive {
+	n := &naive{grm, map[string]bool{}, map[string]TokenSet{}, map[string]TokenSet{}}
+	for name := range grm {
+		if ast.IsExported(name) {
+			n.first[name] = TokenSet{}
+			n.follow[name] = TokenSet{}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, prod := range grm {
+			if ast.IsExported(name) && !n.nullable[name] && n.nullableExpr(prod.Expr) {
+				n.nullable[name] = true
+				changed = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, prod := range grm {
+			if ast.IsExported(name) && n.add(n.first[name], n.firstOf(prod.Expr)) {
+				changed = true
+			}
+		}
+	}
+	if s, ok := n.follow[start]; ok {
+		s[EndOfInput] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, prod := range grm {
+			if ast.IsExported(name) && n.followOf(prod.Expr, n.follow[name]) {
+				changed = true
+			}
+		}
+	}
+	return n
+}
+
+func (n *naive) add(s, t TokenSet) (changed bool) {
+	for k := range t {
+		if !s[k] {
+			s[k] = true
+			changed = true
+		}
+	}
+	return
+}
+
+func (n *naive) nullableExpr(expr ebnf.Expression) bool {
+	switch x := expr.(type) {
+	case nil, *ebnf.Option, *ebnf.Repetition:
+		return true
+	case ebnf.Alternative:
+		for _, v := range x {
+			if n.nullableExpr(v) {
+				return true
+			}
+		}
+		return false
+	case ebnf.Sequence:
+		for _, v := range x {
+			if !n.nullableExpr(v) {
+				return false
+			}
+		}
+		return true
+	case *ebnf.Group:
+		return n.nullableExpr(x.Body)
+	case *ebnf.Name:
+		return n.nullable[x.String]
+	}
+	return false
+}
+
+func (n *naive) firstOf(expr ebnf.Expression) TokenSet {
+	s := TokenSet{}
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			n.add(s, n.firstOf(v))
+		}
+	case ebnf.Sequence:
+		for _, v := range x {
+			n.add(s, n.firstOf(v))
+			if !n.nullableExpr(v) {
+				break
+			}
+		}
+	case *ebnf.Group:
+		return n.firstOf(x.Body)
+	case *ebnf.Option:
+		return n.firstOf(x.Body)
+	case *ebnf.Repetition:
+		return n.firstOf(x.Body)
+	case *ebnf.Name:
+		if ast.IsExported(x.String) {
+			n.add(s, n.first[x.String])
+			break
+		}
+
+		s[x.String] = true
+	case *ebnf.Token, *ebnf.Range:
+		t, _ := Terminal(x)
+		s[t] = true
+	}
+	return s
+}
+
+// followOf adds follow, the terminals following expr, to the FOLLOW sets of
+// the non terminals of expr.
+func (n *naive) followOf(expr ebnf.Expression, follow TokenSet) (changed bool) {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		for _, v := range x {
+			if n.followOf(v, follow) {
+				changed = true
+			}
+		}
+	case ebnf.Sequence:
+		for i, v := range x {
+			t := n.firstOf(ebnf.Sequence(x[i+1:]))
+			if n.nullableExpr(ebnf.Sequence(x[i+1:])) {
+				n.add(t, follow)
+			}
+			if n.followOf(v, t) {
+				changed = true
+			}
+		}
+	case *ebnf.Group:
+		return n.followOf(x.Body, follow)
+	case *ebnf.Option:
+		return n.followOf(x.Body, follow)
+	case *ebnf.Repetition:
+		t := n.firstOf(x.Body)
+		n.add(t, follow)
+		return n.followOf(x.Body, t)
+	case *ebnf.Name:
+		if s, ok := n.follow[x.String]; ok {
+			return n.add(s, follow)
+		}
+	}
+	return
+}
+
+func str(s TokenSet) string { return strings.Join(s.Sorted(), " ") }
+
+// check compares the properties of g to those of the naive algorithm.
+func check(t *testing.T, tag string, g *Grammar) {
+	n := newNaive(g.Grammar, g.start)
+	var names []string
+	for name := range g.Grammar {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if g, e := g.Nullable(name), n.nullable[name]; g != e {
+			t.Errorf("%s: %s: nullable %v, expected %v", tag, name, g, e)
+		}
+		if g, e := str(g.First(name)), str(n.first[name]); g != e {
+			t.Errorf("%s: %s: FIRST\ngot %s\nexp %s", tag, name, g, e)
+		}
+		if g, e := str(g.Follow(name)), str(n.follow[name]); g != e {
+			t.Errorf("%s: %s: FOLLOW\ngot %s\nexp %s", tag, name, g, e)
+		}
+	}
+}
+
+func parse(t testing.TB, src string) ebnfutil.Grammar {
+	grm, err := ebnfutil.Parse("test.ebnf", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return grm
+}
+
+var grammars = []string{
+	`Start = Expr .
+	Expr = Term { ( "+" | "-" ) Term } .
+	Term = Factor { ( "*" | "/" ) Factor } .
+	Factor = num | "(" Expr ")" | "-" Factor .
+	num = "0" … "9" .`,
+
+	`Start = A B C "x" .
+	A = [ "a" ] .
+	B = A | { "b" } .
+	C = B A | "c" .`,
+
+	`Start = List .
+	List = List "," Item | Item .
+	Item = Start "!" | [ id ] .
+	id = "i" .`,
+
+	`Start = IfStmt .
+	IfStmt = "if" Expr Stmt [ "else" Stmt ] .
+	Stmt = IfStmt | id "=" Expr ";" | "{" { Stmt } "}" .
+	Expr = id | num .
+	id = "i" .
+	num = "n" .`,
+}
+
+func TestNaive(t *testing.T) {
+	for i, src := range grammars {
+		check(t, fmt.Sprint(i), New(parse(t, src), "Start"))
+	}
+
+	src, err := ioutil.ReadFile("../demo/demo.ebnf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(t, "demo.ebnf", New(parse(t, string(src)), "Expression"))
+	for i := 0; i < 50; i++ {
+		check(t, fmt.Sprintf("random %d", i), New(parse(t, random(rand.New(rand.NewSource(int64(i))), 20)), "P0"))
+	}
+}
+
+// random returns the source of a random grammar of n non terminal
+// productions, P0 to Pn-1, and the lexical productions a to e. Pi refers
+// mostly to the next few productions, like the levels of an expression.
+func random(r *rand.Rand, n int) string {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "P%d = %s .\n", i, randomExpr(r, n, i, 2))
+	}
+	for _, v := range "abcde" {
+		fmt.Fprintf(&b, "%c = %q .\n", v, string(v-'a'+'A'))
+	}
+	return b.String()
+}
+
+// randomExpr returns a random expression of the production Pi.
+func randomExpr(r *rand.Rand, n, i, depth int) string {
+	var alts []string
+	for i := r.Intn(3) + 1; i > 0; i-- {
+		var seq []string
+		for j := r.Intn(3) + 1; j > 0; j-- {
+			seq = append(seq, randomTerm(r, n, i, depth))
+		}
+		alts = append(alts, strings.Join(seq, " "))
+	}
+	return strings.Join(alts, " | ")
+}
+
+func randomTerm(r *rand.Rand, n, i, depth int) string {
+	ref := i + 1 + r.Intn(4)
+	if ref >= n || r.Intn(10) == 0 {
+		ref = r.Intn(n)
+	}
+	switch k := r.Intn(10); {
+	case k < 4:
+		return fmt.Sprintf("P%d", ref)
+	case k < 6:
+		return fmt.Sprintf("%q", string(rune('a'+r.Intn(5))))
+	case k < 7:
+		return string(rune('a' + r.Intn(5)))
+	case depth == 0:
+		return fmt.Sprintf("P%d", ref)
+	case k < 8:
+		return "[ " + randomExpr(r, n, i, depth-1) + " ]"
+	case k < 9:
+		return "{ " + randomExpr(r, n, i, depth-1) + " }"
+	default:
+		return "( " + randomExpr(r, n, i, depth-1) + " )"
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	updated, total := 0, 0
+	for i := 0; i < 100; i++ {
+		r := rand.New(rand.NewSource(int64(i)))
+		const n = 40
+		grm := parse(t, random(r, n))
+		g := New(grm, "P0")
+		check(t, fmt.Sprintf("%d", i), g)
+		for k := 0; k < 5; k++ {
+			// Modify some productions, using the terminals of
+			// grm, and remove one, like inlining does, or add one.
+			var names []string
+			for j := r.Intn(2) + 1; j > 0; j-- {
+				x := r.Intn(n)
+				name := fmt.Sprintf("P%d", x)
+				for name, prod := range parse(t, fmt.Sprintf("%s = %s .", name, randomExpr(r, n, x, 2))) {
+					grm[name] = prod
+				}
+				names = append(names, name)
+			}
+			switch name := fmt.Sprintf("P%d", n+k); r.Intn(3) {
+			case 0:
+				delete(grm, fmt.Sprintf("P%d", n+k-1))
+				names = append(names, fmt.Sprintf("P%d", n+k-1))
+			case 1:
+				for name, prod := range parse(t, fmt.Sprintf("%s = %s .", name, randomExpr(r, n, n, 2))) {
+					grm[name] = prod
+				}
+				names = append(names, name)
+			}
+			g.Invalidate(names...)
+			if g.follow != nil {
+				updated++
+			}
+			total++
+			check(t, fmt.Sprintf("%d.%d", i, k), g)
+		}
+	}
+	if updated < total/4 {
+		t.Errorf("only %d of %d invalidations updated the properties", updated, total)
+	}
+}
+
+// The FIRST set of Y changes, changing the FOLLOW set of X in the unchanged
+// production Start.
+func TestInvalidateFollow(t *testing.T) {
+	grm := parse(t, `Start = X Y "w" . X = "x" . Y = Z . Z = "z" .`)
+	g := New(grm, "Start")
+	check(t, "before", g)
+	for name, prod := range parse(t, `Z = [ "z" ] .`) {
+		grm[name] = prod
+	}
+	g.Invalidate("Z")
+	check(t, "after", g)
+}
+
+func TestInvalidateNewTerminal(t *testing.T) {
+	grm := parse(t, grammars[0])
+	g := New(grm, "Start")
+	check(t, "before", g)
+	for name, prod := range parse(t, `Factor = num | "(" Expr ")" | "-" Factor | "!" Factor .`) {
+		grm[name] = prod
+	}
+	g.Invalidate("Factor")
+	check(t, "after", g)
+}
+
+func benchmarkGrammar(b *testing.B) ebnfutil.Grammar {
+	return parse(b, random(rand.New(rand.NewSource(42)), 400))
+}
+
+func BenchmarkNaive(b *testing.B) {
+	grm := benchmarkGrammar(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newNaive(grm, "P0")
+	}
+}
+
+func BenchmarkFollow(b *testing.B) {
+	grm := benchmarkGrammar(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(grm, "P0").Follow("P0")
+	}
+}
+
+// BenchmarkInvalidate measures updating the properties after a change of a
+// production, every production in turn, compared to computing them again by
+// BenchmarkFollow.
+func BenchmarkInvalidate(b *testing.B) {
+	grm := benchmarkGrammar(b)
+	g := New(grm, "P0")
+	g.Follow("P0")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Invalidate(fmt.Sprintf("P%d", i%400))
+		g.Follow("P0")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"sort"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// inlineTrace writes the -explain-inline log of the inlining decisions to
+// stderr. The nil *inlineTrace writes nothing.
+type inlineTrace struct {
+	skip map[string]bool // The start productions.
+}
+
+func newInlineTrace(start string) *inlineTrace {
+	return &inlineTrace{skip: map[string]bool{start: true}}
+}
+
+// termCount returns the number of names and literals of expr.
+func termCount(expr ebnf.Expression) (n int) {
+	walk(expr, func(expr ebnf.Expression) {
+		switch expr.(type) {
+		case *ebnf.Name, *ebnf.Token:
+			n++
+		}
+	})
+	return
+}
+
+// run calls f inlining productions of grm and logs, for each of names, or
+// every non terminal production but the start one if names is nil, its use
+// count and size before and whether f inlined it, by rule, or kept it. Phase
+// names the step, eg. "-ie 1".
+func (t *inlineTrace) run(grm ebnfutil.Grammar, phase, rule string, names []string, f func() error) error {
+	if t == nil {
+		return f()
+	}
+
+	if names == nil {
+		for name := range grm {
+			if ast.IsExported(name) && !t.skip[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	refs := map[string]int{}
+	for _, v := range countRefs(grm) {
+		refs[v.Name] = v.Refs
+	}
+	cyclic := map[string]bool{}
+	for _, c := range toposort(grm) {
+		for _, name := range c.Productions {
+			cyclic[name] = c.Cycle
+		}
+	}
+	size := map[string]int{}
+	for _, name := range names {
+		if prod, ok := grm[name]; ok {
+			size[name] = termCount(prod.Expr)
+		}
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		var why string
+		switch {
+		case !has(grm, name):
+			why = "inlined (" + rule + ")"
+		case cyclic[name]:
+			why = "kept (recursive)"
+		case refs[name] == 0:
+			why = "kept (unused)"
+		case rule == "used-once" && refs[name] > 1:
+			why = "kept (used more than once)"
+		default:
+			// The rule applies, but ebnfutil has checks of its own.
+			why = "kept (" + rule + " applies, not inlined by ebnfutil)"
+		}
+		wlog.Printf("%s: %s: used %d time(s), %d term(s): %s", phase, name, refs[name], size[name], why)
+	}
+	return nil
+}
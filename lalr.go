@@ -0,0 +1,534 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/ebnf2y/grammar"
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// lrRule is a rule of the lowered grammar, its symbols being indices of
+// lalr.syms.
+type lrRule struct {
+	lhs  int
+	rhs  []int
+	prec string // yacc symbol giving the precedence of the rule, if any.
+	src  string // Eg. "Expr: Term Expr1".
+}
+
+// lrItem is a rule with a dot before its symbol rhs[dot].
+type lrItem struct {
+	rule, dot int
+}
+
+// lrState is a state of the LR(0) automaton.
+type lrState struct {
+	items []lrItem    // Kernel.
+	la    []bits      // Lookaheads of the kernel items.
+	next  map[int]int // Symbol: state.
+}
+
+// lalr is the LALR(1) automaton of the lowered grammar. The symbols are the
+// terminals, $end first, followed by the non terminals.
+type lalr struct {
+	byLhs    map[int][]int // Non terminal: rules.
+	first    map[int]bits  // Non terminal: FIRST set.
+	keys     []string      // Of the terminals, as by the analysis.
+	nterms   int
+	nullable map[int]bool
+	rules    []lrRule // Rule 0 accepts the start production.
+	states   []*lrState
+	syms     []string // yacc names.
+}
+
+// bits is a set of terminals represented by their indices.
+type bits []uint64
+
+// or adds the members of c to b and reports whether b changed.
+func (b bits) or(c bits) (changed bool) {
+	for i, v := range c {
+		if w := b[i] | v; w != b[i] {
+			b[i] = w
+			changed = true
+		}
+	}
+	return
+}
+
+func (l *lalr) newBits() bits {
+	// The extra terminal is the propagation marker.
+	return make(bits, (l.nterms+64)/64)
+}
+
+func (b bits) has(i int) bool { return b[i/64]&(1<<uint(i%64)) != 0 }
+
+func (b bits) add(i int) { b[i/64] |= 1 << uint(i%64) }
+
+func (b bits) remove(i int) { b[i/64] &^= 1 << uint(i%64) }
+
+// newLALR returns the LALR(1) automaton of the lowered productions of j, the
+// synthetic production start accepting the input.
+func (j *job) newLALR(start string) *lalr {
+	top := j.grm[start].Expr.(*ebnf.Name).String
+	nts := append([]string{start}, j.bnfOrder(start, top)...)
+	l := &lalr{byLhs: map[int][]int{}, first: map[int]bits{}, nullable: map[int]bool{}}
+
+	// Terminals of the rules, keyed like by the analysis.
+	m := map[string]bool{}
+	for _, name := range nts {
+		walk(j.grm[name].Expr, func(expr ebnf.Expression) {
+			if t, ok := grammar.Terminal(expr); ok {
+				m[t] = true
+			}
+		})
+	}
+	var a []string
+	for t := range m {
+		a = append(a, t)
+	}
+	sort.Strings(a)
+	index := map[string]int{}
+	l.syms = []string{grammar.EndOfInput}
+	l.keys = append([]string{grammar.EndOfInput}, a...)
+	for _, t := range a {
+		index[t] = len(l.syms)
+		l.syms = append(l.syms, j.rdToken(t))
+	}
+	l.nterms = len(l.syms)
+	for _, name := range nts {
+		index[name] = len(l.syms)
+		l.syms = append(l.syms, j.ruleName(name))
+	}
+
+	for _, name := range nts {
+		for _, v := range alternatives(j.grm[name].Expr) {
+			r := lrRule{lhs: index[name]}
+			var a []string
+			for _, x := range terms(v) {
+				t, ok := grammar.Terminal(x)
+				if !ok {
+					t = x.(*ebnf.Name).String
+				}
+				r.rhs = append(r.rhs, index[t])
+				a = append(a, l.syms[index[t]])
+				if ok {
+					r.prec = l.syms[index[t]]
+				}
+			}
+			if s, ok := j.prec[exprOffset(v)]; ok {
+				r.prec = j.precSym(s)
+			}
+			r.src = strings.TrimSpace(j.ruleName(name) + ": " + strings.Join(a, " "))
+			l.byLhs[r.lhs] = append(l.byLhs[r.lhs], len(l.rules))
+			l.rules = append(l.rules, r)
+		}
+	}
+	l.firsts()
+	l.lr0()
+	l.lookaheads()
+	return l
+}
+
+// firsts computes the nullable non terminals and their FIRST sets.
+func (l *lalr) firsts() {
+	for i := l.nterms; i < len(l.syms); i++ {
+		l.first[i] = l.newBits()
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range l.rules {
+			if l.first[r.lhs].or(l.firstOf(r.rhs, nil)) {
+				changed = true
+			}
+			if !l.nullable[r.lhs] && l.nullableSeq(r.rhs) {
+				l.nullable[r.lhs] = true
+				changed = true
+			}
+		}
+	}
+}
+
+func (l *lalr) nullableSeq(a []int) bool {
+	for _, v := range a {
+		if !l.nullable[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstOf returns the FIRST set of the symbols a followed by la.
+func (l *lalr) firstOf(a []int, la bits) bits {
+	b := l.newBits()
+	for _, v := range a {
+		if v < l.nterms {
+			b.add(v)
+			return b
+		}
+
+		b.or(l.first[v])
+		if !l.nullable[v] {
+			return b
+		}
+	}
+	if la != nil {
+		b.or(la)
+	}
+	return b
+}
+
+// closure returns the items of the closure of kernel, the lookaheads of the
+// kernel items being la, in order of addition, and their lookaheads.
+func (l *lalr) closure(kernel []lrItem, la []bits) ([]lrItem, map[lrItem]bits) {
+	var a, q []lrItem
+	m := map[lrItem]bits{}
+	add := func(it lrItem, b bits) {
+		c, ok := m[it]
+		if !ok {
+			c = l.newBits()
+			m[it] = c
+			a = append(a, it)
+		}
+		if c.or(b) || !ok {
+			q = append(q, it)
+		}
+	}
+	for i, it := range kernel {
+		add(it, la[i])
+	}
+	for len(q) != 0 {
+		it := q[0]
+		q = q[1:]
+		r := l.rules[it.rule]
+		if it.dot == len(r.rhs) || r.rhs[it.dot] < l.nterms {
+			continue
+		}
+
+		b := l.firstOf(r.rhs[it.dot+1:], m[it])
+		for _, rule := range l.byLhs[r.rhs[it.dot]] {
+			add(lrItem{rule, 0}, b)
+		}
+	}
+	return a, m
+}
+
+// lr0 computes the states of the LR(0) automaton.
+func (l *lalr) lr0() {
+	index := map[string]int{}
+	key := func(a []lrItem) string {
+		return fmt.Sprint(a)
+	}
+	l.states = []*lrState{{items: []lrItem{{0, 0}}}}
+	index[key(l.states[0].items)] = 0
+	for i := 0; i < len(l.states); i++ {
+		s := l.states[i]
+		s.next = map[int]int{}
+		la := make([]bits, len(s.items))
+		for k := range la {
+			la[k] = l.newBits()
+		}
+		items, _ := l.closure(s.items, la)
+		next := map[int][]lrItem{}
+		var syms []int
+		for _, it := range items {
+			r := l.rules[it.rule]
+			if it.dot == len(r.rhs) {
+				continue
+			}
+
+			x := r.rhs[it.dot]
+			if next[x] == nil {
+				syms = append(syms, x)
+			}
+			next[x] = append(next[x], lrItem{it.rule, it.dot + 1})
+		}
+		sort.Ints(syms)
+		for _, x := range syms {
+			kernel := next[x]
+			sort.Sort(byItem(kernel))
+			k := key(kernel)
+			n, ok := index[k]
+			if !ok {
+				n = len(l.states)
+				index[k] = n
+				l.states = append(l.states, &lrState{items: kernel})
+			}
+			s.next[x] = n
+		}
+	}
+}
+
+type byItem []lrItem
+
+func (a byItem) Len() int { return len(a) }
+func (a byItem) Less(i, j int) bool {
+	return a[i].rule < a[j].rule || a[i].rule == a[j].rule && a[i].dot < a[j].dot
+}
+func (a byItem) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// lookaheads computes the LALR(1) lookaheads of the kernel items, the ones
+// generated spontaneously and the ones propagated from the kernel items of
+// the predecessor states.
+func (l *lalr) lookaheads() {
+	marker := l.nterms
+	kernel := make([]map[lrItem]int, len(l.states))
+	for i, s := range l.states {
+		kernel[i] = map[lrItem]int{}
+		s.la = make([]bits, len(s.items))
+		for k, it := range s.items {
+			kernel[i][it] = k
+			s.la[k] = l.newBits()
+		}
+	}
+	l.states[0].la[0].add(0)
+
+	type ref struct{ state, item int }
+	prop := map[ref][]ref{}
+	for i, s := range l.states {
+		for k, it := range s.items {
+			b := l.newBits()
+			b.add(marker)
+			items, la := l.closure([]lrItem{it}, []bits{b})
+			for _, v := range items {
+				r := l.rules[v.rule]
+				if v.dot == len(r.rhs) {
+					continue
+				}
+
+				n := s.next[r.rhs[v.dot]]
+				to := ref{n, kernel[n][lrItem{v.rule, v.dot + 1}]}
+				c := l.newBits()
+				c.or(la[v])
+				if c.has(marker) {
+					c.remove(marker)
+					prop[ref{i, k}] = append(prop[ref{i, k}], to)
+				}
+				l.states[to.state].la[to.item].or(c)
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, s := range l.states {
+			for k := range s.items {
+				for _, to := range prop[ref{i, k}] {
+					if l.states[to.state].la[to.item].or(s.la[k]) {
+						changed = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// tables returns the ACTION table, a map of terminals to actions per state,
+// encoded like by -table, and the numbers of the unresolved conflicts. The
+// shift/reduce conflicts are resolved by the precedence levels like by yacc,
+// else by shifting, the reduce/reduce ones by the rule defined first.
+func (l *lalr) tables(levels []*level, precSym func(string) string) (action []map[int]int, sr, rr int) {
+	type prec struct {
+		i    int
+		kind string
+	}
+	precs := map[string]prec{}
+	for i, v := range levels {
+		for _, s := range v.syms {
+			precs[precSym(s)] = prec{i, v.kind}
+		}
+	}
+	for _, s := range l.states {
+		m := map[int]int{}
+		errs := map[int]bool{} // Of %nonassoc.
+		for t, n := range s.next {
+			if t < l.nterms {
+				m[t] = n + 1
+			}
+		}
+		items, la := l.closure(s.items, s.la)
+		sort.Sort(byItem(items))
+		for _, it := range items {
+			r := l.rules[it.rule]
+			if it.dot != len(r.rhs) {
+				continue
+			}
+
+			for t := 0; t < l.nterms; t++ {
+				if !la[it].has(t) || errs[t] {
+					continue
+				}
+
+				a := -it.rule - 1
+				if it.rule == 0 {
+					a = 0
+				}
+				old, ok := m[t]
+				switch {
+				case !ok:
+					m[t] = a
+				case old > 0:
+					rp, ok1 := precs[r.prec]
+					tp, ok2 := precs[l.syms[t]]
+					switch {
+					case !ok1 || !ok2 || rp.i == tp.i && rp.kind == "precedence":
+						sr++
+					case rp.i > tp.i || rp.i == tp.i && rp.kind == "left":
+						m[t] = a
+					case rp.i == tp.i && rp.kind == "nonassoc":
+						delete(m, t)
+						errs[t] = true
+					}
+				default:
+					rr++
+				}
+			}
+		}
+		action = append(action, m)
+	}
+	return
+}
+
+// step returns stack after the reductions and the shift of the terminal t, or
+// the accept on $end, and whether t can follow the input read so far. The
+// stack passed in is not modified.
+func (l *lalr) step(action []map[int]int, stack []int, t int) ([]int, bool) {
+	stack = append([]int(nil), stack...)
+	for {
+		a, ok := action[stack[len(stack)-1]][t]
+		switch {
+		case !ok:
+			return nil, false
+		case a >= 0:
+			if a > 0 {
+				stack = append(stack, a-1)
+			}
+			return stack, true
+		}
+
+		r := l.rules[-a-1]
+		stack = stack[:len(stack)-len(r.rhs)]
+		stack = append(stack, l.states[stack[len(stack)-1]].next[r.lhs])
+	}
+}
+
+// followAt writes to w, as a JSON list, the terminals which can follow words,
+// a prefix of literals or names of lexical tokens like for -accept, $end if
+// the prefix is a complete input. The terminals are the ones of the parser
+// of -table. At the first word which cannot follow the preceding ones, the
+// error point, the list is of the terminals expected there instead. It
+// reports whether all the words were accepted.
+func (j *job) followAt(w io.Writer, start string, words []string) (ok bool, err error) {
+	l := j.newLALR(start)
+	action, _, _ := l.tables(j.levels, j.precSym)
+	index := map[string]int{}
+	for i, k := range l.keys {
+		index[k] = i
+	}
+	stack := []int{0}
+	ok = true
+	for i, word := range words {
+		t, found := index[word]
+		if s, err := strconv.Unquote(word); err == nil {
+			t, found = index[strconv.Quote(s)]
+		} else if !found {
+			t, found = index[strconv.Quote(word)]
+		}
+		if !found || word == grammar.EndOfInput {
+			return false, fmt.Errorf("-follow-at: %s is not a terminal of the grammar", word)
+		}
+
+		next, valid := l.step(action, stack, t)
+		if !valid {
+			wlog.Printf("-follow-at: token %d, %s, cannot follow the preceding ones", i+1, word)
+			ok = false
+			break
+		}
+
+		stack = next
+	}
+
+	a := []string{}
+	for t, k := range l.keys {
+		if _, valid := l.step(action, stack, t); valid {
+			a = append(a, k)
+		}
+	}
+	b, err := json.MarshalIndent(a, "", "\t")
+	if err != nil {
+		return false, err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return
+}
+
+// renderTable writes the LALR(1) parse tables of the lowered grammar as Go
+// data for a table driven parser, documented in doc.go. fn names the output
+// in the report of the conflicts.
+func (j *job) renderTable(w io.Writer, fn, start string) error {
+	l := j.newLALR(start)
+	action, sr, rr := l.tables(j.levels, j.precSym)
+	if sr+rr != 0 {
+		wlog.Printf("%s: conflicts: %d shift/reduce, %d reduce/reduce", fn, sr, rr)
+	}
+
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "LALR(1) parse tables")
+	f.Format("package %s //%s real package name\n\n", j.pkg, todo)
+	j.tableData(f, l, action)
+	return sw.err
+}
+
+// tableData writes the token constants and the tables of l, action being its
+// ACTION table.
+func (j *job) tableData(f strutil.Formatter, l *lalr, action []map[int]int) {
+	j.tokenConsts(f)
+	f.Format("// yyNTerminals is the number of terminals, the first symbols.\nconst yyNTerminals = %d\n\n", l.nterms)
+	f.Format("// yySymbols are the names of the terminals and non terminals.\nvar yySymbols = []string{%i\n")
+	for i, v := range l.syms {
+		f.Format("%s, // %d\n", strconv.Quote(v), i)
+	}
+	f.Format("%u}\n\n")
+	f.Format("// yyTerminals maps the tokens of the lexer to their terminals.\nvar yyTerminals = map[int]int{%i\nyyEOF: 0,\n")
+	for i, v := range l.syms[1:l.nterms] {
+		f.Format("%s: %d,\n", v, i+1)
+	}
+	f.Format("%u}\n\n")
+	f.Format("// yyRules are the left hand side symbols and lengths of the rules.\nvar yyRules = [][2]int{%i\n")
+	for i, r := range l.rules {
+		f.Format("{%d, %d}, // %d %s\n", r.lhs, len(r.rhs), i, r.src)
+	}
+	f.Format("%u}\n\n")
+	f.Format("// yyAction are the terminal, action pairs of the states: shift to\n// state a-1 if a > 0, reduce by rule -a-1 if a < 0 and accept if a == 0.\nvar yyAction = [][]int{%i\n")
+	for i, m := range action {
+		var a []string
+		for t := 0; t < l.nterms; t++ {
+			if v, ok := m[t]; ok {
+				a = append(a, fmt.Sprintf("%d, %d", t, v))
+			}
+		}
+		f.Format("{%s}, // %d\n", strings.Join(a, ", "), i)
+	}
+	f.Format("%u}\n\n")
+	f.Format("// yyGoto are the non terminal, state pairs of the states.\nvar yyGoto = [][]int{%i\n")
+	for i, s := range l.states {
+		var a []string
+		for x := l.nterms; x < len(l.syms); x++ {
+			if n, ok := s.next[x]; ok {
+				a = append(a, fmt.Sprintf("%d, %d", x, n))
+			}
+		}
+		f.Format("{%s}, // %d\n", strings.Join(a, ", "), i)
+	}
+	f.Format("%u}\n")
+}
@@ -0,0 +1,402 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// lalrpopKeywords are the words reserved by lalrpop.
+var lalrpopKeywords = map[string]bool{
+	"else":    true,
+	"enum":    true,
+	"extern":  true,
+	"for":     true,
+	"grammar": true,
+	"if":      true,
+	"match":   true,
+	"mut":     true,
+	"pub":     true,
+	"type":    true,
+	"use":     true,
+	"where":   true,
+}
+
+// lalrpop lowers the EBNF non terminal productions to lalrpop rules. Nested
+// alternatives, not supported by lalrpop, are lifted to rules of their own.
+type lalrpop struct {
+	j       *job
+	lifted  map[string]ebnf.Expression
+	queue   []string // Lifted rules not yet written.
+	regexps map[string]bool
+	tokens  map[string]bool // Literals used by the rules.
+	used    map[string]bool // Lexical productions used by the rules.
+}
+
+// name returns the lalrpop name of the production name.
+func (l *lalrpop) name(name string) string {
+	if ast.IsExported(name) {
+		name = l.j.ruleName(name)
+	}
+	if lalrpopKeywords[name] {
+		return name + "_"
+	}
+
+	return name
+}
+
+// lift returns the name of a new rule for the alternatives of expr, found in
+// the named production.
+func (l *lalrpop) lift(expr ebnf.Expression, in string) string {
+	name := l.j.inventName(in, sep)
+	l.lifted[name] = expr
+	l.queue = append(l.queue, name)
+	return name
+}
+
+// seq returns the lalrpop symbols of expr, an alternative of the named
+// production.
+func (l *lalrpop) seq(expr ebnf.Expression, in string) string {
+	x, ok := expr.(ebnf.Sequence)
+	if !ok {
+		return l.term(expr, in)
+	}
+
+	var a []string
+	for i := 0; i < len(x); i++ {
+		// X {X} is X+.
+		if i+1 < len(x) {
+			if r, ok := x[i+1].(*ebnf.Repetition); ok && sameExpr(x[i], r.Body) {
+				a = append(a, l.group(x[i], in)+"+")
+				i++
+				continue
+			}
+		}
+
+		a = append(a, l.term(x[i], in))
+	}
+	return strings.Join(a, " ")
+}
+
+// group returns the lalrpop symbols of expr as a single term, parenthesized
+// if necessary.
+func (l *lalrpop) group(expr ebnf.Expression, in string) string {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		if len(x) > 1 {
+			return l.lift(x, in)
+		}
+
+		return l.group(x[0], in)
+	case ebnf.Sequence:
+		if len(x) > 1 {
+			return "(" + l.seq(x, in) + ")"
+		}
+
+		return l.group(x[0], in)
+	case *ebnf.Group:
+		return l.group(x.Body, in)
+	default:
+		return l.term(expr, in)
+	}
+}
+
+func (l *lalrpop) term(expr ebnf.Expression, in string) string {
+	switch x := expr.(type) {
+	case nil:
+		return ""
+	case ebnf.Alternative:
+		return l.group(x, in)
+	case ebnf.Sequence:
+		return l.seq(x, in)
+	case *ebnf.Group:
+		return l.group(x.Body, in)
+	case *ebnf.Option:
+		return l.group(x.Body, in) + "?"
+	case *ebnf.Repetition:
+		return l.group(x.Body, in) + "*"
+	case *ebnf.Name:
+		if !ast.IsExported(x.String) {
+			l.used[x.String] = true
+		}
+		return l.name(x.String)
+	case *ebnf.Token:
+		l.tokens[x.String] = true
+		return rustQuote(x.String)
+	case *ebnf.Range:
+		s, _ := l.regexp(x, nil)
+		l.regexps[s] = true
+		return rawString(s)
+	default:
+		panic("internal error")
+	}
+}
+
+// regexp returns the Rust regular expression of expr, a lexical expression,
+// or false if the expression refers to itself through the productions in
+// seen.
+func (l *lalrpop) regexp(expr ebnf.Expression, seen map[string]bool) (string, bool) {
+	list := func(a []ebnf.Expression, sep string) (string, bool) {
+		var b []string
+		for _, v := range a {
+			s, ok := l.regexp(v, seen)
+			if !ok {
+				return "", false
+			}
+
+			b = append(b, s)
+		}
+		return strings.Join(b, sep), true
+	}
+	group := func(body ebnf.Expression, op string) (string, bool) {
+		s, ok := l.regexp(body, seen)
+		return "(?:" + s + ")" + op, ok
+	}
+	switch x := expr.(type) {
+	case nil:
+		return "", true
+	case ebnf.Alternative:
+		s, ok := list(x, "|")
+		return "(?:" + s + ")", ok
+	case ebnf.Sequence:
+		return list(x, "")
+	case *ebnf.Group:
+		return group(x.Body, "")
+	case *ebnf.Option:
+		return group(x.Body, "?")
+	case *ebnf.Repetition:
+		return group(x.Body, "*")
+	case *ebnf.Name:
+		prod, ok := l.j.grm[x.String]
+		if !ok || seen[x.String] || ast.IsExported(x.String) {
+			return "", false
+		}
+
+		if seen == nil {
+			seen = map[string]bool{}
+		}
+		seen[x.String] = true
+		s, ok := group(prod.Expr, "")
+		delete(seen, x.String)
+		return s, ok
+	case *ebnf.Token:
+		return regexp.QuoteMeta(x.String), true
+	case *ebnf.Range:
+		return "[" + classChar(x.Begin.String) + "-" + classChar(x.End.String) + "]", true
+	default:
+		panic("internal error")
+	}
+}
+
+func classChar(s string) string {
+	if strings.ContainsAny(s, `\]-[^`) {
+		return `\` + s
+	}
+
+	return s
+}
+
+// rustQuote returns s as a Rust string literal.
+func rustQuote(s string) string {
+	var a []string
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			a = append(a, `\`+string(c))
+		case c == '\n':
+			a = append(a, `\n`)
+		case c == '\r':
+			a = append(a, `\r`)
+		case c == '\t':
+			a = append(a, `\t`)
+		case !unicode.IsPrint(c):
+			a = append(a, fmt.Sprintf(`\u{%x}`, c))
+		default:
+			a = append(a, string(c))
+		}
+	}
+	return `"` + strings.Join(a, "") + `"`
+}
+
+// rawString returns s as a Rust raw string literal.
+func rawString(s string) string {
+	if strings.Contains(s, `"`) {
+		return `r#"` + s + `"#`
+	}
+
+	return `r"` + s + `"`
+}
+
+// sameExpr reports whether a and b are the same expression.
+func sameExpr(a, b ebnf.Expression) bool {
+	list := func(a, b []ebnf.Expression) bool {
+		if len(a) != len(b) {
+			return false
+		}
+
+		for i, v := range a {
+			if !sameExpr(v, b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case ebnf.Alternative:
+		y, ok := b.(ebnf.Alternative)
+		return ok && list(x, y)
+	case ebnf.Sequence:
+		y, ok := b.(ebnf.Sequence)
+		return ok && list(x, y)
+	case *ebnf.Group:
+		y, ok := b.(*ebnf.Group)
+		return ok && sameExpr(x.Body, y.Body)
+	case *ebnf.Option:
+		y, ok := b.(*ebnf.Option)
+		return ok && sameExpr(x.Body, y.Body)
+	case *ebnf.Repetition:
+		y, ok := b.(*ebnf.Repetition)
+		return ok && sameExpr(x.Body, y.Body)
+	case *ebnf.Name:
+		y, ok := b.(*ebnf.Name)
+		return ok && x.String == y.String
+	case *ebnf.Token:
+		y, ok := b.(*ebnf.Token)
+		return ok && x.String == y.String
+	case *ebnf.Range:
+		y, ok := b.(*ebnf.Range)
+		return ok && x.Begin.String == y.Begin.String && x.End.String == y.End.String
+	}
+	return false
+}
+
+// rule writes the lalrpop rule of expr, the alternatives of the named
+// production.
+func (l *lalrpop) rule(f strutil.Formatter, name string, expr ebnf.Expression, pub bool) {
+	if pub {
+		f.Format("pub ")
+	}
+	f.Format("%s: () = {%i\n", l.name(name))
+	alts, ok := expr.(ebnf.Alternative)
+	if !ok {
+		alts = ebnf.Alternative{expr}
+	}
+	for _, alt := range alts {
+		s := l.seq(alt, name)
+		if s != "" {
+			s += " "
+		}
+		f.Format("%s=> (),\n", s)
+	}
+	f.Format("%u};\n\n")
+}
+
+// renderLalrpop writes the EBNF grammar as a lalrpop grammar, the production
+// top first and marked pub. Lexical productions used by the rules become
+// rules of a single regular expression matched by the lalrpop lexer.
+func (j *job) renderLalrpop(w io.Writer, top string) error {
+	l := &lalrpop{
+		j:       j,
+		lifted:  map[string]ebnf.Expression{},
+		regexps: map[string]bool{},
+		tokens:  map[string]bool{},
+		used:    map[string]bool{},
+	}
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	f.Format(`//%s Put your favorite license here
+
+// lalrpop grammar generated by ebnf2y[1]
+// at %s
+//
+//  $ %s
+//
+//   [1]: http://github.com/cznic/ebnf2y
+
+//%s real types and actions, eg. => Node::new(<>)
+grammar;
+
+`, todo, time.Now(), strings.Join(os.Args, " "), todo)
+	a := []string{top}
+	for name := range j.grm {
+		if ast.IsExported(name) && name != top {
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a[1:])
+
+	// Written after the match block, which needs the terminals.
+	var rules bytes.Buffer
+	b := strutil.IndentFormatter(&rules, "\t")
+	for _, name := range a {
+		l.rule(b, name, j.grm[name].Expr, name == top)
+		for len(l.queue) != 0 {
+			s := l.queue[0]
+			l.queue = l.queue[1:]
+			l.rule(b, s, l.lifted[s], false)
+		}
+	}
+
+	var tokens []string
+	for name := range l.used {
+		tokens = append(tokens, name)
+	}
+	sort.Strings(tokens)
+	for _, name := range tokens {
+		expr := j.grm[name].Expr
+		s, ok := l.regexp(expr, map[string]bool{name: true})
+		switch {
+		case expr == nil:
+			b.Format("%s: () = r\"\" => (); //%s regular expression of %s\n", l.name(name), todo, name)
+		case !ok:
+			b.Format("%s: () = r\"\" => (); //%s %s is recursive, not a regular expression\n", l.name(name), todo, name)
+		default:
+			l.regexps[s] = true
+			b.Format("%s: () = %s => ();\n", l.name(name), rawString(s))
+		}
+	}
+
+	var skip []string
+	for name := range j.skip {
+		if prod, ok := j.grm[name]; ok {
+			if s, ok := l.regexp(prod.Expr, map[string]bool{name: true}); ok {
+				skip = append(skip, s)
+			}
+		}
+	}
+	sort.Strings(skip)
+	if len(skip) != 0 || len(l.tokens) != 0 && len(l.regexps) != 0 {
+		// Literals take precedence over the regular expressions.
+		f.Format("match {%i\n")
+		for _, s := range skip {
+			f.Format("%s => { },\n", rawString(s))
+		}
+		var lits []string
+		for s := range l.tokens {
+			lits = append(lits, s)
+		}
+		sort.Strings(lits)
+		for _, s := range lits {
+			f.Format("%s,\n", rustQuote(s))
+		}
+		f.Format("%u} else {%i\n_\n%u}\n\n")
+	}
+	sw.Write(rules.Bytes())
+	return sw.err
+}
@@ -0,0 +1,445 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// initialState is the start condition of tokens without a %state directive.
+const initialState = "INITIAL"
+
+// commentSyntaxes are the golex patterns of the comments of the -comment-syntax
+// languages.
+var commentSyntaxes = map[string][]string{
+	"c":     {`"/*"([^*]|"*"+[^*/])*"*"+"/"`},
+	"cpp":   {`"/*"([^*]|"*"+[^*/])*"*"+"/"`, `"//".*`},
+	"lua":   {`"--[["([^\]]|"]"[^\]])*"]]"`, `"--".*`},
+	"none":  nil,
+	"shell": {`#.*`},
+}
+
+// commentRules returns the golex patterns of the comments skipped by the
+// lexer skeleton, s naming the language or giving a pattern as re:pattern.
+func commentRules(s string) ([]string, error) {
+	if strings.HasPrefix(s, "re:") {
+		if s = s[len("re:"):]; s == "" {
+			return nil, fmt.Errorf("-comment-syntax: empty pattern")
+		}
+
+		return []string{s}, nil
+	}
+
+	a, ok := commentSyntaxes[s]
+	if !ok {
+		return nil, fmt.Errorf("-comment-syntax: unknown %q, must be none, c, cpp, shell, lua or re:pattern", s)
+	}
+
+	return a, nil
+}
+
+// bindStates returns the start conditions declared by the %states directives
+// in ds and the start conditions of the lexical productions in grm, bound by
+// the %state directives following them.
+func bindStates(grm ebnfutil.Grammar, ds []*directive) (states []string, m map[string][]string, err error) {
+	declared := map[string]bool{initialState: true}
+	for _, d := range ds {
+		if d.name != "states" {
+			continue
+		}
+
+		for _, s := range d.args {
+			if declared[s] {
+				return nil, nil, fmt.Errorf("%s: %%states: %s redeclared", d.pos, s)
+			}
+
+			declared[s] = true
+			states = append(states, s)
+		}
+	}
+
+	m = map[string][]string{}
+	for _, d := range ds {
+		if d.name != "state" {
+			continue
+		}
+
+		if len(d.args) == 0 {
+			return nil, nil, fmt.Errorf("%s: missing argument of %%state", d.pos)
+		}
+
+		prod := preceding(grm, d.pos.Offset)
+		if prod == nil || ast.IsExported(prod.Name.String) {
+			return nil, nil, fmt.Errorf("%s: %%state must follow a lexical production", d.pos)
+		}
+
+		name := prod.Name.String
+		if _, ok := m[name]; ok {
+			return nil, nil, fmt.Errorf("%s: multiple %%state directives for %s", d.pos, name)
+		}
+
+		for _, s := range d.args {
+			if !declared[s] {
+				return nil, nil, fmt.Errorf("%s: %%state: undeclared start condition %s", d.pos, s)
+			}
+		}
+		m[name] = d.args
+	}
+	return
+}
+
+// lexChar returns r in a form usable in a character class.
+func lexChar(r rune) string {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return string(r)
+	case r < 0x80:
+		return fmt.Sprintf("\\x%02x", r)
+	default:
+		return fmt.Sprintf("\\u%04x", r)
+	}
+}
+
+// lexPattern returns the golex regular expression of a lexical expression.
+// References to the fragments in grm are replaced by their expressions.
+func lexPattern(expr ebnf.Expression, grm ebnfutil.Grammar, fragments map[string]bool) string {
+	switch x := expr.(type) {
+	case nil:
+		return `""`
+	case ebnf.Alternative:
+		a := []string{}
+		for _, v := range x {
+			a = append(a, lexPattern(v, grm, fragments))
+		}
+		return "(" + strings.Join(a, "|") + ")"
+	case ebnf.Sequence:
+		a := []string{}
+		for _, v := range x {
+			a = append(a, lexPattern(v, grm, fragments))
+		}
+		return strings.Join(a, "")
+	case *ebnf.Group:
+		return "(" + lexPattern(x.Body, grm, fragments) + ")"
+	case *ebnf.Option:
+		return "(" + lexPattern(x.Body, grm, fragments) + ")?"
+	case *ebnf.Repetition:
+		return "(" + lexPattern(x.Body, grm, fragments) + ")*"
+	case *ebnf.Name:
+		if fragments[x.String] {
+			return "(" + lexPattern(grm[x.String].Expr, grm, fragments) + ")"
+		}
+
+		return "{" + x.String + "}"
+	case *ebnf.Token:
+		return strconv.Quote(x.String)
+	case *ebnf.Range:
+		lo := []rune(x.Begin.String)
+		hi := []rune(x.End.String)
+		return "[" + lexChar(lo[0]) + "-" + lexChar(hi[0]) + "]"
+	default:
+		panic("internal error")
+	}
+}
+
+// renderLexer writes a golex skeleton of a lexer for the .y file last
+// rendered. Lexical productions without an expression get their name as the
+// pattern.
+func (j *job) renderLexer(w io.Writer) error {
+	// Lexical productions needed by the tokens, directly or indirectly.
+	need := map[string]bool{}
+	var mark func(name string)
+	mark = func(name string) {
+		prod, ok := j.lexical[name]
+		if !ok || need[name] {
+			return
+		}
+
+		// Fragments are inlined, but may need definitions.
+		if !j.fragments[name] {
+			need[name] = true
+		}
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok {
+				mark(x.String)
+			}
+		})
+	}
+	var tokens, skip []string
+	for name := range j.rep.Tokens {
+		tokens = append(tokens, name)
+		mark(name)
+	}
+	for name := range j.skip {
+		skip = append(skip, name)
+		mark(name)
+	}
+	var defs []string
+	for name := range need {
+		defs = append(defs, name)
+	}
+	sort.Strings(tokens)
+	sort.Strings(skip)
+	sort.Strings(defs)
+
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	f.Format(`/*
+
+//%s Put your favorite license here
+
+golex skeleton generated by ebnf2y[1]
+
+  $ %s
+
+CAUTION: If this file is a Go source file (*.go), it was generated
+automatically by '$ golex' from a *.l file - DO NOT EDIT in that case!
+
+  [1]: http://github.com/cznic/ebnf2y
+
+*/
+
+%%{
+
+package %s
+
+import (
+	"fmt"
+	"unicode"
+)
+
+type lexer struct {
+	c     int
+	col   int
+	errs  []error
+	i     int
+	lcol  int
+	line  int
+	ncol  int
+	nline int
+	sc    int
+	src   string
+	val   []byte
+}
+
+func newLexer(src string) (l *lexer) {
+	l = &lexer{
+		src:   src,
+		nline: 1,
+		ncol:  0,
+	}
+	l.next()
+	return
+}
+
+func (l *lexer) next() int {
+	if l.c != 0 {
+		l.val = append(l.val, byte(l.c))
+	}
+	l.c = 0
+	if l.i < len(l.src) {
+		l.c = int(l.src[l.i])
+		l.i++
+	}
+	switch l.c {
+	case '\n':
+		l.lcol = l.ncol
+		l.nline++
+		l.ncol = 0
+	default:
+		l.ncol++
+	}
+	return l.c
+}
+
+func (l *lexer) err(s string, arg ...interface{}) {
+	err := fmt.Errorf(fmt.Sprintf("%%d:%%d ", l.line, l.col)+s, arg...)
+	l.errs = append(l.errs, err)
+}
+
+func (l *lexer) Error(s string) {
+	l.err(s)
+}
+
+func (l *lexer) Lex(lval *%s) int {
+	const (
+		%s = iota
+`, todo, strings.Join(os.Args, " "), j.pkg, j.symType, initialState)
+	for _, s := range j.lexStates {
+		f.Format("\t\t%s\n", s)
+	}
+	f.Format("\t)\n\n")
+	if len(j.lexStates) != 0 {
+		f.Format("\t//%s set l.sc where the tokens of %s start.\n", todo, strings.Join(j.lexStates, ", "))
+	}
+	f.Format("\tc0, c := 0, l.c\n%%}\n\n")
+	for _, name := range defs {
+		pattern := strconv.Quote(name)
+		if expr := j.lexical[name].Expr; expr != nil {
+			pattern = lexPattern(expr, j.lexical, j.fragments)
+		}
+		f.Format("%s\t%s\n", name, pattern)
+	}
+	if len(defs) != 0 {
+		f.Format("\n")
+	}
+	f.Format("%%yyc c\n%%yyn c = l.next()\n%%yyt l.sc\n\n")
+	if len(j.lexStates) != 0 {
+		f.Format("%%x %s\n\n", strings.Join(j.lexStates, " "))
+	}
+	f.Format("%%%%\n\t\t\tl.val = l.val[:0]\n\t\t\tc0, l.line, l.col = l.c, l.nline, l.ncol\n\n<*>\\0\t\t\treturn 0\n\n")
+	switch {
+	case len(skip) == 0 && !j.keepWhitespace:
+		f.Format("[ \\t\\n\\r]+\n\n")
+	case len(skip) == 0:
+		// nop
+	default:
+		for _, name := range skip {
+			f.Format("%s{%s}\n", j.lexStart(name), name)
+		}
+		f.Format("\n")
+	}
+	for _, v := range j.comments {
+		f.Format("%s\n", v)
+	}
+	if len(j.comments) != 0 {
+		f.Format("\n")
+	}
+
+	var lits, all []string
+	for lit := range j.rep.Literals {
+		all = append(all, lit)
+		if !j.inlineLiteral(lit) {
+			lits = append(lits, lit)
+		}
+	}
+	sort.Strings(lits)
+	toks := map[string]string{}
+	for name, lit := range singleLiterals(j.lexical, j.skip, j.tokenStates) {
+		if _, ok := j.rep.Tokens[name]; ok {
+			toks[name] = lit
+		}
+	}
+	prefixes := terminalPrefixes(all, toks)
+	annotate := func(t string) {
+		if a := prefixes[t]; len(a) != 0 {
+			f.Format(" // A prefix of %s, the longest match wins.", strings.Join(a, ", "))
+		}
+	}
+	for _, lit := range lits {
+		f.Format("%s\t\treturn %s", strconv.Quote(lit), j.term2name[lit])
+		annotate(strconv.Quote(lit))
+		f.Format("\n")
+	}
+	if len(lits) != 0 {
+		f.Format("\n")
+	}
+
+	for _, name := range tokens {
+		f.Format("%s{%s}\t\t", j.lexStart(name), name)
+		if a := prefixes[name]; len(a) != 0 {
+			f.Format("// A prefix of %s, the longest match wins.\n\t\t\t", strings.Join(a, ", "))
+		}
+		if s := j.tokenStates[name]; len(s) != 0 {
+			f.Format("l.sc = %s //%s next start condition\n\t\t\t", initialState, todo)
+		}
+		if typ, ok := j.tokenTypes[name]; ok {
+			f.Format("//%s lval.%s = the %s value of l.val\n\t\t\treturn %s\n\n", todo, unionField(typ), typ, j.term2name[name])
+			continue
+		}
+
+		f.Format("lval.item = string(l.val)\n\t\t\treturn %s\n\n", j.term2name[name])
+	}
+	f.Format(".\t\t\treturn c0\n\n%%%%\n\t\t\treturn int(unicode.ReplacementChar)\n}\n")
+	return sw.err
+}
+
+// lexStart returns the start condition prefix of the rule of the named
+// token.
+func (j *job) lexStart(name string) string {
+	if s := j.tokenStates[name]; len(s) != 0 {
+		return "<" + strings.Join(s, ",") + ">"
+	}
+
+	return ""
+}
+
+var reIdent = regexp.MustCompile(`^[_\pL][_\pL\pN]*$`)
+
+// lexerInterface describes an existing lexer the parser is adapted to.
+type lexerInterface struct {
+	typ       string // Go type of the lexer.
+	lex       string // Method returning the next token.
+	err       string // Method reporting a syntax error.
+	tokenType string // Type of the semantic value passed to lex, -symtype if blank.
+}
+
+// parseLexerInterface parses s, the type[,lex[,error]] argument of
+// -lexer-interface.
+func parseLexerInterface(s, tokenType string) (*lexerInterface, error) {
+	a := strings.Split(s, ",")
+	if len(a) > 3 || strings.TrimSpace(a[0]) == "" {
+		return nil, fmt.Errorf("-lexer-interface: invalid %q, must be type[,lex[,error]]", s)
+	}
+
+	l := &lexerInterface{typ: strings.TrimSpace(a[0]), lex: "Lex", err: "Error", tokenType: tokenType}
+	for i, p := range []*string{&l.lex, &l.err} {
+		if i+1 >= len(a) {
+			break
+		}
+
+		if *p = strings.TrimSpace(a[i+1]); !reIdent.MatchString(*p) {
+			return nil, fmt.Errorf("-lexer-interface: invalid method name %q", *p)
+		}
+	}
+	if tokenType != "" && !reIdent.MatchString(tokenType) {
+		return nil, fmt.Errorf("-token-type: invalid type name %q", tokenType)
+	}
+
+	return l, nil
+}
+
+// adapt returns the Go expression making the lexer value of expr a yyLexer.
+func (l *lexerInterface) adapt(expr string) string {
+	if l == nil {
+		return expr
+	}
+
+	return "lexerAdapter{" + expr + "}"
+}
+
+// render writes the declarations of the adapter of l to yyLexer, symType
+// being the type of the semantic values.
+func (l *lexerInterface) render(f strutil.Formatter, symType string) {
+	lval := "lval"
+	if l.tokenType != "" {
+		f.Format("// %s is the semantic value of the tokens.\ntype %s %s\n\n", l.tokenType, l.tokenType, symType)
+		lval = fmt.Sprintf("(*%s)(lval)", l.tokenType)
+	}
+	f.Format(`// lexerAdapter makes a %s a yyLexer, pass lexerAdapter{l} to yyParse.
+type lexerAdapter struct {%i
+l %s%u
+}
+
+func (a lexerAdapter) Lex(lval *%s) int {%i
+return a.l.%s(%s)%u
+}
+
+func (a lexerAdapter) Error(s string) {%i
+a.l.%s(s)%u
+}
+
+`, l.typ, l.typ, symType, l.lex, lval, l.err)
+}
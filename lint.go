@@ -0,0 +1,922 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+var (
+	warnings int
+	wlog     = log.New(os.Stderr, "", 0)
+)
+
+func warn(pos scanner.Position, format string, args ...interface{}) {
+	warnings++
+	s := fmt.Sprintf(format, args...)
+	switch {
+	case pos.IsValid():
+		wlog.Printf("%s: warning: %s", pos, s)
+	default:
+		wlog.Printf("warning: %s", s)
+	}
+}
+
+// checkWarnings terminates the program if there were any warnings and werror
+// is set.
+func checkWarnings(werror bool) {
+	if werror && warnings != 0 {
+		log.Fatalf("%d warning(s) treated as errors (-Werror)", warnings)
+	}
+}
+
+// skipTokens returns the set of lexical productions declared by the %skip
+// directives in ds.
+func skipTokens(grm ebnfutil.Grammar, ds []*directive) (m map[string]bool, err error) {
+	m = map[string]bool{}
+	for _, d := range ds {
+		if d.name != "skip" {
+			continue
+		}
+
+		for _, name := range d.args {
+			prod, ok := grm[name]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("%s: %%skip %s: undefined production", d.pos, name)
+			case ast.IsExported(name):
+				return nil, fmt.Errorf("%s: %%skip %s: not a lexical production", prod.Pos(), name)
+			}
+
+			m[name] = true
+		}
+	}
+	return
+}
+
+// noinlineProductions returns the set of non terminal productions declared by
+// the %noinline directives in ds.
+func noinlineProductions(grm ebnfutil.Grammar, ds []*directive) (m map[string]bool, err error) {
+	m = map[string]bool{}
+	for _, d := range ds {
+		if d.name != "noinline" {
+			continue
+		}
+
+		for _, name := range d.args {
+			prod, ok := grm[name]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("%s: %%noinline %s: undefined production", d.pos, name)
+			case !ast.IsExported(name):
+				return nil, fmt.Errorf("%s: %%noinline %s: not a non terminal production", prod.Pos(), name)
+			}
+
+			m[name] = true
+		}
+	}
+	return
+}
+
+// tokenTypes returns the Go types of the values of the lexical productions
+// declared by name : type = ... . in ds.
+func tokenTypes(grm ebnfutil.Grammar, ds []*directive, fragments map[string]bool) (m map[string]string, err error) {
+	m = map[string]string{}
+	for _, d := range ds {
+		if d.name != typeDecl {
+			continue
+		}
+
+		name := d.args[0]
+		switch {
+		case ast.IsExported(name):
+			return nil, fmt.Errorf("%s: %s : %s: not a lexical production", d.pos, name, d.args[1])
+		case fragments[name]:
+			return nil, fmt.Errorf("%s: %s : %s: a fragment is never a token", d.pos, name, d.args[1])
+		case !has(grm, name):
+			return nil, fmt.Errorf("%s: %s : %s: undefined production", d.pos, name, d.args[1])
+		}
+
+		m[name] = d.args[1]
+	}
+	return
+}
+
+// targetInlines returns the non terminal productions the %inline directives
+// in ds name for target, in the order of the directives. The first argument
+// of a directive is a comma separated list of targets.
+func targetInlines(grm ebnfutil.Grammar, ds []*directive, target, start string) (a []string, err error) {
+	seen := map[string]bool{}
+	for _, d := range ds {
+		if d.name != "inline" {
+			continue
+		}
+
+		if len(d.args) < 2 {
+			return nil, fmt.Errorf("%s: %%inline requires a target and a production", d.pos)
+		}
+
+		match := false
+		for _, t := range strings.Split(d.args[0], ",") {
+			switch t {
+			case "bnf", "cfg", "lalrpop", "recursive-descent", "yacc":
+				match = match || t == target
+			default:
+				return nil, fmt.Errorf("%s: %%inline %s: unknown target %q, must be yacc, bnf, cfg, lalrpop or recursive-descent", d.pos, d.args[0], t)
+			}
+		}
+		for _, name := range d.args[1:] {
+			prod, ok := grm[name]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("%s: %%inline %s: undefined production", d.pos, name)
+			case !ast.IsExported(name):
+				return nil, fmt.Errorf("%s: %%inline %s: not a non terminal production", prod.Pos(), name)
+			case name == start:
+				return nil, fmt.Errorf("%s: %%inline %s: the start production", d.pos, name)
+			}
+
+			if match && !seen[name] {
+				seen[name] = true
+				a = append(a, name)
+			}
+		}
+	}
+	return
+}
+
+// fragmentTokens returns the set of lexical productions declared as
+// fragments by the directives in ds. A fragment must not be referred to by a
+// non terminal production, by itself or by %skip.
+func fragmentTokens(grm ebnfutil.Grammar, ds []*directive, skip map[string]bool) (m map[string]bool, err error) {
+	m = map[string]bool{}
+	for _, d := range ds {
+		if d.name != fragment {
+			continue
+		}
+
+		name := d.args[0]
+		prod, ok := grm[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s: fragment %s: undefined production", d.pos, name)
+		case ast.IsExported(name):
+			return nil, fmt.Errorf("%s: fragment %s: not a lexical production", prod.Pos(), name)
+		case skip[name]:
+			return nil, fmt.Errorf("%s: fragment %s: listed by %%skip", prod.Pos(), name)
+		}
+
+		m[name] = true
+	}
+
+	var names []string
+	for name := range grm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod := grm[name]
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok && m[x.String] && ast.IsExported(name) && err == nil {
+				err = fmt.Errorf("%s: fragment %s used by the non terminal production %s", x.Pos(), x.String, name)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if m[name] && refersTo(grm, prod.Expr, name) {
+			return nil, fmt.Errorf("%s: fragment %s refers to itself", prod.Pos(), name)
+		}
+	}
+	return
+}
+
+// checkStart returns an error if the start production is not defined in grm,
+// listing the non terminal productions, or all of them if there are none.
+func checkStart(grm ebnfutil.Grammar, start string) error {
+	if _, ok := grm[start]; ok {
+		return nil
+	}
+
+	var nts, all []string
+	for name := range grm {
+		all = append(all, name)
+		if ast.IsExported(name) {
+			nts = append(nts, name)
+		}
+	}
+	if len(nts) == 0 {
+		nts = all
+	}
+	sort.Strings(nts)
+	return fmt.Errorf("start production %q is not defined; available: %s", start, strings.Join(nts, ", "))
+}
+
+// checkNoSynthetic returns an error listing the groups, options and
+// repetitions of the non terminal productions of grm, each of which would be
+// lowered to a synthetic production.
+func checkNoSynthetic(grm ebnfutil.Grammar) error {
+	var list []violation
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		for _, v := range constructs(prod.Expr, nil) {
+			list = append(list, violation{v.Pos(), fmt.Sprintf("%s: production %s: %s requires a synthetic production", v.Pos(), name, termString(v))})
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+
+	sort.Stable(byOffset(list))
+	var a []string
+	for _, v := range list {
+		a = append(a, v.s)
+	}
+	return fmt.Errorf("-no-synthetic:\n%s", strings.Join(a, "\n"))
+}
+
+// refersTo reports whether expr refers to the production name, directly or
+// through other productions of grm.
+func refersTo(grm ebnfutil.Grammar, expr ebnf.Expression, name string) (y bool) {
+	walk(expr, func(expr ebnf.Expression) {
+		if x, ok := expr.(*ebnf.Name); ok && !y {
+			y = x.String == name || reachable(grm, x.String)[name]
+		}
+	})
+	return
+}
+
+// dropUnusedTokens removes from grm the lexical productions not reachable
+// from start. The removed productions not in skip are reported.
+func dropUnusedTokens(grm ebnfutil.Grammar, start string, skip map[string]bool) {
+	r := reachable(grm, start)
+	if !r[start] {
+		return
+	}
+
+	var a []string
+	for name := range grm {
+		if !r[name] && !ast.IsExported(name) {
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a)
+	for _, name := range a {
+		switch {
+		case skip[name]:
+			// nop
+		default:
+			warn(grm[name].Pos(), "token %q is declared but never used", name)
+		}
+		delete(grm, name)
+	}
+	for name := range skip {
+		if r[name] {
+			warn(grm[name].Pos(), "%%skip token %q is used by the grammar", name)
+		}
+	}
+}
+
+type byPos []*ebnf.Production
+
+func (a byPos) Len() int           { return len(a) }
+func (a byPos) Less(i, j int) bool { return a[i].Pos().Offset < a[j].Pos().Offset }
+func (a byPos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// dedupTokens reports the lexical productions of grm defining the same
+// literal as a lexical production declared before them. If fix is set, the
+// references to them, including those of the %prec symbols in prec and of
+// the precedence levels, are replaced by the first one and they are removed
+// from grm.
+func dedupTokens(grm ebnfutil.Grammar, prec map[int]string, levels []*level, fix bool) {
+	var a byPos
+	for name, prod := range grm {
+		if _, ok := prod.Expr.(*ebnf.Token); ok && !ast.IsExported(name) {
+			a = append(a, prod)
+		}
+	}
+	sort.Sort(a)
+	first := map[string]string{}
+	dup := map[string]string{}
+	for _, prod := range a {
+		name, lit := prod.Name.String, prod.Expr.(*ebnf.Token).String
+		canon, ok := first[lit]
+		if !ok {
+			first[lit] = name
+			continue
+		}
+
+		warn(prod.Pos(), "token %q defines the same literal %q as token %q", name, lit, canon)
+		dup[name] = canon
+	}
+	if !fix || len(dup) == 0 {
+		return
+	}
+
+	for name := range dup {
+		delete(grm, name)
+	}
+	for _, prod := range grm {
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok {
+				if canon, ok := dup[x.String]; ok {
+					x.String = canon
+				}
+			}
+		})
+	}
+	for off, s := range prec {
+		if canon, ok := dup[s]; ok {
+			prec[off] = canon
+		}
+	}
+	for _, l := range levels {
+		for i, s := range l.syms {
+			if canon, ok := dup[s]; ok {
+				l.syms[i] = canon
+			}
+		}
+	}
+}
+
+// corner is an edge of the left corner graph: the non terminal name can
+// start the production from which it is reached after the nullable prefix.
+type corner struct {
+	name   string
+	prefix []string
+}
+
+// checkLeftRecursion reports the left recursive non terminal productions of
+// grm. Productions starting with nullable non terminals are considered as
+// well, the nullable prefix hiding the recursion is included in the report.
+func checkLeftRecursion(grm ebnfutil.Grammar, a *analysis) {
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	corners := map[string][]corner{}
+	for _, name := range names {
+		seen := map[string]bool{}
+		a.leftCorners(grm[name].Expr, nil, func(s string, prefix []string) {
+			if !seen[s] {
+				seen[s] = true
+				corners[name] = append(corners[name], corner{s, prefix})
+			}
+		})
+	}
+
+	for _, name := range names {
+		// Breadth first search of the shortest path back to name.
+		from := map[string]string{}
+		via := map[string]corner{}
+		q := []string{name}
+		found := false
+		for len(q) != 0 && !found {
+			v := q[0]
+			q = q[1:]
+			for _, c := range corners[v] {
+				if c.name == name {
+					from[name], via[name] = v, c
+					found = true
+					break
+				}
+
+				if _, ok := from[c.name]; !ok {
+					from[c.name], via[c.name] = v, c
+					q = append(q, c.name)
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		var steps, prefix []string
+		for v := name; ; {
+			c := via[v]
+			steps = append([]string{fmt.Sprintf("%s → %s …", from[v], strings.Join(append(append([]string(nil), c.prefix...), c.name), " "))}, steps...)
+			prefix = append(append([]string(nil), c.prefix...), prefix...)
+			if v = from[v]; v == name {
+				break
+			}
+		}
+		path := strings.Join(steps, ", ")
+		switch {
+		case len(prefix) == 0:
+			warn(grm[name].Pos(), "production %s is left recursive: %s", name, path)
+		default:
+			warn(grm[name].Pos(), "production %s is left recursive through the nullable prefix %s: %s", name, strings.Join(prefix, " "), path)
+		}
+	}
+}
+
+// checkEpsilonRepetition reports the repetitions of nullable expressions in
+// the non terminal productions of grm. The parser can loop on such repetition
+// without consuming any input.
+func checkEpsilonRepetition(grm ebnfutil.Grammar, a *analysis) {
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		walk(grm[name].Expr, func(expr ebnf.Expression) {
+			x, ok := expr.(*ebnf.Repetition)
+			if !ok || !a.NullableExpr(x.Body) {
+				return
+			}
+
+			body := x.Body
+			for {
+				switch y := body.(type) {
+				case *ebnf.Group:
+					body = y.Body
+					continue
+				case ebnf.Sequence:
+					if len(y) == 1 {
+						body = y[0]
+						continue
+					}
+				case ebnf.Alternative:
+					if len(y) == 1 {
+						body = y[0]
+						continue
+					}
+				}
+				break
+			}
+			switch y := body.(type) {
+			case *ebnf.Name:
+				warn(x.Pos(), "production %s: repetition of the nullable production %s", name, y.String)
+			default:
+				warn(x.Pos(), "production %s: repetition of a nullable expression", name)
+			}
+		})
+	}
+}
+
+// checkDangling reports the options ending the non terminal productions of
+// grm which start with terminals which can also follow the production, like
+// the dangling else of IfStmt = "if" Expr Stmt [ "else" Stmt ] . when Stmt
+// can be an IfStmt.
+func checkDangling(grm ebnfutil.Grammar, a *analysis) {
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var f func(ebnf.Expression)
+		f = func(expr ebnf.Expression) {
+			switch x := expr.(type) {
+			case ebnf.Alternative:
+				for _, v := range x {
+					f(v)
+				}
+			case ebnf.Sequence:
+				// The last terms, followed by nullable ones only.
+				for i := len(x) - 1; i >= 0; i-- {
+					f(x[i])
+					if !a.NullableExpr(x[i]) {
+						break
+					}
+				}
+			case *ebnf.Group:
+				f(x.Body)
+			case *ebnf.Option:
+				sets, _ := a.FirstSets(x.Body, nil)
+				if b := a.Union(sets).And(a.FollowBits(name)); !b.Empty() {
+					s := strings.Join(a.Set(b).Sorted(), " ")
+					warn(x.Pos(), "production %s: dangling option on %s, which can also follow %s, like the dangling else: yacc shifts, binding it to the innermost %s; resolve the conflict by %%prec or rewrite the production", name, s, name, name)
+				}
+				f(x.Body)
+			}
+		}
+		f(grm[name].Expr)
+	}
+}
+
+// checkNesting reports the non terminal productions of grm nesting groups,
+// options and repetitions more than max levels deep, at the first construct
+// too deep.
+func checkNesting(grm ebnfutil.Grammar, max int) {
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var at ebnf.Expression
+		depth := 0
+		var f func(ebnf.Expression, int)
+		f = func(expr ebnf.Expression, level int) {
+			var body ebnf.Expression
+			switch x := expr.(type) {
+			case ebnf.Alternative:
+				for _, v := range x {
+					f(v, level)
+				}
+				return
+			case ebnf.Sequence:
+				for _, v := range x {
+					f(v, level)
+				}
+				return
+			case *ebnf.Group:
+				body = x.Body
+			case *ebnf.Option:
+				body = x.Body
+			case *ebnf.Repetition:
+				body = x.Body
+			default:
+				return
+			}
+
+			level++
+			if level > depth {
+				depth = level
+				if level == max+1 {
+					at = expr
+				}
+			}
+			f(body, level)
+		}
+		f(grm[name].Expr, 0)
+		if at != nil {
+			warn(at.Pos(), "production %s: groups, options and repetitions nested %d levels deep, more than %d", name, depth, max)
+		}
+	}
+}
+
+// checkSingleUse reports the non terminal productions of grm, other than
+// start, referred to exactly once, at the reference.
+func checkSingleUse(grm ebnfutil.Grammar, start string) {
+	once := map[string]bool{}
+	for _, v := range countRefs(grm) {
+		if v.Refs == 1 && v.Name != start && ast.IsExported(v.Name) {
+			once[v.Name] = true
+		}
+	}
+	var names []string
+	for name := range grm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		walk(grm[name].Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok && once[x.String] {
+				warn(x.Pos(), "production %s, defined at %s, is used only here, by %s: a candidate for inlining", x.String, grm[x.String].Pos(), name)
+			}
+		})
+	}
+}
+
+// terminalPrefixes returns the terminals which are proper prefixes of other
+// ones, mapped to the sorted descriptions of the longer ones. The terminals
+// are the literals of lits, quoted, and the names of toks, lexical
+// productions of a single literal, eg. lsh = "<<" ., mapped to it.
+func terminalPrefixes(lits []string, toks map[string]string) map[string][]string {
+	str := map[string]string{} // Terminal: literal.
+	desc := map[string]string{}
+	for _, lit := range lits {
+		q := strconv.Quote(lit)
+		str[q], desc[q] = lit, q
+	}
+	for name, lit := range toks {
+		str[name], desc[name] = lit, fmt.Sprintf("%s (%q)", name, lit)
+	}
+	m := map[string][]string{}
+	for short, s := range str {
+		for long, l := range str {
+			if len(l) > len(s) && strings.HasPrefix(l, s) {
+				m[short] = append(m[short], desc[long])
+			}
+		}
+	}
+	for _, v := range m {
+		sort.Strings(v)
+	}
+	return m
+}
+
+// checkTokenPrefixes reports the terminals used by the non terminal
+// productions of grm which are proper prefixes of other ones, at their first
+// use. The terminals are the literals and the lexical productions of a single
+// literal, toks, mapped to it.
+func checkTokenPrefixes(grm ebnfutil.Grammar, toks map[string]string) {
+	first := map[string]scanner.Position{}
+	use := func(t string, pos scanner.Position) {
+		if p, ok := first[t]; !ok || pos.Offset < p.Offset {
+			first[t] = pos
+		}
+	}
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			switch x := expr.(type) {
+			case *ebnf.Token:
+				use(strconv.Quote(x.String), x.Pos())
+			case *ebnf.Name:
+				if _, ok := toks[x.String]; ok {
+					use(x.String, x.Pos())
+				}
+			}
+		})
+	}
+	var terms, lits []string
+	used := map[string]string{}
+	for t := range first {
+		terms = append(terms, t)
+		switch lit, ok := toks[t]; {
+		case ok:
+			used[t] = lit
+		default:
+			s, _ := strconv.Unquote(t)
+			lits = append(lits, s)
+		}
+	}
+	sort.Strings(terms)
+	m := terminalPrefixes(lits, used)
+	for _, t := range terms {
+		a := m[t]
+		if len(a) == 0 {
+			continue
+		}
+
+		switch lit, ok := used[t]; {
+		case ok:
+			warn(first[t], "token %s (%q) is a prefix of %s: the lexer must prefer the longest match", t, lit, strings.Join(a, ", "))
+		default:
+			warn(first[t], "literal %s is a prefix of %s: the lexer must prefer the longest match", t, strings.Join(a, ", "))
+		}
+	}
+}
+
+// checkRHS reports the alternatives of the lowered non terminal productions
+// having more than max terms.
+func (j *job) checkRHS(max int) {
+	var names []string
+	for name := range j.grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod := j.grm[name]
+		alts, ok := prod.Expr.(ebnf.Alternative)
+		if !ok {
+			alts = ebnf.Alternative{prod.Expr}
+		}
+		for i, alt := range alts {
+			n := 1
+			switch x := alt.(type) {
+			case nil:
+				n = 0
+			case ebnf.Sequence:
+				n = len(x)
+			}
+			if n <= max {
+				continue
+			}
+
+			pos, in := prod.Pos(), ""
+			if alt != nil {
+				if p := alt.Pos(); p.IsValid() {
+					pos = p
+				}
+			}
+			if o, ok := j.origins[name]; ok {
+				if !pos.IsValid() {
+					pos = o.pos
+				}
+				in = fmt.Sprintf(" (from %s in %s)", o.kind, o.in)
+			}
+			warn(pos, "rule %s%s: alternative %d has %d terms, more than %d", name, in, i+1, n, max)
+		}
+	}
+}
+
+// nameWords returns the lower case words of the production name, split at
+// the underscores and the case changes like by snake.
+func nameWords(name string) (a []string) {
+	for _, v := range strings.Split(snake(name), "_") {
+		if v != "" {
+			a = append(a, v)
+		}
+	}
+	return
+}
+
+// checkCamelCase reports the names of the productions of grm not following
+// the naming convention the tool classifies them by: a non terminal name is
+// CamelCase, without underscores or consecutive upper case letters, a lexical
+// one is lower case without underscores.
+func checkCamelCase(grm ebnfutil.Grammar) {
+	var a byPos
+	for _, prod := range grm {
+		a = append(a, prod)
+	}
+	sort.Sort(a)
+	suggest := func(s string) string {
+		if has(grm, s) {
+			return s + ", a name already used"
+		}
+
+		return s
+	}
+	for _, prod := range a {
+		name := prod.Name.String
+		words := nameWords(name)
+		camel := ""
+		for _, v := range words {
+			camel += strings.ToUpper(v[:1]) + v[1:]
+		}
+		switch {
+		case ast.IsExported(name):
+			if camel != name {
+				warn(prod.Pos(), "production %s: not a CamelCase name, the canonical one is %s", name, suggest(camel))
+			}
+		case strings.Contains(name, "_"):
+			warn(prod.Pos(), "production %s: neither a lower case lexical nor a CamelCase name, %s for a non terminal, %s for a lexical one", name, suggest(camel), suggest(strings.Join(words, "")))
+		}
+	}
+}
+
+// checkMixedAssoc reports the operators of the operator chains, alternatives
+// X { op X } of the non terminal productions of grm, used by the chains of
+// more than one production. Each chain implies a precedence level, but the
+// precedence declarations can assign an operator only one. The operators are
+// the terminals of the repetition preceding its operand.
+func checkMixedAssoc(grm ebnfutil.Grammar) {
+	type use struct {
+		name string
+		pos  scanner.Position
+	}
+	var a byPos
+	for name, prod := range grm {
+		if ast.IsExported(name) {
+			a = append(a, prod)
+		}
+	}
+	sort.Sort(a)
+	m := map[string][]use{}
+	for _, prod := range a {
+		name := prod.Name.String
+		seen := map[string]bool{}
+		for _, alt := range alternatives(prod.Expr) {
+			x, ok := alt.(ebnf.Sequence)
+			if !ok || len(x) != 2 {
+				continue
+			}
+
+			r, ok := x[1].(*ebnf.Repetition)
+			if !ok {
+				continue
+			}
+
+			t := terms(r.Body)
+			if len(t) < 2 {
+				continue
+			}
+
+			lhs, ok1 := x[0].(*ebnf.Name)
+			rhs, ok2 := t[len(t)-1].(*ebnf.Name)
+			if !ok1 || !ok2 || lhs.String != rhs.String {
+				continue
+			}
+
+			for _, v := range t[:len(t)-1] {
+				walk(v, func(expr ebnf.Expression) {
+					var s string
+					switch y := expr.(type) {
+					case *ebnf.Token:
+						s = strconv.Quote(y.String)
+					case *ebnf.Name:
+						if ast.IsExported(y.String) {
+							return
+						}
+
+						s = y.String
+					default:
+						return
+					}
+					if !seen[s] {
+						seen[s] = true
+						m[s] = append(m[s], use{name, expr.Pos()})
+					}
+				})
+			}
+		}
+	}
+	var ops []string
+	for op := range m {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		uses := m[op]
+		for _, u := range uses[1:] {
+			warn(u.pos, "operator %s of the operator chain of %s is also one of %s, at %s: the precedence declarations can assign it only one level", op, u.name, uses[0].name, uses[0].pos)
+		}
+	}
+}
+
+// checkRightRecursion reports the productions of grm which are lists written
+// right recursive, P = x P | y . with x y = y z, eg. List = Item "," List |
+// Item . The yacc stack grows with the length of such a list, the equivalent
+// left recursive P = P z | y . or the repetition y { z } keep it constant,
+// the latter unless right is set, -recursion right lowering it right
+// recursive too. The other right recursion, eg. of the prefix operators, is
+// not reported.
+func checkRightRecursion(grm ebnfutil.Grammar, right bool) {
+	var a byPos
+	for name, prod := range grm {
+		if ast.IsExported(name) {
+			a = append(a, prod)
+		}
+	}
+	sort.Sort(a)
+	str := func(a []ebnf.Expression) string {
+		var s []string
+		for _, v := range a {
+			s = append(s, ebnfStr(v))
+		}
+		return strings.Join(s, " ")
+	}
+	for _, prod := range a {
+		name := prod.Name.String
+		alts := alternatives(prod.Expr)
+		if len(alts) != 2 {
+			continue
+		}
+
+		var x, y []ebnf.Expression
+		for _, v := range alts {
+			t := terms(v)
+			if n := len(t); n > 1 {
+				if last, ok := t[n-1].(*ebnf.Name); ok && last.String == name {
+					x = t[:n-1]
+					continue
+				}
+			}
+
+			y = t
+		}
+		if x == nil || len(y) == 0 {
+			continue
+		}
+
+		// x y = y z
+		xy := append(append([]ebnf.Expression(nil), x...), y...)
+		if str(xy[:len(y)]) != str(y) {
+			continue
+		}
+
+		refs := false
+		walk(sequence(xy), func(expr ebnf.Expression) {
+			if n, ok := expr.(*ebnf.Name); ok && n.String == name {
+				refs = true
+			}
+		})
+		if refs {
+			continue
+		}
+
+		z := str(xy[len(y):])
+		switch {
+		case right:
+			warn(prod.Pos(), "production %s is a right recursive list, the parser stack grows with its length: %s = %s %s | %s . keeps it constant", name, name, name, z, str(y))
+		default:
+			warn(prod.Pos(), "production %s is a right recursive list, the parser stack grows with its length: %s = %s %s | %s . or %s = %s { %s } . keeps it constant", name, name, name, z, str(y), name, str(y), z)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/cznic/ebnf2y/grammar"
+	"golang.org/x/exp/ebnf"
+)
+
+// checkLL1 reports the LL(1) conflicts of the non terminal productions: the
+// alternatives starting with the same terminals and the nullable constructs
+// starting with terminals which can also follow them. Left recursion is
+// reported by checkLeftRecursion.
+func (a *analysis) checkLL1() {
+	for _, v := range a.conflicts() {
+		warn(v.pos, "%s", v.s)
+	}
+}
+
+// conflicts returns the LL(1) conflicts of the non terminal productions, by
+// production name and then by position.
+func (a *analysis) conflicts() (r []violation) {
+	var names []string
+	for name := range a.grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var list []violation
+		a.ll1(a.grm[name].Expr, []grammar.Bits{a.FollowBits(name)}, func(at ebnf.Expression, b grammar.Bits, format string, args ...interface{}) {
+			pos := a.grm[name].Pos()
+			if at != nil {
+				pos = at.Pos()
+			}
+			s := fmt.Sprintf(format, args...)
+			if b != nil {
+				s += " on " + strings.Join(a.Set(b).Sorted(), " ")
+			}
+			list = append(list, violation{pos, fmt.Sprintf("production %s is not LL(1): %s", name, s)})
+		})
+		sort.Stable(byOffset(list))
+		r = append(r, list...)
+	}
+	return
+}
+
+type violation struct {
+	pos scanner.Position
+	s   string
+}
+
+type byOffset []violation
+
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Less(i, j int) bool { return a[i].pos.Offset < a[j].pos.Offset }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// ll1 checks expr, the union of follow being the terminals which can follow
+// it, and reports its conflicts.
+func (a *analysis) ll1(expr ebnf.Expression, follow []grammar.Bits, report func(ebnf.Expression, grammar.Bits, string, ...interface{})) {
+	switch x := expr.(type) {
+	case ebnf.Alternative:
+		first := make([]grammar.Bits, len(x))
+		nullable := make([]bool, len(x))
+		for i, v := range x {
+			var sets []grammar.Bits
+			sets, nullable[i] = a.FirstSets(v, nil)
+			first[i] = a.Union(sets)
+		}
+		f := a.Union(follow)
+		for i, v := range x {
+			for k := i + 1; k < len(x); k++ {
+				if b := first[i].And(first[k]); !b.Empty() {
+					report(x[k], b, "FIRST/FIRST conflict of alternatives %d and %d", i+1, k+1)
+				}
+				if nullable[i] && nullable[k] {
+					report(x[k], nil, "alternatives %d and %d are both nullable", i+1, k+1)
+				}
+			}
+			if nullable[i] {
+				for k := range x {
+					if b := first[k].And(f); k != i && !b.Empty() {
+						report(x[k], b, "FIRST/FOLLOW conflict of alternative %d with the nullable alternative %d", k+1, i+1)
+					}
+				}
+			}
+			a.ll1(v, follow, report)
+		}
+	case ebnf.Sequence:
+		for i := len(x) - 1; i >= 0; i-- {
+			a.ll1(x[i], follow, report)
+			t, nullable := a.FirstSets(x[i], nil)
+			if nullable {
+				t = append(t, follow...)
+			}
+			follow = t
+		}
+	case *ebnf.Group:
+		a.ll1(x.Body, follow, report)
+	case *ebnf.Option:
+		a.optional(x, x.Body, follow, report)
+		a.ll1(x.Body, follow, report)
+	case *ebnf.Repetition:
+		sets := a.optional(x, x.Body, follow, report)
+		a.ll1(x.Body, append(sets, follow...), report)
+	}
+}
+
+// optional checks expr, the option or repetition of body, and returns the
+// FIRST sets of body.
+func (a *analysis) optional(expr, body ebnf.Expression, follow []grammar.Bits, report func(ebnf.Expression, grammar.Bits, string, ...interface{})) []grammar.Bits {
+	sets, nullable := a.FirstSets(body, nil)
+	if b := a.Union(sets).And(a.Union(follow)); !b.Empty() {
+		report(expr, b, "FIRST/FOLLOW conflict of %s", termString(expr))
+	}
+	if nullable {
+		report(expr, nil, "%s of a nullable expression", termString(expr))
+	}
+	return sets
+}
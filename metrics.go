@@ -0,0 +1,92 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+)
+
+// metric is a gauge written by -metrics-prom.
+type metric struct {
+	name  string
+	help  string
+	kind  string // Of conflict, if not blank.
+	value int
+}
+
+// depth returns the length of the longest chain of references between the
+// non terminal productions of grm, the productions of a cycle counted once.
+func depth(grm ebnfutil.Grammar) (max int) {
+	nts := ebnfutil.Grammar{}
+	for name, prod := range grm {
+		if ast.IsExported(name) {
+			nts[name] = prod
+		}
+	}
+
+	// Every component follows the ones it refers to.
+	comp, d := map[string]int{}, map[int]int{}
+	for i, c := range toposort(nts) {
+		for _, name := range c.Productions {
+			comp[name] = i
+		}
+		for _, name := range c.Productions {
+			for _, ref := range references(nts, name) {
+				if j := comp[ref]; j != i && d[j] > d[i] {
+					d[i] = d[j]
+				}
+			}
+		}
+		if d[i]++; d[i] > max {
+			max = d[i]
+		}
+	}
+	return
+}
+
+// grammarMetrics returns the metrics of grm, the EBNF grammar.
+func grammarMetrics(grm ebnfutil.Grammar) []metric {
+	return []metric{
+		{"ebnf2y_productions", "Number of productions of the grammar.", "", len(grm)},
+		{"ebnf2y_recursion_depth", "Longest chain of references between non terminal productions, a cycle counted once.", "", depth(grm)},
+	}
+}
+
+// yaccMetrics returns the metrics of the emitted yacc rules, s being the
+// output of the parser generator run on them.
+func (j *job) yaccMetrics(s string) []metric {
+	return []metric{
+		{"ebnf2y_tokens", "Number of tokens of the yacc grammar, literals included.", "", len(j.rep.Tokens) + len(j.rep.Literals)},
+		{"ebnf2y_conflicts", "Number of yacc conflicts.", "shift/reduce", scoreN(s, strings.Split(s, " shift/reduce"))},
+		{"ebnf2y_conflicts", "Number of yacc conflicts.", "reduce/reduce", scoreN(s, strings.Split(s, " reduce/reduce"))},
+	}
+}
+
+// writeMetrics writes a to w in the Prometheus text exposition format, the
+// samples labelled by the grammar file name fn.
+func writeMetrics(w io.Writer, fn string, a []metric) error {
+	label := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, v := range a {
+		if i == 0 || a[i-1].name != v.name {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", v.name, v.help, v.name); err != nil {
+				return err
+			}
+		}
+
+		labels := `grammar="` + label.Replace(fn) + `"`
+		if v.kind != "" {
+			labels += `,kind="` + v.kind + `"`
+		}
+		if _, err := fmt.Fprintf(w, "%s{%s} %d\n", v.name, labels, v.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mutation is a minimal change of a sentence at the token index i.
+type mutation struct {
+	name string
+	min  int // Tokens of the sentences it applies to.
+	f    func(a []string, i int) []string
+}
+
+var mutations = []mutation{
+	{"drop", 1, func(a []string, i int) []string { return append(a[:i:i], a[i+1:]...) }},
+	{"dup", 1, func(a []string, i int) []string { return append(a[:i+1:i+1], a[i:]...) }},
+	{"swap", 2, func(a []string, i int) []string {
+		b := append([]string(nil), a...)
+		b[i], b[i+1] = b[i+1], b[i]
+		return b
+	}},
+}
+
+// writeMutants writes to the accept and reject subdirectories of dir n
+// sentences sampled by s from start, to accept, and their mutations, to the
+// subdirectory given by r recognizing them or not.
+func writeMutants(dir string, s *sampler, r *recognizer, start string, n int) error {
+	for _, v := range []string{"accept", "reject"} {
+		if err := os.MkdirAll(filepath.Join(dir, v), 0777); err != nil {
+			return err
+		}
+	}
+
+	write := func(sub, name string, a []string) error {
+		return ioutil.WriteFile(filepath.Join(dir, sub, name+".txt"), []byte(strings.Join(a, " ")+"\n"), 0666)
+	}
+
+	for i := 0; i < n; i++ {
+		text := s.sampleTokens(start)
+		kinds := s.kinds
+		name := fmt.Sprintf("%03d", i)
+		if err := write("accept", name, text); err != nil {
+			return err
+		}
+
+		for _, m := range mutations {
+			if len(text) < m.min {
+				continue
+			}
+
+			k := s.rnd.Intn(len(text) - m.min + 1)
+			mutant := name + "-" + m.name
+			sub := "reject"
+			switch ok := len(r.parses(start, m.f(kinds, k), 1)) != 0; {
+			case ok:
+				sub = "accept"
+			case r.truncated:
+				wlog.Printf("mutant %s: recursion depth limit %d reached, left out", mutant, r.max)
+				continue
+			}
+
+			if err := write(sub, mutant, m.f(text, k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// leadingBars returns the names of the productions of grm written with a |
+// before their first alternative.
+func leadingBars(grm ebnfutil.Grammar, ds []*directive) map[string]bool {
+	m := map[string]bool{}
+	for _, d := range ds {
+		if d.name != leadingBar {
+			continue
+		}
+
+		// The production defined last before the |.
+		name, off := "", -1
+		for n, prod := range grm {
+			if o := prod.Pos().Offset; o < d.pos.Offset && o > off {
+				name, off = n, o
+			}
+		}
+		if name != "" {
+			m[name] = true
+		}
+	}
+	return m
+}
+
+// ebnfStr returns the EBNF of expr.
+func ebnfStr(expr ebnf.Expression) string {
+	switch x := expr.(type) {
+	case nil:
+		return ""
+	case ebnf.Alternative:
+		a := []string{}
+		for _, v := range x {
+			a = append(a, ebnfStr(v))
+		}
+		return strings.Join(a, " | ")
+	case ebnf.Sequence:
+		a := []string{}
+		for _, v := range x {
+			a = append(a, ebnfStr(v))
+		}
+		return strings.Join(a, " ")
+	case *ebnf.Name:
+		return x.String
+	case *ebnf.Token:
+		return strconv.Quote(x.String)
+	case *ebnf.Range:
+		return ebnfStr(x.Begin) + " … " + ebnfStr(x.End)
+	case *ebnf.Group:
+		return "( " + ebnfStr(x.Body) + " )"
+	case *ebnf.Option:
+		return "[ " + ebnfStr(x.Body) + " ]"
+	case *ebnf.Repetition:
+		return "{ " + ebnfStr(x.Body) + " }"
+	default:
+		return "?"
+	}
+}
+
+// writeEBNF writes the pretty printed grm to w in the -oe-style style. With
+// preserve, the productions named by bars are written an alternative per
+// line, each led by |.
+func writeEBNF(w io.Writer, grm ebnfutil.Grammar, style string, bars map[string]bool) (err error) {
+	switch style {
+	case "normalize":
+		_, err = io.WriteString(w, grm.String())
+		return
+	case "preserve":
+		// ok
+	default:
+		return fmt.Errorf("-oe-style: unknown %q, must be normalize or preserve", style)
+	}
+
+	var names []string
+	for name := range grm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod := grm[name]
+		x, ok := prod.Expr.(ebnf.Alternative)
+		if !ok || !bars[name] {
+			if _, err = io.WriteString(w, ebnfutil.Grammar{name: prod}.String()); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if _, err = fmt.Fprintf(w, "%s =\n", name); err != nil {
+			return
+		}
+
+		for i, v := range x {
+			end := "\n"
+			if i == len(x)-1 {
+				end = " .\n"
+			}
+			if _, err = fmt.Fprintf(w, "\t| %s%s", ebnfStr(v), end); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cznic/ebnfutil"
+)
+
+// inlineScores returns the weighted yacc conflicts of the grammar g0 with
+// each of the named productions inlined in turn. The candidates are rendered
+// and run through the parser generator by n goroutines, each working in a
+// temporary directory of its own.
+func (j *job) inlineScores(g0 ebnfutil.Grammar, names []string, start string, n, wr, ws int) []int {
+	scores := make([]int, len(names))
+	if n > len(names) {
+		n = len(names)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		dir, err := ioutil.TempDir("", "ebnf2y-")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			fn := filepath.Join(dir, "candidate.y")
+			for k := range next {
+				g1 := g0.Normalize()
+				if err := g1.InlineOne(names[k], true); err != nil {
+					log.Fatal(err)
+				}
+
+				// render changes the names and the tokens of the job.
+				c := *j
+				c.grm = g1
+				c.names = map[string]bool{}
+				for name := range j.names {
+					c.names[name] = true
+				}
+				create(fn, func(w io.Writer) error {
+					c.checkTerminals(start)
+					return c.render(w, start)
+				})
+				scores[k] = score(j.compat, dir, fn, wr, ws)
+			}
+		}(dir)
+	}
+	for k := range names {
+		next <- k
+	}
+	close(next)
+	wg.Wait()
+	return scores
+}
@@ -0,0 +1,310 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// goPattern returns the Go regular expression of a lexical expression and
+// whether it has one. Lexical productions without an expression and the
+// recursive ones have none.
+func (j *job) goPattern(expr ebnf.Expression, seen map[string]bool) (string, bool) {
+	list := func(a []ebnf.Expression, sep string) (string, bool) {
+		var s []string
+		for _, v := range a {
+			x, ok := j.goPattern(v, seen)
+			if !ok {
+				return "", false
+			}
+
+			s = append(s, x)
+		}
+		return "(?:" + strings.Join(s, sep) + ")", true
+	}
+	switch x := expr.(type) {
+	case nil:
+		return "", false
+	case ebnf.Alternative:
+		return list(x, "|")
+	case ebnf.Sequence:
+		return list(x, "")
+	case *ebnf.Group:
+		return j.goPattern(x.Body, seen)
+	case *ebnf.Option:
+		s, ok := j.goPattern(x.Body, seen)
+		return "(?:" + s + ")?", ok
+	case *ebnf.Repetition:
+		s, ok := j.goPattern(x.Body, seen)
+		return "(?:" + s + ")*", ok
+	case *ebnf.Name:
+		prod, ok := j.lexical[x.String]
+		if !ok || seen[x.String] {
+			return "", false
+		}
+
+		seen[x.String] = true
+		s, ok := j.goPattern(prod.Expr, seen)
+		delete(seen, x.String)
+		return s, ok
+	case *ebnf.Token:
+		return regexp.QuoteMeta(x.String), true
+	case *ebnf.Range:
+		char := func(r rune) string {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return string(r)
+			}
+
+			return fmt.Sprintf(`\x{%x}`, r)
+		}
+		lo := []rune(x.Begin.String)
+		hi := []rune(x.End.String)
+		return "[" + char(lo[0]) + "-" + char(hi[0]) + "]", true
+	default:
+		return "", false
+	}
+}
+
+// playgroundRule writes the lexer rule of the named lexical production,
+// matching its Go regular expression, or calling a stub to be completed if it
+// has none. It returns the name of the stub, if any.
+func (j *job) playgroundRule(f strutil.Formatter, tok, name string) (stub string) {
+	if s, ok := j.goPattern(&ebnf.Name{String: name}, map[string]bool{}); ok {
+		f.Format("{%s, yyRegexp(%s)}, // %s\n", tok, strconv.Quote(s), name)
+		return ""
+	}
+
+	stub = "lex" + strings.Title(name)
+	f.Format("{%s, %s}, //%s stub, %s\n", tok, stub, todo, name)
+	return stub
+}
+
+// renderPlayground writes a self-contained Go program of the lowered grammar
+// for a quick test: a lexer matching the literals and the regular lexical
+// tokens, the LALR(1) tables of -table and a main printing the parse tree of
+// stdin. The tokens without a regular expression get stubs to be completed.
+// fn names the output in the reports.
+func (j *job) renderPlayground(w io.Writer, fn, start string) error {
+	l := j.newLALR(start)
+	action, sr, rr := l.tables(j.levels, j.precSym)
+	if sr+rr != 0 {
+		wlog.Printf("%s: conflicts: %d shift/reduce, %d reduce/reduce", fn, sr, rr)
+	}
+
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "Playground parser", "Run it by", "", " $ go run "+fn+" < input")
+	f.Format(`package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+`)
+	j.tableData(f, l, action)
+	f.Format("\n// yySkip match the input skipped between the tokens.\nvar yySkip = []func(string) int{%i\n")
+	switch {
+	case len(j.skip) == 0 && !j.keepWhitespace:
+		f.Format("yyRegexp(`[ \\t\\n\\r]+`),\n")
+	default:
+		var skip []string
+		for name := range j.skip {
+			skip = append(skip, name)
+		}
+		sort.Strings(skip)
+		for _, name := range skip {
+			if !j.playgroundSkip(f, name) {
+				wlog.Printf("%s: %%skip %s has no regular expression, complete its stub", fn, name)
+			}
+		}
+	}
+	f.Format("%u}\n\n")
+
+	// Literals first, they win over the tokens of the same length.
+	var stubs [][2]string
+	f.Format("// yyLexRules match the tokens, the longest match wins, the first one\n// of the same length.\nvar yyLexRules = []yyLexRule{%i\n")
+	var names []string
+	for _, k := range l.keys[1:] {
+		if _, err := strconv.Unquote(k); err != nil {
+			names = append(names, k)
+			continue
+		}
+
+		f.Format("{%s, yyLiteral(%s)},\n", j.rdToken(k), k)
+	}
+	for _, name := range names {
+		if stub := j.playgroundRule(f, j.rdToken(name), name); stub != "" {
+			stubs = append(stubs, [2]string{stub, name})
+		}
+	}
+	f.Format("%u}\n\n")
+	for _, v := range stubs {
+		wlog.Printf("%s: token %s has no regular expression, complete the stub %s", fn, v[1], v[0])
+		f.Format("// %s returns the length of the %s token at the start of s, 0 if none.\nfunc %[1]s(s string) int {%i\n//%[3]s the lexical production %[2]s has no regular expression.\nreturn 0%u\n}\n\n", v[0], v[1], todo)
+	}
+	f.Format("%s", playgroundDriver)
+	return sw.err
+}
+
+// playgroundSkip writes the matcher of the input skipped by the named %skip
+// production, a stub if it has no regular expression, and reports which.
+func (j *job) playgroundSkip(f strutil.Formatter, name string) bool {
+	if s, ok := j.goPattern(&ebnf.Name{String: name}, map[string]bool{}); ok {
+		f.Format("yyRegexp(%s), // %s\n", strconv.Quote(s), name)
+		return true
+	}
+
+	f.Format("func(string) int { return 0 }, //%s stub, %s\n", todo, name)
+	return false
+}
+
+// playgroundDriver is the lexer and the table driven parser of the
+// playground.
+const playgroundDriver = `type yyLexRule struct {
+	tok   int
+	match func(s string) int // Length of the token at the start of s, 0 if none.
+}
+
+func yyLiteral(lit string) func(string) int {
+	return func(s string) int {
+		if strings.HasPrefix(s, lit) {
+			return len(lit)
+		}
+
+		return 0
+	}
+}
+
+func yyRegexp(re string) func(string) int {
+	x := regexp.MustCompile("^(?:" + re + ")")
+	return func(s string) int {
+		return len(x.FindString(s))
+	}
+}
+
+// yyNode is a node of the parse tree, a terminal or a rule.
+type yyNode struct {
+	sym  int
+	text string // Of a terminal.
+	kids []*yyNode
+}
+
+// yyPos returns the line:column of the offset off of src.
+func yyPos(src string, off int) string {
+	line := 1 + strings.Count(src[:off], "\n")
+	return fmt.Sprintf("%d:%d", line, off-strings.LastIndex(src[:off], "\n"))
+}
+
+// yyLex returns the terminal, the text, the offset and the end of the token
+// following the offset off of src.
+func yyLex(src string, off int) (t int, text string, pos, end int, err error) {
+	for skipped := true; skipped; {
+		skipped = false
+		for _, f := range yySkip {
+			if n := f(src[off:]); n != 0 {
+				off += n
+				skipped = true
+			}
+		}
+	}
+	if off == len(src) {
+		return 0, "", off, off, nil
+	}
+
+	tok, n := 0, 0
+	for _, r := range yyLexRules {
+		if m := r.match(src[off:]); m > n {
+			tok, n = r.tok, m
+		}
+	}
+	if n == 0 {
+		return 0, "", off, off, fmt.Errorf("%s: invalid character %q", yyPos(src, off), src[off])
+	}
+
+	return yyTerminals[tok], src[off : off+n], off, off + n, nil
+}
+
+// yyFind returns the value of key in pairs, a list of key, value pairs.
+func yyFind(pairs []int, key int) (int, bool) {
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == key {
+			return pairs[i+1], true
+		}
+	}
+	return 0, false
+}
+
+// yyParse returns the parse tree of src.
+func yyParse(src string) (*yyNode, error) {
+	stack := []int{0}
+	var vals []*yyNode
+	t, text, pos, end, err := yyLex(src, 0)
+	for {
+		if err != nil {
+			return nil, err
+		}
+
+		a, ok := yyFind(yyAction[stack[len(stack)-1]], t)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s: syntax error, unexpected %s", yyPos(src, pos), yySymbols[t])
+		case a == 0:
+			return vals[len(vals)-1], nil
+		case a > 0:
+			stack = append(stack, a-1)
+			vals = append(vals, &yyNode{sym: t, text: text})
+			t, text, pos, end, err = yyLex(src, end)
+			continue
+		}
+
+		r := yyRules[-a-1]
+		n := &yyNode{sym: r[0], kids: append([]*yyNode(nil), vals[len(vals)-r[1]:]...)}
+		stack, vals = stack[:len(stack)-r[1]], vals[:len(vals)-r[1]]
+		next, _ := yyFind(yyGoto[stack[len(stack)-1]], r[0])
+		stack, vals = append(stack, next), append(vals, n)
+	}
+}
+
+// yyPrint writes the parse tree n to w, a node per line.
+func yyPrint(w io.Writer, n *yyNode, indent string) {
+	if n.sym < yyNTerminals {
+		fmt.Fprintf(w, "%s%s %q\n", indent, yySymbols[n.sym], n.text)
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s\n", indent, yySymbols[n.sym])
+	for _, v := range n.kids {
+		yyPrint(w, v, indent+"  ")
+	}
+}
+
+func main() {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := yyParse(string(b))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	yyPrint(os.Stdout, n, "")
+}
+`
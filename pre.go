@@ -0,0 +1,667 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// Number of arguments of the known directives, -1 means the rest of the line.
+var directives = map[string]int{
+	"ebnf2y-version": 1,
+	"endif":          0,
+	"error":          1,
+	"if":             1,
+	"inline":         -1,
+	"left":           -1,
+	"noinline":       -1,
+	"nonassoc":       -1,
+	"prec":           1,
+	"precedence":     -1,
+	"right":          -1,
+	"skip":           -1,
+	"state":          -1,
+	"states":         -1,
+}
+
+type directive struct {
+	pos  scanner.Position
+	name string
+	args []string
+}
+
+func isDirectiveChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// cond is an %if directive being processed.
+type cond struct {
+	pos scanner.Position
+	off int  // Source offset of the directive.
+	on  bool // The feature is selected and so are all the enclosing ones.
+}
+
+// fragment is the keyword marking a lexical production as a fragment. It is
+// recorded as a directive.
+const fragment = "fragment"
+
+// typeDecl is the name of the directive recording a name : type = ... .
+// declaration of the type of the values of a token.
+const typeDecl = "type"
+
+// assoc is the name of the directive recording the @left or @right
+// annotation of a repetition, at the position of its {.
+const assoc = "assoc"
+
+// leadingBar is the name of the directive recording the | written before
+// the first alternative of a production, at its position.
+const leadingBar = "leading-bar"
+
+// skipBlank returns the offset of the first byte of b at or after i which is
+// not white space or a part of a comment.
+func skipBlank(b []byte, i int) int {
+	for i < len(b) {
+		switch {
+		case b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r':
+			i++
+		case bytes.HasPrefix(b[i:], []byte("//")):
+			j := bytes.IndexByte(b[i:], '\n')
+			if j < 0 {
+				return len(b)
+			}
+
+			i += j
+		case bytes.HasPrefix(b[i:], []byte("/*")):
+			j := bytes.Index(b[i:], []byte("*/"))
+			if j < 0 {
+				return len(b)
+			}
+
+			i += j + 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isTypeChar(c byte) bool {
+	return c != '-' && isDirectiveChar(c) || c == '.' || c == '*' || c == '[' || c == ']'
+}
+
+// lineStart reports whether only blanks and tabs precede offset i in its line
+// of b.
+func lineStart(b []byte, i int) bool {
+	for i > 0 {
+		switch b[i-1] {
+		case '\n':
+			return true
+		case ' ', '\t':
+			i--
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// blank replaces everything in b except new lines by spaces.
+func blank(b []byte) {
+	for i, c := range b {
+		if c != '\n' {
+			b[i] = ' '
+		}
+	}
+}
+
+// preprocess returns a copy of src with all ebnf2y specific directives,
+// fragment keywords and repetition annotations replaced by white space, so
+// positions reported by the EBNF parser do not change, and the list of the
+// directives found. The %if blocks of the features not found in features are
+// blanked as well.
+func preprocess(fn string, src []byte, features map[string]bool) (b []byte, ds []*directive, err error) {
+	b = append([]byte(nil), src...)
+	var conds []cond
+	var braces []scanner.Position
+	line, lineOff := 1, 0
+	pos := func(off int) scanner.Position {
+		for i := lineOff; i < off; i++ {
+			if b[i] == '\n' {
+				line++
+				lineOff = i + 1
+			}
+		}
+		return scanner.Position{Filename: fn, Offset: off, Line: line, Column: utf8.RuneCount(b[lineOff:off]) + 1}
+	}
+
+	for i := 0; i < len(b); {
+		switch c := b[i]; {
+		case c == '"' || c == '`':
+			for i++; i < len(b) && b[i] != c && b[i] != '\n'; i++ {
+				if c == '"' && b[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '/' && bytes.HasPrefix(b[i:], []byte("//")):
+			if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+				i += j
+				break
+			}
+
+			i = len(b)
+		case c == '/' && bytes.HasPrefix(b[i:], []byte("/*")):
+			if j := bytes.Index(b[i:], []byte("*/")); j >= 0 {
+				i += j + 2
+				break
+			}
+
+			i = len(b)
+		case c == 'f' && bytes.HasPrefix(b[i:], []byte(fragment)) && lineStart(b, i):
+			j := i + len(fragment)
+			k := j
+			for k < len(b) && (b[k] == ' ' || b[k] == '\t') {
+				k++
+			}
+			l := k
+			for l < len(b) && b[l] != '-' && isDirectiveChar(b[l]) {
+				l++
+			}
+			if k == j || l == k {
+				// Not the keyword, eg. fragment = "x" . or fragments = ... .
+				i++
+				break
+			}
+
+			if len(conds) == 0 || conds[len(conds)-1].on {
+				ds = append(ds, &directive{pos(i), fragment, []string{string(b[k:l])}})
+			}
+			blank(b[i:j])
+			i = l
+		case c == ':':
+			k := i
+			for k > 0 && (b[k-1] == ' ' || b[k-1] == '\t') {
+				k--
+			}
+			l := k
+			for l > 0 && b[l-1] != '-' && isDirectiveChar(b[l-1]) {
+				l--
+			}
+			j := i + 1
+			for j < len(b) && (b[j] == ' ' || b[j] == '\t') {
+				j++
+			}
+			m := j
+			for m < len(b) && isTypeChar(b[m]) {
+				m++
+			}
+			n := m
+			for n < len(b) && (b[n] == ' ' || b[n] == '\t') {
+				n++
+			}
+			if l == k || m == j || n == len(b) || b[n] != '=' || !lineStart(b, l) {
+				// Not a name : type = ... . declaration.
+				i++
+				break
+			}
+
+			if len(conds) == 0 || conds[len(conds)-1].on {
+				ds = append(ds, &directive{pos(l), typeDecl, []string{string(b[l:k]), string(b[j:m])}})
+			}
+			blank(b[i:m])
+			i = m
+		case c == '=':
+			j := skipBlank(b, i+1)
+			if j == len(b) || b[j] != '|' {
+				i++
+				break
+			}
+
+			if len(conds) == 0 || conds[len(conds)-1].on {
+				ds = append(ds, &directive{pos(j), leadingBar, nil})
+			}
+			blank(b[j : j+1])
+			i = j + 1
+		case c == '{':
+			braces = append(braces, pos(i))
+			i++
+		case c == '}':
+			var lbrace scanner.Position
+			if n := len(braces); n != 0 {
+				lbrace, braces = braces[n-1], braces[:n-1]
+			}
+			j := i + 1
+			for j < len(b) && (b[j] == ' ' || b[j] == '\t') {
+				j++
+			}
+			if j == len(b) || b[j] != '@' || !lbrace.IsValid() {
+				i++
+				break
+			}
+
+			k := j + 1
+			for k < len(b) && b[k] >= 'a' && b[k] <= 'z' {
+				k++
+			}
+			switch s := string(b[j+1 : k]); s {
+			case "left", "right":
+				if len(conds) == 0 || conds[len(conds)-1].on {
+					ds = append(ds, &directive{lbrace, assoc, []string{s}})
+				}
+			default:
+				return nil, nil, fmt.Errorf("%s: unknown associativity @%s, must be @left or @right", pos(j), s)
+			}
+			blank(b[j:k])
+			i = k
+		case c == '%':
+			d := &directive{pos: pos(i)}
+			j := i + 1
+			for j < len(b) && isDirectiveChar(b[j]) {
+				j++
+			}
+			d.name = string(b[i+1 : j])
+			n, ok := directives[d.name]
+			off := len(conds) != 0 && !conds[len(conds)-1].on
+			switch {
+			case off && d.name != "if" && d.name != "endif":
+				i = j
+				continue
+			case !ok:
+				return nil, nil, fmt.Errorf("%s: unknown directive %%%s", d.pos, d.name)
+			}
+
+			for ; n != 0; n-- {
+				for j < len(b) && (b[j] == ' ' || b[j] == '\t') {
+					j++
+				}
+				if j == len(b) || b[j] == '\n' || b[j] == '\r' {
+					if n < 0 {
+						break
+					}
+
+					return nil, nil, fmt.Errorf("%s: missing argument of %%%s", d.pos, d.name)
+				}
+
+				k := j
+				switch q := b[j]; q {
+				case '"', '`':
+					for k++; k < len(b) && b[k] != q && b[k] != '\n'; k++ {
+						if q == '"' && b[k] == '\\' {
+							k++
+						}
+					}
+					if k >= len(b) || b[k] != q {
+						return nil, nil, fmt.Errorf("%s: unterminated literal in %%%s", d.pos, d.name)
+					}
+
+					k++
+				default:
+					for k < len(b) && b[k] > ' ' {
+						k++
+					}
+				}
+				d.args = append(d.args, string(b[j:k]))
+				j = k
+			}
+			switch d.name {
+			case "ebnf2y-version":
+				if err = checkVersion(d.args[0]); err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", d.pos, err)
+				}
+			case "if":
+				conds = append(conds, cond{d.pos, i, !off && features[d.args[0]]})
+			case "endif":
+				if len(conds) == 0 {
+					return nil, nil, fmt.Errorf("%s: %%endif without %%if", d.pos)
+				}
+
+				c := conds[len(conds)-1]
+				conds = conds[:len(conds)-1]
+				if !c.on && (len(conds) == 0 || conds[len(conds)-1].on) {
+					blank(b[c.off:j])
+				}
+			}
+
+			blank(b[i:j])
+			i = j
+			ds = append(ds, d)
+		default:
+			i++
+		}
+	}
+	if len(conds) != 0 {
+		return nil, nil, fmt.Errorf("%s: %%if without %%endif", conds[len(conds)-1].pos)
+	}
+
+	return
+}
+
+// informal is the name replacing an informal …. It has the same length so
+// positions do not change.
+const informal = "___"
+
+// markEllipses replaces by the name informal every … in src not forming a
+// range, ie. not between two literals.
+func markEllipses(src []byte) {
+	var s scanner.Scanner
+	s.Init(bytes.NewReader(src))
+	s.Error = func(*scanner.Scanner, string) {}
+	isLit := func(tok rune) bool { return tok == scanner.String || tok == scanner.RawString }
+	prev, off := rune(scanner.EOF), -1
+	for tok := s.Scan(); ; tok = s.Scan() {
+		if off >= 0 && !isLit(tok) {
+			copy(src[off:], informal)
+		}
+		off = -1
+		switch {
+		case tok == scanner.EOF:
+			return
+		case tok == '…' && isLit(prev):
+			off = s.Position.Offset
+		case tok == '…':
+			copy(src[s.Position.Offset:], informal)
+		}
+		prev = tok
+	}
+}
+
+func parseVersion(s string) (v []int, err error) {
+	for _, c := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(c, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+
+		v = append(v, int(n))
+	}
+	return
+}
+
+// checkVersion returns an error if the version of ebnf2y is lower than min.
+func checkVersion(min string) error {
+	want, err := parseVersion(min)
+	if err != nil {
+		return err
+	}
+
+	have, err := parseVersion(version)
+	if err != nil {
+		panic("internal error")
+	}
+
+	for i, n := range want {
+		var m int
+		if i < len(have) {
+			m = have[i]
+		}
+		switch {
+		case m > n:
+			return nil
+		case m < n:
+			return fmt.Errorf("the grammar requires ebnf2y version %s or later, this is version %s", min, version)
+		}
+	}
+	return nil
+}
+
+// exprOffset returns the offset of the first term of expr or -1 if expr is
+// empty.
+func exprOffset(expr ebnf.Expression) int {
+	if pos := exprPos(expr); pos.IsValid() {
+		return pos.Offset
+	}
+
+	return -1
+}
+
+// exprPos returns the position of the first term of expr, if any.
+func exprPos(expr ebnf.Expression) scanner.Position {
+	switch x := expr.(type) {
+	case nil:
+		return scanner.Position{}
+	case ebnf.Sequence:
+		if len(x) == 0 {
+			return scanner.Position{}
+		}
+
+		return exprPos(x[0])
+	case ebnf.Alternative:
+		if len(x) == 0 {
+			return scanner.Position{}
+		}
+
+		return exprPos(x[0])
+	default:
+		return x.Pos()
+	}
+}
+
+type mark struct {
+	off  int
+	prod bool
+}
+
+type marks []mark
+
+func (m marks) Len() int           { return len(m) }
+func (m marks) Less(i, j int) bool { return m[i].off < m[j].off }
+func (m marks) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// bindPrec associates the %prec directives in ds with the top level
+// alternatives of the non terminal productions in grm they follow. The
+// result is keyed by the offset of the alternatives' first term.
+func bindPrec(grm ebnfutil.Grammar, ds []*directive) (m map[int]string, err error) {
+	var a marks
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		a = append(a, mark{prod.Pos().Offset, true})
+		alts, ok := prod.Expr.(ebnf.Alternative)
+		if !ok {
+			alts = ebnf.Alternative{prod.Expr}
+		}
+		for _, alt := range alts {
+			if off := exprOffset(alt); off >= 0 {
+				a = append(a, mark{off, false})
+			}
+		}
+	}
+	sort.Sort(a)
+
+	m = map[int]string{}
+	for _, d := range ds {
+		if d.name != "prec" {
+			continue
+		}
+
+		i := sort.Search(len(a), func(i int) bool { return a[i].off >= d.pos.Offset }) - 1
+		if i < 0 || a[i].prod {
+			return nil, fmt.Errorf("%s: %%prec must follow an alternative of a non terminal production", d.pos)
+		}
+
+		off := a[i].off
+		if _, ok := m[off]; ok {
+			return nil, fmt.Errorf("%s: multiple %%prec directives for one alternative", d.pos)
+		}
+
+		m[off] = d.args[0]
+	}
+	return
+}
+
+// bindAssoc returns the associativity of the repetitions annotated by @left
+// or @right, keyed by their offsets. The repetition must be the last of the
+// two terms of an alternative of a non terminal production and repeat
+// operators followed by an operand. The terminals of the operators are
+// appended to the arguments of the directive for precLevels.
+func bindAssoc(grm ebnfutil.Grammar, ds []*directive) (m map[int]string, err error) {
+	reps := map[int]*ebnf.Repetition{}
+	last := map[int]bool{}
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			continue
+		}
+
+		for _, alt := range alternatives(prod.Expr) {
+			walk(alt, func(expr ebnf.Expression) {
+				if x, ok := expr.(*ebnf.Repetition); ok {
+					reps[x.Pos().Offset] = x
+				}
+			})
+			if x, ok := alt.(ebnf.Sequence); ok && len(x) == 2 {
+				if r, ok := x[1].(*ebnf.Repetition); ok {
+					last[r.Pos().Offset] = true
+				}
+			}
+		}
+	}
+
+	m = map[int]string{}
+	for _, d := range ds {
+		if d.name != assoc {
+			continue
+		}
+
+		off, kind := d.pos.Offset, d.args[0]
+		rep, ok := reps[off]
+		if !ok {
+			return nil, fmt.Errorf("%s: @%s must annotate a repetition of a non terminal production", d.pos, kind)
+		}
+
+		if !last[off] {
+			return nil, fmt.Errorf("%s: @%s: the repetition must follow the only other term of an alternative", d.pos, kind)
+		}
+
+		a := terms(rep.Body)
+		if len(a) < 2 {
+			return nil, fmt.Errorf("%s: @%s: the repetition must be of operators followed by an operand, eg. { ( \"+\" | \"-\" ) Term }", d.pos, kind)
+		}
+
+		seen := map[string]bool{}
+		for _, v := range a[:len(a)-1] {
+			walk(v, func(expr ebnf.Expression) {
+				var s string
+				switch x := expr.(type) {
+				case *ebnf.Token:
+					s = strconv.Quote(x.String)
+				case *ebnf.Name:
+					if ast.IsExported(x.String) {
+						return
+					}
+
+					s = x.String
+				default:
+					return
+				}
+				if !seen[s] {
+					seen[s] = true
+					d.args = append(d.args, s)
+				}
+			})
+		}
+		m[off] = kind
+	}
+	return
+}
+
+// level is a precedence level declared by a %left, %right, %nonassoc or
+// %precedence directive.
+type level struct {
+	kind string
+	syms []string
+}
+
+// precLevels returns the precedence levels declared in ds, in increasing
+// precedence.
+func precLevels(ds []*directive) (a []*level, err error) {
+	seen := map[string]bool{}
+	for _, d := range ds {
+		switch d.name {
+		case "left", "right", "nonassoc", "precedence":
+		case assoc:
+			// The operators appended by bindAssoc.
+			lev := &level{d.args[0], d.args[1:]}
+			for _, s := range lev.syms {
+				if seen[s] {
+					return nil, fmt.Errorf("%s: @%s: %s already has a precedence", d.pos, lev.kind, s)
+				}
+
+				seen[s] = true
+			}
+			if len(lev.syms) != 0 {
+				a = append(a, lev)
+			}
+			continue
+		default:
+			continue
+		}
+
+		if len(d.args) == 0 {
+			return nil, fmt.Errorf("%s: missing argument of %%%s", d.pos, d.name)
+		}
+
+		for _, s := range d.args {
+			if seen[s] {
+				return nil, fmt.Errorf("%s: %%%s: %s already has a precedence", d.pos, d.name, s)
+			}
+
+			seen[s] = true
+		}
+		a = append(a, &level{d.name, d.args})
+	}
+	return
+}
+
+// preceding returns the production of grm closest before off or nil if there
+// is none.
+func preceding(grm ebnfutil.Grammar, off int) (prod *ebnf.Production) {
+	for _, v := range grm {
+		if o := v.Pos().Offset; o < off && (prod == nil || o > prod.Pos().Offset) {
+			prod = v
+		}
+	}
+	return
+}
+
+// bindErrors returns the messages of the %error directives in ds keyed by
+// the non terminal productions of grm they follow.
+func bindErrors(grm ebnfutil.Grammar, ds []*directive) (m map[string]string, err error) {
+	m = map[string]string{}
+	for _, d := range ds {
+		if d.name != "error" {
+			continue
+		}
+
+		prod := preceding(grm, d.pos.Offset)
+		if prod == nil || !ast.IsExported(prod.Name.String) {
+			return nil, fmt.Errorf("%s: %%error must follow a non terminal production", d.pos)
+		}
+
+		name := prod.Name.String
+		if _, ok := m[name]; ok {
+			return nil, fmt.Errorf("%s: multiple %%error directives for %s", d.pos, name)
+		}
+
+		s, err := strconv.Unquote(d.args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %%error: the message must be a literal", d.pos)
+		}
+
+		m[name] = s
+	}
+	return
+}
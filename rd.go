@@ -0,0 +1,354 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/ebnf2y/grammar"
+	"github.com/cznic/ebnfutil"
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// rdTails returns the items appended to the named repetition by each of its
+// iterations, the lowered alternatives of R = | R items... or R = | items... R.
+func (j *job) rdTails(name string) (a []ebnf.Sequence) {
+	for _, v := range alternatives(j.grm[name].Expr)[1:] {
+		x, ok := v.(ebnf.Sequence)
+		if !ok || len(x) < 2 {
+			log.Fatalf("internal error: repetition %s: %s", name, ebnfStr(v))
+		}
+
+		a = append(a, j.iteration(x))
+	}
+	return
+}
+
+// rdAnalysis returns the analysis of the lowered grammar with the synthetic
+// productions of the repetitions turned back into loops, as parsed by the
+// recursive descent parser. It terminates the program if the grammar is not
+// LL(1).
+func (j *job) rdAnalysis(start string) *analysis {
+	view := ebnfutil.Grammar{}
+	for name, prod := range j.grm {
+		if !j.repetitions[name] {
+			view[name] = prod
+			continue
+		}
+
+		var body ebnf.Alternative
+		for _, v := range j.rdTails(name) {
+			body = append(body, v)
+		}
+		var b ebnf.Expression = body
+		if len(body) == 1 {
+			b = body[0]
+		}
+		view[name] = &ebnf.Production{Name: prod.Name, Expr: &ebnf.Repetition{Lbrace: prod.Pos(), Body: b}}
+	}
+
+	a := newAnalysis(view, start)
+	list := a.conflicts()
+	var names []string
+	for name := range view {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Depth first search of a left corner path back to name.
+		seen := map[string]bool{}
+		var f func(string) bool
+		f = func(s string) (found bool) {
+			a.leftCorners(view[s].Expr, nil, func(c string, _ []string) {
+				if found || c == name {
+					found = true
+					return
+				}
+
+				if !seen[c] {
+					seen[c] = true
+					found = f(c)
+				}
+			})
+			return
+		}
+		if f(name) {
+			list = append(list, violation{view[name].Pos(), "production " + name + " is left recursive"})
+		}
+	}
+	if len(list) != 0 {
+		for _, v := range list {
+			wlog.Printf("%s: %s", v.pos, v.s)
+		}
+		log.Fatalf("'-target recursive-descent': the grammar is not LL(1), %d conflict(s)", len(list))
+	}
+	return a
+}
+
+// rdToken returns the Go constant of the terminal t, a lexical token or a
+// quoted literal.
+func (j *job) rdToken(t string) string {
+	lit, err := strconv.Unquote(t)
+	switch {
+	case err != nil:
+		return j.term2name[t]
+	case j.inlineLiteral(lit):
+		return strconv.QuoteRune(rune(lit[0]))
+	default:
+		return j.term2name[lit]
+	}
+}
+
+// rdCase returns the case list of the terminals of b.
+func (j *job) rdCase(a *analysis, b grammar.Bits) string {
+	var s []string
+	for _, t := range a.Set(b).Sorted() {
+		s = append(s, j.rdToken(t))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+// rdItems writes the parsing of the items of a rule, the first one being $n,
+// and returns their values, like ystr.
+func (j *job) rdItems(f strutil.Formatter, items []ebnf.Expression, n int) (vals []string) {
+	for i, v := range items {
+		x := "x" + strconv.Itoa(n+i)
+		switch y := v.(type) {
+		case *ebnf.Name:
+			if ast.IsExported(y.String) {
+				f.Format("%s := p.parse%s()\n", x, j.ruleName(y.String))
+				vals = append(vals, x)
+				break
+			}
+
+			field := "item"
+			if typ, ok := j.tokenTypes[y.String]; ok {
+				field = unionField(typ)
+			}
+			f.Format("%s := p.expect(%s)\n", x, j.term2name[y.String])
+			vals = append(vals, x+"."+field)
+		case *ebnf.Token:
+			f.Format("p.expect(%s)\n", j.rdToken(strconv.Quote(y.String)))
+			vals = append(vals, strconv.Quote(y.String))
+		default:
+			log.Fatalf("%T(%#v)", y, y)
+		}
+	}
+	return
+}
+
+// rdAlternative writes the parsing of expr, an alternative of the named
+// production, returning its value.
+func (j *job) rdAlternative(f strutil.Formatter, expr ebnf.Expression, name, start string) {
+	vals := j.rdItems(f, terms(expr), 1)
+	if action := j.foldAction(expr, name, start); action != "" {
+		r := strings.NewReplacer("$1", "x1", "$2", "x2")
+		a := strings.Split(action, "\n\t\t")
+		for _, v := range a[:len(a)-1] {
+			f.Format("%s\n", r.Replace(v))
+		}
+		f.Format("return x\n")
+		return
+	}
+
+	switch {
+	case j.altRule(name):
+		f.Format("return []%s{%s}\n", j.ruleName(name), strings.Join(vals, ", "))
+	case len(vals) == 0:
+		f.Format("return nil\n")
+	case len(vals) == 1:
+		f.Format("return %s\n", vals[0])
+	default:
+		f.Format("return []%s{%s}\n", j.ruleName(name), strings.Join(vals, ", "))
+	}
+}
+
+// rdProduction writes the parse function of the named production.
+func (j *job) rdProduction(f strutil.Formatter, a *analysis, name, start string) {
+	f.Format("func (p *yyParser) parse%s() interface{} {%i\n", j.ruleName(name))
+	if j.repetitions[name] {
+		f.Format("l := %s(nil)\nfor {%i\nswitch p.tok {\n", j.nodeType(name))
+		for _, v := range j.rdTails(name) {
+			sets, _ := a.FirstSets(v, nil)
+			f.Format("case %s:%i\n", j.rdCase(a, a.Union(sets)))
+			vals := j.rdItems(f, v, 2)
+			f.Format("l = append(l, %s)%u\n", strings.Join(vals, ", "))
+		}
+		f.Format("default:%i\nreturn l%u\n}%u\n}%u\n}\n\n")
+		return
+	}
+
+	alts := alternatives(j.grm[name].Expr)
+	if len(alts) == 1 {
+		j.rdAlternative(f, alts[0], name, start)
+		f.Format("%u}\n\n")
+		return
+	}
+
+	f.Format("switch p.tok {\n")
+	nullable := -1
+	for i, v := range alts {
+		sets, ok := a.FirstSets(v, nil)
+		if ok {
+			// The default, LL(1) leaves it only one.
+			nullable = i
+			continue
+		}
+
+		f.Format("case %s:%i\n", j.rdCase(a, a.Union(sets)))
+		j.rdAlternative(f, v, name, start)
+		f.Format("%u")
+	}
+	f.Format("default:%i\n")
+	switch {
+	case nullable >= 0:
+		j.rdAlternative(f, alts[nullable], name, start)
+	default:
+		f.Format("panic(p.syntaxError())\n")
+	}
+	f.Format("%u}\n%u}\n\n")
+}
+
+// tokenConsts writes the Go constants of the tokens of the lexer, numbered
+// like by goyacc.
+func (j *job) tokenConsts(f strutil.Formatter) {
+	m := map[string]bool{}
+	for _, name := range j.term2name {
+		m[name] = true
+	}
+	var toks []string
+	for name := range m {
+		toks = append(toks, name)
+	}
+	sort.Strings(toks)
+	f.Format("// yyEOF is the token returned by the lexer at the end of input.\nconst yyEOF = 0\n\n")
+	if len(toks) != 0 {
+		f.Format("const (%i\n%s = iota + 57346\n", toks[0])
+		for _, name := range toks[1:] {
+			f.Format("%s\n", name)
+		}
+		f.Format("%u)\n\n")
+	}
+}
+
+// renderRD writes a recursive descent parser of the lowered grammar, a parse
+// function per production choosing the alternatives by their FIRST sets. The
+// parser reads the tokens of a yyLexer and builds the values of the yacc
+// rules, like yyParse of the yacc target.
+func (j *job) renderRD(w io.Writer, start, top string) error {
+	names := j.bnfOrder(start, top)
+	a := j.rdAnalysis(start)
+	j.tokens(strutil.IndentFormatter(ioutil.Discard, "\t"))
+
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "Recursive descent parser")
+	f.Format("package %s //%s real package name\n\n", j.pkg, todo)
+	if j.lexIface != nil {
+		j.lexIface.render(f, j.symType)
+	}
+
+	j.tokenConsts(f)
+	f.Format("type %s struct {\n\titem interface{} //%s insert real field(s)\n", j.symType, todo)
+	j.unionFields(f)
+	f.Format("}\n\n")
+	f.Format(`type yyLexer interface {
+	Lex(lval *%s) int
+	Error(s string)
+}
+
+`, j.symType)
+
+	switch j.result {
+	case "":
+		f.Format("var _parserResult interface{}\n\n")
+	default:
+		f.Format("// %s is the value of the start production set by yyParse.\nvar %[1]s interface{}\n\n", j.result)
+	}
+
+	var nts []string
+	for name := range j.rep.NonTerminals {
+		nts = append(nts, name)
+	}
+	sort.Strings(nts)
+	f.Format("type (%i\n")
+	for _, name := range nts {
+		j.doc(f, name)
+		f.Format("%s interface{}\n", j.ruleName(name))
+	}
+	f.Format("%u)\n\n")
+
+	f.Format(`// yySyntaxError unwinds the parser on a syntax error.
+type yySyntaxError struct{}
+
+type yyParser struct {
+	lex  yyLexer
+	lval %s // Of tok.
+	tok  int
+}
+
+// yyParse parses the tokens of lex and returns 0 on success, 1 on a syntax
+// error, reported by lex.Error. It sets %s to the value of the input.
+func yyParse(lex yyLexer) (r int) {
+	p := &yyParser{lex: lex}
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(yySyntaxError); !ok {
+				panic(e)
+			}
+
+			r = 1
+		}
+	}()
+
+	p.next()
+	x := p.parse%s()
+	if p.tok != yyEOF {
+		panic(p.syntaxError())
+	}
+
+	%s = x
+	return 0
+}
+
+func (p *yyParser) next() {
+	if p.tok = p.lex.Lex(&p.lval); p.tok < 0 {
+		p.tok = yyEOF
+	}
+}
+
+func (p *yyParser) syntaxError() yySyntaxError {
+	p.lex.Error("syntax error")
+	return yySyntaxError{}
+}
+
+// expect consumes the lookahead token tok and returns its value.
+func (p *yyParser) expect(tok int) %[1]s {
+	if p.tok != tok {
+		panic(p.syntaxError())
+	}
+
+	v := p.lval
+	p.next()
+	return v
+}
+
+`, j.symType, j.resultVar(), j.ruleName(start), j.resultVar())
+	j.rdProduction(f, a, start, start)
+	for _, name := range names {
+		j.rdProduction(f, a, name, start)
+	}
+	return sw.err
+}
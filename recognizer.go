@@ -0,0 +1,216 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// maxParses is the number of parses of an ambiguous input written by -accept.
+const maxParses = 10
+
+// recognizer is a backtracking recognizer of the token sequences derived from
+// the non terminal productions of a grammar. A token is a literal or the name
+// of a lexical production.
+type recognizer struct {
+	active    map[recursion]int
+	depth     int // Of the non terminal expansions in progress.
+	grm       ebnfutil.Grammar
+	max       int // Bound of depth.
+	toks      []string
+	truncated bool // Depth exceeded max.
+}
+
+// recursion is an expansion of a non terminal at a token position.
+type recursion struct {
+	name string
+	pos  int
+}
+
+// parseNode is a node of a parse tree, a non terminal with its children or a
+// token.
+type parseNode struct {
+	name    string
+	kids    []*parseNode
+	literal bool
+}
+
+func newRecognizer(grm ebnfutil.Grammar, max int) *recognizer {
+	return &recognizer{grm: grm, max: max}
+}
+
+// tokens returns the words of an input, unquoting the Go string literals, eg.
+// "+" for +.
+func tokens(words []string) []string {
+	a := make([]string, len(words))
+	for i, v := range words {
+		if s, err := strconv.Unquote(v); err == nil {
+			v = s
+		}
+		a[i] = v
+	}
+	return a
+}
+
+// parses returns up to n parse trees of toks derived from the named
+// production.
+func (r *recognizer) parses(name string, toks []string, n int) (a []*parseNode) {
+	r.toks = toks
+	r.active = map[recursion]int{}
+	r.depth = 0
+	r.truncated = false
+	r.match(&ebnf.Name{String: name}, 0, nil, func(pos int, kids []*parseNode) bool {
+		if pos != len(toks) {
+			return false
+		}
+
+		a = append(a, kids[0])
+		return len(a) == n
+	})
+	return
+}
+
+// appendNode returns kids with n appended, leaving kids alone.
+func appendNode(kids []*parseNode, n *parseNode) []*parseNode {
+	return append(kids[:len(kids):len(kids)], n)
+}
+
+// match matches expr at the token position pos and calls k with the position
+// following every match found and kids with the nodes of the match appended,
+// until k returns true.
+func (r *recognizer) match(expr ebnf.Expression, pos int, kids []*parseNode, k func(int, []*parseNode) bool) bool {
+	switch x := expr.(type) {
+	case nil:
+		return k(pos, kids)
+	case *ebnf.Name:
+		name := x.String
+		if !ast.IsExported(name) {
+			return r.token(pos, name) && k(pos+1, appendNode(kids, &parseNode{name: name}))
+		}
+
+		prod, ok := r.grm[name]
+		if !ok {
+			return false
+		}
+
+		// Every nested expansion of a left recursive production consumes
+		// a token at least, bounding the recursion.
+		key := recursion{name, pos}
+		if r.active[key] > len(r.toks)-pos {
+			return false
+		}
+
+		if r.depth == r.max {
+			r.truncated = true
+			return false
+		}
+
+		r.active[key]++
+		r.depth++
+		ok = r.match(prod.Expr, pos, nil, func(p int, sub []*parseNode) bool {
+			return k(p, appendNode(kids, &parseNode{name: name, kids: sub}))
+		})
+		r.depth--
+		r.active[key]--
+		return ok
+	case *ebnf.Token:
+		return r.token(pos, x.String) && k(pos+1, appendNode(kids, &parseNode{name: x.String, literal: true}))
+	case *ebnf.Range:
+		if pos == len(r.toks) || utf8.RuneCountInString(r.toks[pos]) != 1 {
+			return false
+		}
+
+		c, _ := utf8.DecodeRuneInString(r.toks[pos])
+		b, _ := utf8.DecodeRuneInString(x.Begin.String)
+		e, _ := utf8.DecodeRuneInString(x.End.String)
+		return c >= b && c <= e && k(pos+1, appendNode(kids, &parseNode{name: r.toks[pos], literal: true}))
+	case ebnf.Sequence:
+		return r.sequence(x, pos, kids, k)
+	case ebnf.Alternative:
+		for _, v := range x {
+			if r.match(v, pos, kids, k) {
+				return true
+			}
+		}
+		return false
+	case *ebnf.Group:
+		return r.match(x.Body, pos, kids, k)
+	case *ebnf.Option:
+		return r.match(x.Body, pos, kids, k) || k(pos, kids)
+	case *ebnf.Repetition:
+		return r.repetition(x.Body, pos, kids, k)
+	default:
+		return false
+	}
+}
+
+func (r *recognizer) token(pos int, s string) bool {
+	return pos < len(r.toks) && r.toks[pos] == s
+}
+
+func (r *recognizer) sequence(x ebnf.Sequence, pos int, kids []*parseNode, k func(int, []*parseNode) bool) bool {
+	if len(x) == 0 {
+		return k(pos, kids)
+	}
+
+	return r.match(x[0], pos, kids, func(p int, kids []*parseNode) bool { return r.sequence(x[1:], p, kids, k) })
+}
+
+// repetition matches the repetitions of body consuming a token at least each.
+func (r *recognizer) repetition(body ebnf.Expression, pos int, kids []*parseNode, k func(int, []*parseNode) bool) bool {
+	return r.match(body, pos, kids, func(p int, kids []*parseNode) bool {
+		return p > pos && r.repetition(body, p, kids, k)
+	}) || k(pos, kids)
+}
+
+// write writes the tree rooted at n, a node per line, indented by its level.
+func (n *parseNode) write(w io.Writer, level int) {
+	s := n.name
+	if n.literal {
+		s = strconv.Quote(s)
+	}
+	fmt.Fprintf(w, "%s%s\n", strings.Repeat("\t", level), s)
+	for _, v := range n.kids {
+		v.write(w, level+1)
+	}
+}
+
+// accept writes yes and the parse trees of toks derived from the named
+// production, or no, and reports whether toks is accepted.
+func (r *recognizer) accept(w io.Writer, name string, toks []string) bool {
+	a := r.parses(name, toks, maxParses+1)
+	if r.truncated {
+		fmt.Fprintf(w, "recursion depth limit %d reached, the answer may be incomplete\n", r.max)
+	}
+	if len(a) == 0 {
+		fmt.Fprintln(w, "no")
+		return false
+	}
+
+	switch n := len(a); {
+	case n == 1:
+		fmt.Fprintln(w, "yes")
+		a[0].write(w, 0)
+		return true
+	case n > maxParses:
+		fmt.Fprintf(w, "yes, ambiguous, more than %d parses\n", maxParses)
+		a = a[:maxParses]
+	default:
+		fmt.Fprintf(w, "yes, ambiguous, %d parses\n", n)
+	}
+	for i, v := range a {
+		fmt.Fprintf(w, "# parse %d\n", i+1)
+		v.write(w, 0)
+	}
+	return true
+}
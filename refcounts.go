@@ -0,0 +1,83 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+type refcount struct {
+	Name string `json:"name"`
+	Refs int    `json:"refs"`
+}
+
+type refcounts []refcount
+
+func (r refcounts) Len() int { return len(r) }
+
+func (r refcounts) Less(i, j int) bool {
+	if r[i].Refs != r[j].Refs {
+		return r[i].Refs > r[j].Refs
+	}
+
+	return r[i].Name < r[j].Name
+}
+
+func (r refcounts) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+
+// countRefs returns the productions of grm with the number of times they are
+// referred to by all the productions, sorted by decreasing count.
+func countRefs(grm ebnfutil.Grammar) (r refcounts) {
+	m := map[string]int{}
+	for name := range grm {
+		m[name] = 0
+	}
+	for _, prod := range grm {
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Name); ok {
+				if _, ok := m[x.String]; ok {
+					m[x.String]++
+				}
+			}
+		})
+	}
+	for name, n := range m {
+		r = append(r, refcount{name, n})
+	}
+	sort.Sort(r)
+	return
+}
+
+// writeRefcounts writes r to w in the format selected by -refcounts.
+func writeRefcounts(w io.Writer, r refcounts, format string) (err error) {
+	switch format {
+	case "text":
+		for _, v := range r {
+			if _, err = fmt.Fprintf(w, "%d\t%s\n", v.Refs, v.Name); err != nil {
+				return
+			}
+		}
+		return
+	case "json":
+		if r == nil {
+			r = refcounts{}
+		}
+		b, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	default:
+		return fmt.Errorf("-refcounts: unknown format %q, must be text or json", format)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"log"
+	"sort"
+	"unicode"
+)
+
+// snake returns s, a CamelCase name, in snake_case, eg. "XMLExpression1" ->
+// "xml_expression1".
+func snake(s string) string {
+	r := []rune(s)
+	var a []rune
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 {
+			prev := r[i-1]
+			next := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && next {
+				a = append(a, '_')
+			}
+		}
+		a = append(a, unicode.ToLower(c))
+	}
+	return string(a)
+}
+
+// camel returns s, a CamelCase name, in lowerCamelCase, eg. "XMLExpression1"
+// -> "xmlExpression1".
+func camel(s string) string {
+	r := []rune(s)
+	for i, c := range r {
+		if !unicode.IsUpper(c) || i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+
+		r[i] = unicode.ToLower(c)
+	}
+	return string(r)
+}
+
+// renameRules sets the names under which the non terminal productions are
+// written to the output. Mode is one of "keep", "snake" or "camel".
+func (j *job) renameRules(mode string) (err error) {
+	var f func(string) string
+	switch mode {
+	case "keep":
+		return
+	case "snake":
+		f = snake
+	case "camel":
+		f = camel
+	default:
+		return fmt.Errorf("-rename-rules: unknown mode %q, must be keep, snake or camel", mode)
+	}
+
+	var a []string
+	for name := range j.grm {
+		if ast.IsExported(name) {
+			a = append(a, name)
+		}
+	}
+	sort.Strings(a)
+	j.ruleNames = map[string]string{}
+	by := map[string]string{}
+	for _, name := range a {
+		s := f(name)
+		if token.Lookup(s).IsKeyword() {
+			return fmt.Errorf("-rename-rules: %s would be renamed to the keyword %s", name, s)
+		}
+
+		if prev, ok := by[s]; ok {
+			return fmt.Errorf("-rename-rules: both %s and %s would be renamed to %s", prev, name, s)
+		}
+
+		by[s] = name
+		j.ruleNames[name] = s
+	}
+	return
+}
+
+// ruleName returns the output name of the named non terminal production.
+func (j *job) ruleName(name string) string {
+	if s, ok := j.ruleNames[name]; ok {
+		return s
+	}
+
+	return name
+}
+
+// synthName returns the synthetic production name s, renamed by
+// -rename-synthetic if listed there.
+func (j *job) synthName(s string) string {
+	n, ok := j.synthMap[s]
+	switch {
+	case !ok || n == s:
+		return s
+	case !ast.IsExported(n):
+		log.Fatalf("-rename-synthetic: cannot rename %s to %s, a non terminal name must start with an upper case letter", s, n)
+	case j.names[n]:
+		log.Fatalf("-rename-synthetic: cannot rename %s to %s, the name is already used", s, n)
+	}
+
+	j.names[n] = true
+	return n
+}
+
+// synthKey returns the derived name of the production name, renamed by
+// -rename-synthetic, the prefix of the names of its own synthetics.
+func (j *job) synthKey(name string) string {
+	for s, n := range j.synthMap {
+		if n == name {
+			return s
+		}
+	}
+
+	return name
+}
+
+// checkSynthMap returns an error if -rename-synthetic lists a name not given
+// to a synthetic production, eg. after a change of the grammar.
+func (j *job) checkSynthMap() error {
+	var a []string
+	for s := range j.synthMap {
+		a = append(a, s)
+	}
+	sort.Strings(a)
+	for _, s := range a {
+		if _, ok := j.synthetic[j.synthMap[s]]; !ok {
+			return fmt.Errorf("-rename-synthetic: %s is not a synthetic production", s)
+		}
+	}
+	return nil
+}
+
+// renameTable writes the renamed non terminals as name=newname lines.
+func (j *job) renameTable(w io.Writer) (err error) {
+	var a []string
+	for name := range j.ruleNames {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	for _, name := range a {
+		if _, err = fmt.Fprintf(w, "%s=%s\n", name, j.ruleNames[name]); err != nil {
+			return
+		}
+	}
+	return
+}
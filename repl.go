@@ -0,0 +1,114 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"io"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+)
+
+const replHelp = `accept token...	Report whether the tokens are derived from the start production, like -accept.
+first Name	Write the FIRST set of the non terminal Name.
+follow Name	Write the FOLLOW set of the non terminal Name.
+help		Write this text.
+lower name	Write the BNF productions the production name is lowered to.
+nullable Name	Report whether the non terminal Name derives the empty string.
+quit		Leave.
+`
+
+// repl reads the -repl commands from r, one per line, and writes the answers
+// to w until the quit command or the end of r.
+func repl(r io.Reader, w io.Writer, grm ebnfutil.Grammar, start string, depth int) error {
+	a := newAnalysis(grm, start)
+	rec := newRecognizer(grm, depth)
+	s := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, "> ")
+		if !s.Scan() {
+			fmt.Fprintln(w)
+			return s.Err()
+		}
+
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+
+		cmd, args := f[0], f[1:]
+		switch cmd {
+		case "accept":
+			rec.accept(w, start, tokens(args))
+			continue
+		case "help":
+			fmt.Fprint(w, replHelp)
+			continue
+		case "quit", "exit":
+			return nil
+		case "first", "follow", "nullable", "lower":
+			// below
+		default:
+			fmt.Fprintf(w, "unknown command %q, try help\n", cmd)
+			continue
+		}
+
+		if len(args) != 1 {
+			fmt.Fprintf(w, "usage: %s name\n", cmd)
+			continue
+		}
+
+		name := args[0]
+		if _, ok := grm[name]; !ok {
+			fmt.Fprintf(w, "undefined production %q\n", name)
+			continue
+		}
+
+		if cmd == "lower" {
+			lowering(w, grm, start, name)
+			continue
+		}
+
+		if !ast.IsExported(name) {
+			fmt.Fprintf(w, "%s is not a non terminal\n", name)
+			continue
+		}
+
+		switch cmd {
+		case "first":
+			fmt.Fprintln(w, strings.Join(a.First(name).Sorted(), " "))
+		case "follow":
+			fmt.Fprintln(w, strings.Join(a.Follow(name).Sorted(), " "))
+		case "nullable":
+			fmt.Fprintln(w, a.Nullable(name))
+		}
+	}
+}
+
+// lowering writes the BNF productions of the named production and the ones
+// derived from it, lowering a copy of grm.
+func lowering(w io.Writer, grm ebnfutil.Grammar, start, name string) {
+	g := ebnfutil.Grammar{}
+	for n, prod := range grm {
+		g[n] = copyProduction(prod, 0)
+	}
+	j := &job{
+		grm:       g,
+		names:     map[string]bool{},
+		synthetic: map[string]string{},
+		children:  map[string][]string{},
+	}
+	for _, n := range keywords {
+		j.names[n] = true
+	}
+	for n := range g {
+		j.names[n] = true
+	}
+	j.toBnf(start)
+	(&explainer{name, w}).bnf("BNF", j)
+}
@@ -0,0 +1,205 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"io"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cznic/ebnfutil"
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+const infinite = int(^uint(0) >> 1)
+
+// sampler generates random sentences of a grammar.
+type sampler struct {
+	cost  map[string]int // Production: minimal length of its expansion.
+	depth int
+	grm   ebnfutil.Grammar
+	kinds []string // Of the tokens of the sentence, as read by the recognizer.
+	rnd   *rand.Rand
+}
+
+// newSampler returns a sampler of grm which falls back to the shortest
+// terminating expansion of productions nested more than depth levels deep.
+func newSampler(grm ebnfutil.Grammar, depth int) *sampler {
+	s := &sampler{
+		cost:  map[string]int{},
+		depth: depth,
+		grm:   grm,
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+	for name := range grm {
+		s.cost[name] = infinite
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, prod := range grm {
+			if n := s.exprCost(prod.Expr); n < s.cost[name] {
+				s.cost[name] = n
+				changed = true
+			}
+		}
+	}
+	return s
+}
+
+func add(a, b int) int {
+	if a == infinite || b == infinite {
+		return infinite
+	}
+
+	return a + b
+}
+
+func (s *sampler) exprCost(expr ebnf.Expression) (n int) {
+	switch x := expr.(type) {
+	case nil, *ebnf.Option, *ebnf.Repetition:
+		return 0
+	case ebnf.Alternative:
+		n = infinite
+		for _, v := range x {
+			if c := s.exprCost(v); c < n {
+				n = c
+			}
+		}
+		return n
+	case ebnf.Sequence:
+		for _, v := range x {
+			n = add(n, s.exprCost(v))
+		}
+		return n
+	case *ebnf.Group:
+		return s.exprCost(x.Body)
+	case *ebnf.Name:
+		if prod, ok := s.grm[x.String]; ok && prod.Expr == nil {
+			return 1
+		}
+
+		return s.cost[x.String]
+	default:
+		return 1
+	}
+}
+
+// sample returns a random sentence derived from start. Tokens are separated
+// by a space. A lexical token is represented by a string it matches or, if
+// its production is empty, by its name.
+func (s *sampler) sample(start string) string {
+	return strings.Join(s.sampleTokens(start), " ")
+}
+
+// sampleTokens returns the tokens of a random sentence derived from start.
+// The kinds of the tokens, literals or names of lexical productions, are
+// left in s.kinds.
+func (s *sampler) sampleTokens(start string) (a []string) {
+	s.kinds = nil
+	s.expand(&a, s.grm[start].Expr, 0, false)
+	return a
+}
+
+func (s *sampler) emit(a *[]string, lex bool, t string) {
+	switch {
+	case lex && len(*a) != 0:
+		(*a)[len(*a)-1] += t
+	default:
+		*a = append(*a, t)
+		s.kinds = append(s.kinds, t)
+	}
+}
+
+func (s *sampler) expand(a *[]string, expr ebnf.Expression, depth int, lex bool) {
+	deep := depth >= s.depth
+	switch x := expr.(type) {
+	case nil:
+		// nop
+	case ebnf.Alternative:
+		v := x[s.rnd.Intn(len(x))]
+		if deep {
+			n := infinite
+			for _, w := range x {
+				if c := s.exprCost(w); c < n {
+					n, v = c, w
+				}
+			}
+		}
+		s.expand(a, v, depth, lex)
+	case ebnf.Sequence:
+		for _, v := range x {
+			s.expand(a, v, depth, lex)
+		}
+	case *ebnf.Group:
+		s.expand(a, x.Body, depth, lex)
+	case *ebnf.Option:
+		if !deep && s.rnd.Intn(2) == 0 {
+			s.expand(a, x.Body, depth, lex)
+		}
+	case *ebnf.Repetition:
+		if deep {
+			break
+		}
+
+		for i := s.rnd.Intn(3); i > 0; i-- {
+			s.expand(a, x.Body, depth, lex)
+		}
+	case *ebnf.Name:
+		name := x.String
+		expr := s.grm[name].Expr
+		switch {
+		case ast.IsExported(name):
+			s.expand(a, expr, depth+1, false)
+		case expr == nil:
+			s.emit(a, lex, name)
+		case lex:
+			s.expand(a, expr, depth+1, true)
+		default:
+			*a = append(*a, "")
+			s.kinds = append(s.kinds, name)
+			s.expand(a, expr, depth+1, true)
+		}
+	case *ebnf.Token:
+		s.emit(a, lex, x.String)
+	case *ebnf.Range:
+		lo, _ := utf8.DecodeRuneInString(x.Begin.String)
+		hi, _ := utf8.DecodeRuneInString(x.End.String)
+		s.emit(a, lex, string(lo+rune(s.rnd.Intn(int(hi-lo)+1))))
+	}
+}
+
+// renderSamplesTest writes a Go test checking the parser accepts every one of
+// the samples, read by the lexer value of the Go expression lexer, of
+// variable src.
+func renderSamplesTest(w io.Writer, pkg, lexer string, samples []string) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "Sample test")
+	f.Format(`package %s
+
+import (
+	"testing"
+)
+
+var samples = []string{%i
+`, pkg)
+	for _, s := range samples {
+		f.Format("%q,\n", s)
+	}
+	f.Format(`%u}
+
+func TestSamples(t *testing.T) {%i
+for i, src := range samples {%i
+if yyParse(%s) != 0 {%i
+t.Errorf("%%d: %%q: not accepted", i, src)%u
+}%u
+}%u
+}
+`, lexer)
+	return sw.err
+}
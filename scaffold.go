@@ -0,0 +1,131 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/cznic/strutil"
+)
+
+// The files of a -scaffold directory generated from the grammar.
+const (
+	scaffoldLexer  = "lexer.l"
+	scaffoldParser = "parser.y"
+)
+
+// scaffoldCommand returns the ebnf2y command line regenerating the parser of
+// a -scaffold directory from its copy of the grammar, the file base.
+func scaffoldCommand(base string) string {
+	a := append([]string{"ebnf2y"}, setFlags(map[string]bool{
+		"gogenerate": true,
+		"lexer":      true,
+		"o":          true,
+		"scaffold":   true,
+		"update":     true,
+	})...)
+	return strings.Join(append(a, "-o", scaffoldParser, goGenerateArg(base)), " ")
+}
+
+// scaffold writes to dir, besides the .y file and the lexer skeleton, a copy
+// src of the grammar named base, the Go program or package using the parser
+// and a Makefile.
+func (j *job) scaffold(dir, base string, src []byte) {
+	if err := ioutil.WriteFile(filepath.Join(dir, base), src, 0666); err != nil {
+		log.Fatal(err)
+	}
+
+	fn := "parse.go"
+	if j.pkg == "main" {
+		fn = "main.go"
+	}
+	create(filepath.Join(dir, fn), func(w io.Writer) error { return j.renderScaffoldGo(w, base) })
+	create(filepath.Join(dir, "Makefile"), func(w io.Writer) error { return renderMakefile(w, base) })
+}
+
+// renderScaffoldGo writes the main function of the program parsing stdin or,
+// for other packages than main, the Parse function.
+func (j *job) renderScaffoldGo(w io.Writer, base string) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "Parser driver")
+	f.Format(`//go:generate %s
+//go:generate goyacc -o parser.go %s
+//go:generate golex -o lexer.go %s
+
+package %s
+
+`, scaffoldCommand(base), scaffoldParser, scaffoldLexer, j.pkg)
+	if j.pkg == "main" {
+		f.Format(`import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {%i
+src, err := ioutil.ReadAll(os.Stdin)
+if err != nil {%i
+fmt.Fprintln(os.Stderr, err)
+os.Exit(1)
+%u}
+
+l := newLexer(string(src))
+if yyParse(l) != 0 {%i
+for _, err := range l.errs {%i
+fmt.Fprintln(os.Stderr, err)
+%u}
+os.Exit(1)
+%u}
+
+_dump()
+%u}
+`)
+		return sw.err
+	}
+
+	f.Format(`// Parse returns the value of the start production of src.
+func Parse(src string) (interface{}, error) {%i
+l := newLexer(src)
+if yyParse(l) != 0 {%i
+return nil, l.errs[0]
+%u}
+
+return %s, nil
+%u}
+`, j.resultVar())
+	return sw.err
+}
+
+func renderMakefile(w io.Writer, base string) error {
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	f.Format(`#%s Put your favorite license here
+
+.PHONY: all clean
+
+all: lexer.go parser.go
+	go fmt
+	go build
+
+clean:
+	@go clean
+	rm -f y.output
+
+lexer.go: %s
+	golex -o $@ $<
+
+parser.go: %s
+	goyacc -o $@ $<
+
+%s: %s
+	%s
+`, todo, scaffoldLexer, scaffoldParser, scaffoldParser, base, strings.Replace(scaffoldCommand(base), "$", "$$", -1))
+	return sw.err
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+
+	"golang.org/x/exp/ebnf"
+)
+
+// astSchema is the -ast-schema description of the AST built by the actions of
+// the .y file.
+type astSchema struct {
+	Root  string       `json:"root"` // The node type of the parser result.
+	Nodes []schemaNode `json:"nodes"`
+}
+
+// schemaNode is an AST node type, the value of a non terminal.
+type schemaNode struct {
+	Name         string      `json:"name"`
+	Production   string      `json:"production"`          // The EBNF production.
+	Construct    string      `json:"construct,omitempty"` // Of a synthetic production: group, option or repetition.
+	Kind         string      `json:"kind"`                // node or list.
+	Alternatives []schemaAlt `json:"alternatives"`
+}
+
+// schemaAlt is the value of a rule alternative: nil, the single field item or
+// the slice of the fields. The alternatives of a list append their fields to
+// it. A fold is the expression tree of nodes of the type of the alternative
+// built from the operands and operators of its @left or @right repetition.
+type schemaAlt struct {
+	Value  string        `json:"value"` // nil, item, slice, append, fold-left or fold-right.
+	Fields []schemaField `json:"fields"`
+}
+
+type schemaField struct {
+	Kind    string `json:"kind"` // token, node, optional or list.
+	Type    string `json:"type,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+// sourceProduction returns the EBNF production the named one was derived from.
+func (j *job) sourceProduction(name string) string {
+	for {
+		s, ok := j.synthetic[name]
+		if !ok {
+			return name
+		}
+
+		name = s
+	}
+}
+
+// optional reports whether the value of the named non terminal may be nil.
+func (j *job) optional(name string) bool {
+	for _, v := range alternatives(j.grm[name].Expr) {
+		if x, ok := v.(ebnf.Sequence); v == nil || ok && len(x) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func alternatives(expr ebnf.Expression) []ebnf.Expression {
+	if x, ok := expr.(ebnf.Alternative); ok {
+		return x
+	}
+
+	return []ebnf.Expression{expr}
+}
+
+// fields returns the items of the value of a rule alternative, like ystr.
+func (j *job) fields(expr ebnf.Expression) (a []schemaField) {
+	var f func(ebnf.Expression)
+	f = func(expr ebnf.Expression) {
+		switch x := expr.(type) {
+		case *ebnf.Name:
+			switch name := x.String; {
+			case !ast.IsExported(name):
+				a = append(a, schemaField{Kind: "token", Type: j.term2name[name]})
+			case j.repetitions[name]:
+				a = append(a, schemaField{Kind: "list", Type: j.ruleName(name)})
+			case j.optional(name):
+				a = append(a, schemaField{Kind: "optional", Type: j.ruleName(name)})
+			default:
+				a = append(a, schemaField{Kind: "node", Type: j.ruleName(name)})
+			}
+		case ebnf.Sequence:
+			for _, v := range x {
+				f(v)
+			}
+		case *ebnf.Token:
+			a = append(a, schemaField{Kind: "token", Type: j.term2name[x.String], Literal: x.String})
+		}
+	}
+	f(expr)
+	return
+}
+
+// renderSchema writes the JSON description of the AST node types, their
+// fields and productions.
+func (j *job) renderSchema(w io.Writer, start, root string) error {
+	j.checkTerminals(start)
+	var nts []string
+	for name := range j.rep.NonTerminals {
+		nts = append(nts, name)
+	}
+	sort.Strings(nts)
+	s := astSchema{Root: j.ruleName(root), Nodes: []schemaNode{}}
+	for _, name := range nts {
+		n := schemaNode{
+			Name:         j.ruleName(name),
+			Production:   j.ruleName(j.sourceProduction(name)),
+			Construct:    j.origins[name].kind,
+			Kind:         "node",
+			Alternatives: []schemaAlt{},
+		}
+		rep := j.repetitions[name]
+		if rep {
+			n.Kind = "list"
+		}
+		for i, v := range alternatives(j.grm[name].Expr) {
+			a := j.fields(v)
+			assoc, _ := j.fold(v)
+			var value string
+			switch {
+			case rep && i == 0:
+				value = "nil"
+			case rep && j.rightRecursion:
+				value, a = "append", a[:len(a)-1]
+			case rep:
+				value, a = "append", a[1:]
+			case assoc != "":
+				value = "fold-" + assoc
+			case j.altRule(name):
+				value = "slice"
+			case len(a) == 0:
+				value = "nil"
+			case len(a) == 1:
+				value = "item"
+			default:
+				value = "slice"
+			}
+			if a == nil {
+				a = []schemaField{}
+			}
+			n.Alternatives = append(n.Alternatives, schemaAlt{value, a})
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
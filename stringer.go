@@ -0,0 +1,93 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"sort"
+
+	"github.com/cznic/strutil"
+)
+
+// renderStringer writes a Go file with the function astString, producing the
+// indented tree dump of an AST node, as printed by the demo. The nodes are
+// the slices of the non terminal types declared by the .y file.
+func (j *job) renderStringer(w io.Writer, start string) error {
+	j.checkTerminals(start)
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "AST stringer")
+	f.Format(`package %s
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// astString returns the indented tree dump of the AST node n. Nil items are
+// left out.
+func astString(n interface{}) string {
+	var buf bytes.Buffer
+	astDump(&buf, n, 0)
+	return buf.String()
+}
+
+func astDump(buf *bytes.Buffer, n interface{}, level int) {
+	items, ok, _ := astNode(n)
+	if !ok {
+		fmt.Fprintf(buf, "%%#v", n)
+		return
+	}
+
+	// Like in the demo, an item is followed by a comma if it is not the
+	// last one, even if all the following ones are nil.
+	var a []string
+	for i, v := range items {
+		if _, _, isNil := astNode(v); isNil {
+			continue
+		}
+
+		var b bytes.Buffer
+		astDump(&b, v, level+1)
+		if i != len(items)-1 {
+			b.WriteString(",")
+		}
+		a = append(a, b.String())
+	}
+	if len(a) == 0 {
+		fmt.Fprintf(buf, "%%T{}", n)
+		return
+	}
+
+	fmt.Fprintf(buf, "%%T{\n", n)
+	for _, v := range a {
+		buf.WriteString(strings.Repeat(". ", level+1))
+		buf.WriteString(v)
+		buf.WriteString("\n")
+	}
+	buf.WriteString(strings.Repeat(". ", level))
+	buf.WriteString("}")
+}
+
+// astNode returns the items of the AST node n, whether n is a node and
+// whether n is nil or a nil node.
+func astNode(n interface{}) (items []interface{}, ok, isNil bool) {
+	switch x := n.(type) {
+	case nil:
+		return nil, false, true
+`, j.pkg)
+	nts := []string{}
+	for name := range j.rep.NonTerminals {
+		nts = append(nts, j.nodeType(name))
+	}
+	sort.Strings(nts)
+	f.Format("%i")
+	for _, name := range nts {
+		f.Format("case %s:%i\nfor _, v := range x {%i\nitems = append(items, v)%u\n}\nreturn items, true, x == nil%u\n", name)
+	}
+	f.Format("}\nreturn nil, false, false%u\n}\n")
+	return sw.err
+}
@@ -0,0 +1,281 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cznic/ebnf2y/grammar"
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// suspect is a non terminal production likely to cause a LALR(1) conflict.
+type suspect struct {
+	name  string   // The production to inline.
+	other string   // The production competing with it.
+	in    string   // The production where they compete.
+	on    []string // Lookahead terminals of the conflict.
+}
+
+// leading returns the non terminal expr starts with, if any.
+func leading(expr ebnf.Expression) string {
+	switch x := expr.(type) {
+	case ebnf.Sequence:
+		if len(x) != 0 {
+			return leading(x[0])
+		}
+	case *ebnf.Name:
+		if ast.IsExported(x.String) {
+			return x.String
+		}
+	}
+	return ""
+}
+
+// corners returns name and the non terminals which can start the strings
+// derived from it, directly or indirectly.
+func corners(grm ebnfutil.Grammar, a *analysis, name string) []string {
+	r := []string{name}
+	seen := map[string]bool{name: true}
+	for i := 0; i < len(r); i++ {
+		a.leftCorners(grm[r[i]].Expr, nil, func(s string, _ []string) {
+			if !seen[s] {
+				seen[s] = true
+				r = append(r, s)
+			}
+		})
+	}
+	return r
+}
+
+// findSuspects returns a pair of non terminals of grm, other than start and
+// not in tried, likely to cause a reduce/reduce conflict. They start, directly
+// or indirectly, different alternatives of one production and their FIRST
+// sets and FOLLOW sets intersect. The parser has to choose which of them to
+// reduce seeing the same lookahead. Inlining both of them postpones the
+// decision, inlining only one would leave a shift/reduce conflict instead.
+// Productions referring to themselves cannot be inlined and are not
+// returned, neither are the pairs where one of them is a left corner of the
+// other, like B of A = B | "x" . The parser reduces then B before A.
+func findSuspects(grm ebnfutil.Grammar, a *analysis, start string, tried map[string]bool) (r []*suspect) {
+	eligible := func(name string) bool {
+		return name != start && !tried[name] && !selfReferring(grm, name)
+	}
+	m := map[string][]string{}
+	cornersOf := func(name string) []string {
+		if _, ok := m[name]; !ok {
+			m[name] = corners(grm, a, name)
+		}
+		return m[name]
+	}
+	isCorner := func(x, y string) bool {
+		for _, v := range cornersOf(y) {
+			if v == x {
+				return true
+			}
+		}
+		return false
+	}
+
+	var names []string
+	for name := range grm {
+		if ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, in := range names {
+		alts, ok := grm[in].Expr.(ebnf.Alternative)
+		if !ok {
+			continue
+		}
+
+		for i, u := range alts {
+			x := leading(u)
+			if x == "" {
+				continue
+			}
+
+			for _, v := range alts[i+1:] {
+				y := leading(v)
+				if y == "" || y == x {
+					continue
+				}
+
+				for _, x := range cornersOf(x) {
+					for _, y := range cornersOf(y) {
+						if x == y || len(common(a.First(x), a.First(y))) == 0 {
+							continue
+						}
+
+						if isCorner(x, y) || isCorner(y, x) {
+							// Never complete items of the same
+							// state.
+							continue
+						}
+
+						on := common(a.Follow(x), a.Follow(y))
+						if len(on) == 0 {
+							continue
+						}
+
+						if eligible(x) {
+							r = append(r, &suspect{x, y, in, on})
+						}
+						if eligible(y) {
+							r = append(r, &suspect{y, x, in, on})
+						}
+						if len(r) != 0 {
+							return r
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// selfReferring reports whether the named production of grm refers to itself,
+// directly or indirectly.
+func selfReferring(grm ebnfutil.Grammar, name string) bool {
+	seen := map[string]bool{}
+	var visit func(string) bool
+	visit = func(n string) (found bool) {
+		walk(grm[n].Expr, func(expr ebnf.Expression) {
+			x, ok := expr.(*ebnf.Name)
+			if !ok || found || !ast.IsExported(x.String) || !has(grm, x.String) {
+				return
+			}
+
+			switch {
+			case x.String == name:
+				found = true
+			case !seen[x.String]:
+				seen[x.String] = true
+				found = visit(x.String)
+			}
+		})
+		return
+	}
+	return visit(name)
+}
+
+// snapshot returns the expressions of the productions of grm.
+func snapshot(grm ebnfutil.Grammar) map[string]string {
+	m := map[string]string{}
+	for name, prod := range grm {
+		m[name] = ebnfStr(prod.Expr)
+	}
+	return m
+}
+
+// modified returns the names of the productions of grm added, removed or
+// changed since its snapshot m.
+func modified(grm ebnfutil.Grammar, m map[string]string) (a []string) {
+	for name, prod := range grm {
+		if s, ok := m[name]; !ok || s != ebnfStr(prod.Expr) {
+			a = append(a, name)
+		}
+	}
+	for name := range m {
+		if !has(grm, name) {
+			a = append(a, name)
+		}
+	}
+	return
+}
+
+// common returns the sorted members of both s and t.
+func common(s, t grammar.TokenSet) (a []string) {
+	for _, v := range s.Sorted() {
+		if t[v] {
+			a = append(a, v)
+		}
+	}
+	return
+}
+
+// candidate is a production ranked by -inline-candidates.
+type candidate struct {
+	name    string
+	refs    int
+	size    int      // Of the terminals and non terminals of its expression.
+	growth  int      // Of the grammar by inlining it: (refs-1)*size.
+	suspect *suspect // Of a reduce/reduce conflict, if any.
+}
+
+type byBenefit []*candidate
+
+func (a byBenefit) Len() int { return len(a) }
+
+func (a byBenefit) Less(i, j int) bool {
+	if x, y := a[i].suspect != nil, a[j].suspect != nil; x != y {
+		return x
+	}
+
+	if a[i].growth != a[j].growth {
+		return a[i].growth < a[j].growth
+	}
+
+	return a[i].name < a[j].name
+}
+
+func (a byBenefit) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// inlineCandidates returns the non terminals of grm which can be inlined,
+// but for start and the unused ones, the suspects of reduce/reduce conflicts
+// first, then by increasing growth of the grammar.
+func inlineCandidates(grm ebnfutil.Grammar, start string) (r []*candidate) {
+	suspects := map[string]*suspect{}
+	tried := map[string]bool{}
+	an := newAnalysis(grm, start)
+	for {
+		a := findSuspects(grm, an, start, tried)
+		if len(a) == 0 {
+			break
+		}
+
+		for _, s := range a {
+			tried[s.name] = true
+			suspects[s.name] = s
+		}
+	}
+	for _, v := range countRefs(grm) {
+		if !ast.IsExported(v.Name) || v.Name == start || v.Refs == 0 || selfReferring(grm, v.Name) {
+			continue
+		}
+
+		size := 0
+		walk(grm[v.Name].Expr, func(expr ebnf.Expression) {
+			switch expr.(type) {
+			case *ebnf.Name, *ebnf.Token:
+				size++
+			}
+		})
+		r = append(r, &candidate{v.Name, v.Refs, size, (v.Refs - 1) * size, suspects[v.Name]})
+	}
+	sort.Sort(byBenefit(r))
+	return
+}
+
+// writeInlineCandidates writes the candidates a to w, one per line.
+func writeInlineCandidates(w io.Writer, a []*candidate) (err error) {
+	for _, v := range a {
+		s := ""
+		if x := v.suspect; x != nil {
+			s = fmt.Sprintf("\treduce/reduce with %s in %s on %s", x.other, x.in, strings.Join(x.on, " "))
+		}
+		if _, err = fmt.Fprintf(w, "%s\trefs %d\tsize %d\tgrowth %d%s\n", v.name, v.refs, v.size, v.growth, s); err != nil {
+			return
+		}
+	}
+	return
+}
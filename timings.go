@@ -0,0 +1,72 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// timings accumulates the wall clock time spent in the phases of a
+// conversion. All methods of a nil *timings do nothing.
+type timings struct {
+	d     map[string]time.Duration
+	names []string // In order of first use.
+	phase string
+	t     time.Time
+}
+
+func newTimings() *timings {
+	return &timings{d: map[string]time.Duration{}, t: time.Now()}
+}
+
+// enter ends the current phase, starts the named one and returns the name of
+// the ended phase. Time spent in a phase entered repeatedly adds up.
+func (t *timings) enter(name string) (prev string) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	if t.phase != "" {
+		t.d[t.phase] += now.Sub(t.t)
+	}
+	if _, ok := t.d[name]; !ok && name != "" {
+		t.names = append(t.names, name)
+		t.d[name] = 0
+	}
+	prev, t.phase, t.t = t.phase, name, now
+	return
+}
+
+// write ends the current phase and writes a table of the phases to w.
+func (t *timings) write(w io.Writer) {
+	if t == nil {
+		return
+	}
+
+	t.enter("")
+	var total time.Duration
+	for _, d := range t.d {
+		total += d
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "phase\ttime\t%%\n")
+	for _, name := range t.names {
+		fmt.Fprintf(tw, "%s\t%v\t%.1f\n", name, t.d[name], percent(t.d[name], total))
+	}
+	fmt.Fprintf(tw, "total\t%v\t%.1f\n", total, percent(total, total))
+	tw.Flush()
+}
+
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(d) / float64(total)
+}
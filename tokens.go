@@ -0,0 +1,466 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/cznic/ebnfutil"
+	"github.com/cznic/strutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// Token categories, in the order of the -sort-tokens category sections.
+const (
+	catToken   = iota // Lexical production.
+	catLiteral        // Literal named TOKn.
+	catKeyword        // Literal named after its letters.
+)
+
+// tokenDecl is a %token declaration.
+type tokenDecl struct {
+	cat  int
+	name string // Yacc name.
+	off  int    // Source order.
+	src  string // Lexical production name or literal.
+}
+
+type tokenDecls struct {
+	a  []*tokenDecl
+	by string
+}
+
+func (t tokenDecls) Len() int      { return len(t.a) }
+func (t tokenDecls) Swap(i, j int) { t.a[i], t.a[j] = t.a[j], t.a[i] }
+
+func (t tokenDecls) Less(i, j int) bool {
+	a, b := t.a[i], t.a[j]
+	switch t.by {
+	case "category":
+		if a.cat != b.cat {
+			return a.cat < b.cat
+		}
+
+		return a.name < b.name
+	case "name":
+		return a.name < b.name
+	default:
+		if a.off != b.off {
+			return a.off < b.off
+		}
+
+		return a.name < b.name
+	}
+}
+
+// tokenOrder returns the source order of the terminals of grm: the offset of
+// the production of a lexical token and the offset of the first use of a
+// literal by a non terminal production.
+func tokenOrder(grm ebnfutil.Grammar) map[string]int {
+	m := map[string]int{}
+	for name, prod := range grm {
+		if !ast.IsExported(name) {
+			m[name] = prod.Pos().Offset
+			continue
+		}
+
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			if x, ok := expr.(*ebnf.Token); ok {
+				if off, ok := m[x.String]; !ok || x.Pos().Offset < off {
+					m[x.String] = x.Pos().Offset
+				}
+			}
+		})
+	}
+	return m
+}
+
+// tokens writes the %token declarations of the terminals in the order
+// selected by -sort-tokens.
+func (j *job) tokens(f strutil.Formatter) {
+	off := func(s string) int {
+		if n, ok := j.order[s]; ok {
+			return n
+		}
+
+		return int(^uint(0) >> 1)
+	}
+	j.term2name = map[string]string{}
+	var a []*tokenDecl
+	var names, lits, keywords []string
+	for name := range j.rep.Tokens {
+		names = append(names, name)
+	}
+	for lit := range j.rep.Literals {
+		switch {
+		case j.inlineLiteral(lit):
+			// nop
+		case toAscii(lit) == "":
+			lits = append(lits, lit)
+		default:
+			keywords = append(keywords, lit)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(keywords)
+	sort.Strings(lits)
+	if j.sortTokens == "source" {
+		sort.Stable(bySource{lits, off})
+	}
+
+	for _, name := range names {
+		token := j.tokenName(j.tPrefix + strings.ToUpper(name))
+		j.term2name[name] = token
+		a = append(a, &tokenDecl{catToken, token, off(name), name})
+	}
+	j.inventName(j.tPrefix+"TOK", "")
+	for _, lit := range lits {
+		token := j.tokenName(j.tPrefix + "TOK")
+		j.term2name[lit] = token
+		a = append(a, &tokenDecl{catLiteral, token, off(lit), lit})
+	}
+	for _, lit := range keywords {
+		token := j.tokenName(j.tPrefix + strings.ToUpper(toAscii(lit)))
+		j.term2name[lit] = token
+		a = append(a, &tokenDecl{catKeyword, token, off(lit), lit})
+	}
+	sort.Sort(tokenDecls{a, j.sortTokens})
+	if j.tokenOrder != nil {
+		j.seedTokens(a)
+	}
+
+	decl := func(t *tokenDecl) {
+		switch t.cat {
+		case catLiteral:
+			f.Format("%%token\t%s\t/*%s Name for %q */\n", t.name, todo, t.src)
+		case catKeyword:
+			if j.sortTokens == "category" {
+				f.Format("%%token %s\n", t.name)
+				break
+			}
+
+			fallthrough
+		default:
+			if s, ok := j.tokenTypes[t.src]; ok && t.cat == catToken {
+				f.Format("%%token\t<%s>\t%s\n", unionField(s), t.name)
+				break
+			}
+
+			f.Format("%%token\t%s\n", t.name)
+		}
+	}
+	types := func(a []*tokenDecl) {
+		typed := false
+		for _, t := range a {
+			if _, ok := j.tokenTypes[t.src]; t.cat == catKeyword || ok && t.cat == catToken {
+				continue
+			}
+
+			if !typed {
+				f.Format("%%type\t<item> \t/*%s real type(s), if/where applicable */\n", todo)
+				typed = true
+			}
+			f.Format("\t%s\n", t.name)
+		}
+		if typed {
+			f.Format("\n")
+		}
+	}
+
+	if j.sortTokens != "category" {
+		for _, t := range a {
+			decl(t)
+		}
+		if len(a) != 0 {
+			f.Format("\n")
+		}
+		types(a)
+		return
+	}
+
+	for len(a) != 0 {
+		i := 1
+		for i < len(a) && a[i].cat == a[0].cat {
+			i++
+		}
+		for _, t := range a[:i] {
+			decl(t)
+		}
+		f.Format("\n")
+		types(a[:i])
+		a = a[i:]
+	}
+}
+
+// seedTokens moves the tokens of a listed by -token-order-from to the front,
+// in the order of the file, keeping their goyacc numbers. The other tokens
+// follow in the -sort-tokens order. It warns, once, about the listed tokens
+// no longer declared.
+func (j *job) seedTokens(a []*tokenDecl) {
+	index := map[string]int{}
+	for i, name := range j.tokenOrder.names {
+		index[name] = i
+	}
+	seeded := map[string]bool{}
+	last := -1 // Of the seeded tokens.
+	for _, t := range a {
+		if i, ok := index[t.name]; ok {
+			seeded[t.name] = true
+			if i > last {
+				last = i
+			}
+		}
+	}
+	sort.Stable(bySeed{a, func(s string) int {
+		if i, ok := index[s]; ok {
+			return i
+		}
+
+		return len(index)
+	}})
+
+	if j.tokenOrder.checked {
+		return
+	}
+
+	j.tokenOrder.checked = true
+	for i, name := range j.tokenOrder.names {
+		if !seeded[name] && i < last {
+			warn(j.tokenOrder.pos[name], "-token-order-from: token %s is no longer declared, the numbers of the tokens following it change", name)
+		}
+	}
+}
+
+type bySeed struct {
+	a     []*tokenDecl
+	index func(string) int
+}
+
+func (s bySeed) Len() int           { return len(s.a) }
+func (s bySeed) Less(i, j int) bool { return s.index(s.a[i].name) < s.index(s.a[j].name) }
+func (s bySeed) Swap(i, j int)      { s.a[i], s.a[j] = s.a[j], s.a[i] }
+
+// tokenSeed is the order of the tokens read by -token-order-from.
+type tokenSeed struct {
+	checked bool // Of the tokens no longer declared.
+	names   []string
+	pos     map[string]scanner.Position
+}
+
+var (
+	reGoToken  = regexp.MustCompile(`^\s*(?:const\s+)?([_\pL][_\pL\pN]*)\s*=\s*([0-9]+)\s*$`)
+	reTokenTag = regexp.MustCompile(`<[^>]*>|/\*.*?\*/|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`)
+)
+
+// loadTokenOrder reads the order of the tokens from the named file, the
+// %token declarations of a .y file, the token constants numbered by goyacc of
+// a .go file or else a token name per line, # starting a comment.
+func loadTokenOrder(fn string) (*tokenSeed, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tokenSeed{pos: map[string]scanner.Position{}}
+	add := func(name string, line int) error {
+		pos := scanner.Position{Filename: fn, Line: line, Column: 1}
+		if !reIdent.MatchString(name) {
+			return fmt.Errorf("%s: invalid token name %q", pos, name)
+		}
+
+		if _, ok := s.pos[name]; ok {
+			return fmt.Errorf("%s: token %s listed again", pos, name)
+		}
+
+		s.names = append(s.names, name)
+		s.pos[name] = pos
+		return nil
+	}
+	var consts byNumber
+	for i, line := range strings.Split(string(b), "\n") {
+		switch filepath.Ext(fn) {
+		case ".y":
+			f := strings.Fields(reTokenTag.ReplaceAllString(line, " "))
+			if len(f) == 0 || f[0] != "%token" {
+				break
+			}
+
+			for _, name := range f[1:] {
+				if err := add(name, i+1); err != nil {
+					return nil, err
+				}
+			}
+		case ".go":
+			// The token constants of goyacc start at 57346.
+			if m := reGoToken.FindStringSubmatch(line); m != nil {
+				if n, err := strconv.Atoi(m[2]); err == nil && n >= 57346 {
+					consts = append(consts, tokenConst{m[1], i + 1, n})
+				}
+			}
+		default:
+			if j := strings.Index(line, "#"); j >= 0 {
+				line = line[:j]
+			}
+			if line = strings.TrimSpace(line); line == "" {
+				break
+			}
+
+			if err := add(line, i+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Sort(consts)
+	for _, v := range consts {
+		if err := add(v.name, v.line); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.names) == 0 {
+		return nil, fmt.Errorf("-token-order-from: no tokens found in %s", fn)
+	}
+
+	return s, nil
+}
+
+// tokenConst is a token constant of a goyacc generated parser.
+type tokenConst struct {
+	name string
+	line int
+	n    int
+}
+
+type byNumber []tokenConst
+
+func (a byNumber) Len() int           { return len(a) }
+func (a byNumber) Less(i, j int) bool { return a[i].n < a[j].n }
+func (a byNumber) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type bySource struct {
+	a   []string
+	off func(string) int
+}
+
+func (s bySource) Len() int           { return len(s.a) }
+func (s bySource) Less(i, j int) bool { return s.off(s.a[i]) < s.off(s.a[j]) }
+func (s bySource) Swap(i, j int)      { s.a[i], s.a[j] = s.a[j], s.a[i] }
+
+// renderKeywords writes the map of the keywords, the literals consisting of
+// letters, digits and underscores, to their tokens and its lookup function.
+func (j *job) renderKeywords(f strutil.Formatter) {
+	var a []string
+	for lit := range j.rep.Literals {
+		if lit != "" && toAscii(lit) == lit {
+			a = append(a, lit)
+		}
+	}
+	sort.Strings(a)
+	f.Format("// _keywords maps the keywords of the grammar to their tokens.\nvar _keywords = map[string]int{%i\n")
+	for _, lit := range a {
+		token := j.term2name[lit]
+		if j.inlineLiteral(lit) {
+			token = strconv.QuoteRune(rune(lit[0]))
+		}
+		f.Format("%q: %s,\n", lit, token)
+	}
+	f.Format(`%u}
+
+// LookupKeyword returns the token of s if it is a keyword.
+func LookupKeyword(s string) (int, bool) {%i
+t, ok := _keywords[s]
+return t, ok
+%u}
+
+`)
+}
+
+// loadNameMap reads the named file of name=newname lines, as used by
+// -token-map and -rename-synthetic.
+func loadNameMap(fn string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{}
+	to := map[string]string{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		a := strings.SplitN(line, "=", 2)
+		if len(a) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected name=newname", fn, i+1)
+		}
+
+		name, s := strings.TrimSpace(a[0]), strings.TrimSpace(a[1])
+		switch {
+		case !reIdent.MatchString(name) || !reIdent.MatchString(s):
+			return nil, fmt.Errorf("%s:%d: invalid name in %q", fn, i+1, line)
+		case m[name] != "":
+			return nil, fmt.Errorf("%s:%d: %s renamed again", fn, i+1, name)
+		case to[s] != "":
+			return nil, fmt.Errorf("%s:%d: %s and %s both renamed to %s", fn, i+1, to[s], name, s)
+		}
+
+		m[name], to[s] = s, name
+	}
+	return m, nil
+}
+
+// tokenName returns a new token name derived from prefix, renamed by
+// -token-map if listed there.
+func (j *job) tokenName(prefix string) string {
+	token := j.inventName(prefix, "")
+	s, ok := j.tokenMap[token]
+	if !ok || s == token {
+		return token
+	}
+
+	if j.names[s] {
+		log.Fatalf("-token-map: cannot rename %s to %s, the name is already used", token, s)
+	}
+
+	j.names[s] = true
+	return s
+}
+
+// unionField returns the name of the %union field of the values of Go type
+// typ, declared by name : typ = ... . for a token.
+func unionField(typ string) string {
+	return strings.ToLower(toAscii(strings.Replace(typ, "[]", "slice", -1))) + "val"
+}
+
+// unionFields writes the %union fields of the token types.
+func (j *job) unionFields(f strutil.Formatter) {
+	m := map[string]string{}
+	for _, typ := range j.tokenTypes {
+		field := unionField(typ)
+		if t, ok := m[field]; ok && t != typ {
+			log.Fatalf("%%union field %s of both %s and %s, rename a type", field, t, typ)
+		}
+
+		m[field] = typ
+	}
+	var a []string
+	for field := range m {
+		a = append(a, field)
+	}
+	sort.Strings(a)
+	for _, field := range a {
+		f.Format("\t%s %s\n", field, m[field])
+	}
+}
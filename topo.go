@@ -0,0 +1,195 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// component is a strongly connected component of the reference graph of a
+// grammar.
+type component struct {
+	Productions []string `json:"productions"`
+	Cycle       bool     `json:"cycle"`
+}
+
+// references returns the sorted names of the productions of grm referred to
+// by the named one.
+func references(grm ebnfutil.Grammar, name string) (a []string) {
+	m := map[string]bool{}
+	walk(grm[name].Expr, func(expr ebnf.Expression) {
+		if x, ok := expr.(*ebnf.Name); ok && has(grm, x.String) && !m[x.String] {
+			m[x.String] = true
+			a = append(a, x.String)
+		}
+	})
+	sort.Strings(a)
+	return
+}
+
+// toposort returns the strongly connected components of the reference graph
+// of grm, every one listed after all of those it refers to. The productions
+// of a component forming a cycle have no such order and are sorted by name.
+func toposort(grm ebnfutil.Grammar) (r []component) {
+	var names []string
+	for name := range grm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Tarjan's algorithm, which finds the components in reverse
+	// topological order.
+	index, low, on := map[string]int{}, map[string]int{}, map[string]bool{}
+	var stack []string
+	var visit func(string)
+	visit = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		on[v] = true
+		self := false
+		for _, w := range references(grm, v) {
+			if w == v {
+				self = true
+			}
+			if _, ok := index[w]; !ok {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if on[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+
+		var c component
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			on[w] = false
+			c.Productions = append(c.Productions, w)
+			if w == v {
+				break
+			}
+		}
+		sort.Strings(c.Productions)
+		c.Cycle = len(c.Productions) > 1 || self
+		r = append(r, c)
+	}
+	for _, name := range names {
+		if _, ok := index[name]; !ok {
+			visit(name)
+		}
+	}
+	return
+}
+
+// checkReachable returns an error if productions of grm are unreachable from
+// start, reported to stderr: the cycles no other production refers to, left
+// behind as a whole, as dead cycle groups, the other productions one by one.
+func checkReachable(grm ebnfutil.Grammar, start string) error {
+	seen := map[string]bool{start: true}
+	q := []string{start}
+	for len(q) != 0 {
+		v := q[0]
+		q = q[1:]
+		for _, w := range references(grm, v) {
+			if !seen[w] {
+				seen[w] = true
+				q = append(q, w)
+			}
+		}
+	}
+	dead := ebnfutil.Grammar{}
+	for name, prod := range grm {
+		if !seen[name] {
+			dead[name] = prod
+		}
+	}
+	if len(dead) == 0 {
+		return nil
+	}
+
+	comps := toposort(dead)
+	comp := map[string]int{}
+	for i, c := range comps {
+		for _, name := range c.Productions {
+			comp[name] = i
+		}
+	}
+	referred := map[int]bool{} // From outside of the component.
+	for name := range dead {
+		for _, ref := range references(dead, name) {
+			if comp[ref] != comp[name] {
+				referred[comp[ref]] = true
+			}
+		}
+	}
+	var list []violation
+	for i, c := range comps {
+		if !c.Cycle || referred[i] {
+			for _, name := range c.Productions {
+				list = append(list, violation{dead[name].Pos(), name + " is unreachable"})
+			}
+			continue
+		}
+
+		pos := dead[c.Productions[0]].Pos()
+		for _, name := range c.Productions[1:] {
+			if p := dead[name].Pos(); p.Offset < pos.Offset {
+				pos = p
+			}
+		}
+		list = append(list, violation{pos, fmt.Sprintf("dead cycle group %s: referring only to each other, unreachable from %s", strings.Join(c.Productions, ", "), start)})
+	}
+	sort.Stable(byOffset(list))
+	for _, v := range list {
+		wlog.Printf("%s: %s", v.pos, v.s)
+	}
+	return fmt.Errorf("%d production(s) unreachable from %s", len(dead), start)
+}
+
+// writeToposort writes r to w in the format selected by -toposort.
+func writeToposort(w io.Writer, r []component, format string) (err error) {
+	switch format {
+	case "text":
+		for _, c := range r {
+			if c.Cycle {
+				if _, err = fmt.Fprintf(w, "# cycle: %s\n", strings.Join(c.Productions, " ")); err != nil {
+					return
+				}
+			}
+			for _, name := range c.Productions {
+				if _, err = fmt.Fprintf(w, "%s\n", name); err != nil {
+					return
+				}
+			}
+		}
+		return
+	case "json":
+		if r == nil {
+			r = []component{}
+		}
+		b, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	default:
+		return fmt.Errorf("-toposort: unknown format %q, must be text or json", format)
+	}
+}
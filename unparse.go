@@ -0,0 +1,83 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"sort"
+
+	"github.com/cznic/strutil"
+)
+
+// renderUnparser writes a Go file with the function unparse, writing the
+// terminals of an AST node back as source. The literals are items of the
+// nodes built by the .y file actions, so a method per non terminal type
+// writes the items in order, delegating the ones which are nodes.
+func (j *job) renderUnparser(w io.Writer, start string) error {
+	j.checkTerminals(start)
+	sw := &stickyWriter{w: w}
+	f := strutil.IndentFormatter(sw, "\t")
+	generated(f, "AST unparser")
+	f.Format(`package %s
+
+import (
+	"fmt"
+	"io"
+)
+
+// unparse writes the terminals of the AST node n to w, separated by spaces.
+func unparse(w io.Writer, n interface{}) error {
+	u := &unparser{w: w}
+	u.node(n)
+	return u.err
+}
+
+type unparser struct {
+	w   io.Writer
+	err error
+	sep bool
+}
+
+func (u *unparser) token(s string) {
+	if u.err != nil {
+		return
+	}
+
+	if u.sep {
+		if _, u.err = io.WriteString(u.w, " "); u.err != nil {
+			return
+		}
+	}
+	_, u.err = io.WriteString(u.w, s)
+	u.sep = true
+}
+
+// node writes the AST node or token value n.
+func (u *unparser) node(n interface{}) {
+	switch x := n.(type) {
+	case nil:
+		// nop
+	case string:
+		u.token(x)
+`, j.pkg)
+	nts := []string{}
+	for name := range j.rep.NonTerminals {
+		nts = append(nts, name)
+	}
+	sort.Strings(nts)
+	f.Format("%i")
+	for _, name := range nts {
+		s := j.ruleName(name)
+		f.Format("case %s:%i\nu.unparse%s(x)%u\n", j.nodeType(name), s)
+	}
+	f.Format("default:%i\nu.token(fmt.Sprint(x))%u\n}%u\n}\n")
+	for _, name := range nts {
+		s := j.ruleName(name)
+		f.Format("\n")
+		j.doc(f, name)
+		f.Format("func (u *unparser) unparse%s(n %s) {%i\nfor _, v := range n {%i\nu.node(v)%u\n}%u\n}\n", s, j.nodeType(name))
+	}
+	return sw.err
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	reRuleHead = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*):\s*$`)
+	reStamp    = regexp.MustCompile(`(?m)^//( at |  \$ ).*\n`) // Time and command line.
+)
+
+// yaccSections splits b, a .y file, into the part up to and including the
+// first %% line, the rules and the rest, starting with the second %% line.
+func yaccSections(b []byte) (head, rules, tail []byte) {
+	var marks [][2]int
+	off := 0
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if string(bytes.TrimSpace(line)) == "%%" {
+			marks = append(marks, [2]int{off, off + len(line)})
+		}
+		off += len(line)
+	}
+	switch len(marks) {
+	case 0:
+		return b, nil, nil
+	case 1:
+		return b[:marks[0][1]], b[marks[0][1]:], nil
+	default:
+		return b[:marks[0][1]], b[marks[0][1]:marks[1][0]], b[marks[1][0]:]
+	}
+}
+
+// yaccRule is the text of the rules of a yacc non terminal, including the
+// comments before it.
+type yaccRule struct {
+	name string
+	text []byte
+}
+
+// yaccRules splits rules, the rules section of a .y file, into the text
+// before the first rule and the rules.
+func yaccRules(rules []byte) (pre []byte, a []yaccRule) {
+	lines := bytes.SplitAfter(rules, []byte("\n"))
+	start := func(i int) int {
+		for i > 0 {
+			s := bytes.TrimSpace(lines[i-1])
+			if !bytes.HasPrefix(s, []byte("//")) && !bytes.HasPrefix(s, []byte("/*")) {
+				break
+			}
+
+			i--
+		}
+		return i
+	}
+	var heads, starts []int
+	for i, line := range lines {
+		if reRuleHead.Match(line) {
+			heads = append(heads, i)
+			starts = append(starts, start(i))
+		}
+	}
+	if len(heads) == 0 {
+		return rules, nil
+	}
+
+	pre = bytes.Join(lines[:starts[0]], nil)
+	for k, h := range heads {
+		end := len(lines)
+		if k+1 < len(starts) {
+			end = starts[k+1]
+		}
+		a = append(a, yaccRule{string(reRuleHead.FindSubmatch(lines[h])[1]), bytes.Join(lines[starts[k]:end], nil)})
+	}
+	return
+}
+
+// grammarLines returns the lines of the rule text b, but for its actions.
+func grammarLines(b []byte) []byte {
+	var out []byte
+	in := false
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		switch s := string(bytes.TrimRight(line, " \t\r\n")); {
+		case s == "\t{":
+			in = true
+		case s == "\t}":
+			in = false
+		case !in:
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// sameRule reports whether a and b are the texts of the same rule, but for
+// their actions.
+func sameRule(a, b []byte) bool {
+	return bytes.Equal(grammarLines(a), grammarLines(b))
+}
+
+// update returns the .y file b, rendered anew, with the text of the rules
+// which did not change, actions included, and the prologue if only its time
+// stamp changed, taken from prev, the previous version of the file. The
+// changed rules are returned as well.
+func update(prev, b []byte) ([]byte, []string) {
+	head0, rules0, _ := yaccSections(prev)
+	head, rules, tail := yaccSections(b)
+	if bytes.Equal(reStamp.ReplaceAll(head0, nil), reStamp.ReplaceAll(head, nil)) {
+		head = head0
+	}
+
+	_, a0 := yaccRules(rules0)
+	m := map[string][]byte{}
+	for _, r := range a0 {
+		m[r.name] = r.text
+	}
+	pre, a := yaccRules(rules)
+	var out bytes.Buffer
+	var changed []string
+	out.Write(head)
+	out.Write(pre)
+	for _, r := range a {
+		switch text, ok := m[r.name]; {
+		case ok && sameRule(text, r.text):
+			out.Write(text)
+		default:
+			out.Write(r.text)
+			changed = append(changed, r.name)
+		}
+	}
+	out.Write(tail)
+	return out.Bytes(), changed
+}
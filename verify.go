@@ -0,0 +1,188 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	reLineDirective = regexp.MustCompile(`(?m)^[ \t]*//line .*\n`)
+	rePosRef        = regexp.MustCompile(`[^\s()]+\.[A-Za-z0-9_]+:\d+(:\d+)?`) // A grammar position, eg. x.ebnf:42.
+)
+
+// normalize returns b with the parts selected by the comma separated list
+// mode removed: stamp, the time stamp and command line of the prologue, and
+// lines, the //line directives and the grammar positions. Mode none selects
+// nothing.
+func normalize(b []byte, mode string) ([]byte, error) {
+	for _, v := range strings.Split(mode, ",") {
+		switch strings.TrimSpace(v) {
+		case "none", "":
+			// nop
+		case "stamp":
+			b = reStamp.ReplaceAll(b, nil)
+		case "lines":
+			b = reLineDirective.ReplaceAll(b, nil)
+			b = rePosRef.ReplaceAll(b, []byte("-"))
+		default:
+			return nil, fmt.Errorf("-verify-normalize: unknown %q, must be none, stamp or lines", v)
+		}
+	}
+	return b, nil
+}
+
+// verify compares b, the regenerated output, to the file fn, both normalized
+// by mode, and writes their differences, if any, to w. It reports whether
+// they are the same.
+func verify(w io.Writer, fn string, b []byte, mode string) (bool, error) {
+	old, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return false, err
+	}
+
+	if old, err = normalize(old, mode); err != nil {
+		return false, err
+	}
+
+	if b, err = normalize(b, mode); err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(old, b) {
+		return true, nil
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s (regenerated)\n", fn, fn)
+	diff(w, lines(old), lines(b))
+	return false, nil
+}
+
+func lines(b []byte) []string {
+	a := strings.SplitAfter(string(b), "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	return a
+}
+
+// edit is a line of a diff: kept (' '), removed ('-') or added ('+').
+type edit struct {
+	op   byte
+	line string
+}
+
+// diff writes the unified diff of a and b, with three lines of context, to w.
+func diff(w io.Writer, a, b []string) {
+	// Longest common subsequence of the lines between the common prefix
+	// and suffix.
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	x, y := a[p:len(a)-s], b[p:len(b)-s]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var e []edit
+	for _, v := range a[:p] {
+		e = append(e, edit{' ', v})
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			e = append(e, edit{' ', x[i]})
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			e = append(e, edit{'-', x[i]})
+			i++
+		default:
+			e = append(e, edit{'+', y[j]})
+			j++
+		}
+	}
+	for _, v := range a[len(a)-s:] {
+		e = append(e, edit{' ', v})
+	}
+
+	const context = 3
+	for k := 0; k < len(e); {
+		if e[k].op == ' ' {
+			k++
+			continue
+		}
+
+		// A hunk of the changes less than 2*context kept lines apart.
+		lo, hi := k-context, k
+		if lo < 0 {
+			lo = 0
+		}
+		for kept := 0; hi < len(e) && kept <= 2*context; hi++ {
+			switch e[hi].op {
+			case ' ':
+				kept++
+			default:
+				kept = 0
+			}
+		}
+		for hi > k && e[hi-1].op == ' ' {
+			hi--
+		}
+		if hi += context; hi > len(e) {
+			hi = len(e)
+		}
+		al, bl := 1, 1
+		for _, v := range e[:lo] {
+			if v.op != '+' {
+				al++
+			}
+			if v.op != '-' {
+				bl++
+			}
+		}
+		an, bn := 0, 0
+		for _, v := range e[lo:hi] {
+			if v.op != '+' {
+				an++
+			}
+			if v.op != '-' {
+				bn++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", al, an, bl, bn)
+		for _, v := range e[lo:hi] {
+			s := v.line
+			if !strings.HasSuffix(s, "\n") {
+				s += "\n\\ No newline at end of file\n"
+			}
+			fmt.Fprintf(w, "%c%s", v.op, s)
+		}
+		k = hi
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 The ebnf2y Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/scanner"
+
+	"github.com/cznic/ebnfutil"
+	"golang.org/x/exp/ebnf"
+)
+
+// symbol is a production or a literal with the positions defining it and
+// referring to it.
+type symbol struct {
+	Name    string   `json:"name"` // Literals are quoted.
+	Defined string   `json:"defined,omitempty"`
+	Refs    []string `json:"refs"`
+
+	refs []scanner.Position
+}
+
+type bySymbol []*symbol
+
+func (a bySymbol) Len() int { return len(a) }
+
+func (a bySymbol) Less(i, j int) bool {
+	if x, y := a[i].Defined != "", a[j].Defined != ""; x != y {
+		return x
+	}
+
+	return a[i].Name < a[j].Name
+}
+
+func (a bySymbol) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+type byPosition []scanner.Position
+
+func (a byPosition) Len() int           { return len(a) }
+func (a byPosition) Less(i, j int) bool { return a[i].Offset < a[j].Offset }
+func (a byPosition) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// xref returns the productions of grm, sorted by name, and the literals used
+// by them, sorted, with the positions referring to them in source order.
+func xref(grm ebnfutil.Grammar) (r []*symbol) {
+	m := map[string]*symbol{}
+	get := func(name string) *symbol {
+		s := m[name]
+		if s == nil {
+			s = &symbol{Name: name, Refs: []string{}}
+			m[name] = s
+		}
+		return s
+	}
+
+	for name, prod := range grm {
+		get(name).Defined = prod.Pos().String()
+		walk(prod.Expr, func(expr ebnf.Expression) {
+			switch x := expr.(type) {
+			case *ebnf.Name:
+				s := get(x.String)
+				s.refs = append(s.refs, x.Pos())
+			case *ebnf.Token:
+				s := get(strconv.Quote(x.String))
+				s.refs = append(s.refs, x.Pos())
+			}
+		})
+	}
+	for _, s := range m {
+		sort.Sort(byPosition(s.refs))
+		for _, v := range s.refs {
+			s.Refs = append(s.Refs, v.String())
+		}
+		r = append(r, s)
+	}
+	sort.Sort(bySymbol(r))
+	return
+}
+
+// writeXref writes r to w in the format selected by -xref.
+func writeXref(w io.Writer, r []*symbol, format string) (err error) {
+	switch format {
+	case "text":
+		for _, v := range r {
+			s := v.Name
+			if v.Defined != "" {
+				s += "\t" + v.Defined
+			}
+			if _, err = fmt.Fprintln(w, s); err != nil {
+				return
+			}
+
+			for _, ref := range v.Refs {
+				if _, err = fmt.Fprintf(w, "\t%s\n", ref); err != nil {
+					return
+				}
+			}
+		}
+		return
+	case "json":
+		if r == nil {
+			r = []*symbol{}
+		}
+		b, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	default:
+		return fmt.Errorf("-xref: unknown format %q, must be text or json", format)
+	}
+}